@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"video-script-bot/internal/config"
+	"video-script-bot/internal/storage"
+	"video-script-bot/internal/storage/postgres"
+	"video-script-bot/internal/storage/sqlite"
+)
+
+// openDatabase opens the backend chosen with DATABASE_DRIVER. With migrate
+// set the schema is left as it is, for the migrate command to manage.
+func openDatabase(cfg *config.Config, migrate bool) (storage.Repository, error) {
+	switch cfg.DatabaseDriver {
+	case "sqlite":
+		if migrate {
+			return sqlite.Open(cfg.DatabasePath)
+		}
+		return sqlite.New(cfg.DatabasePath, cfg.DatabaseAutoMigrate)
+	case "postgres":
+		if migrate {
+			return postgres.Open(cfg.DatabaseURL)
+		}
+		return postgres.New(cfg.DatabaseURL, cfg.DatabaseAutoMigrate)
+	default:
+		return nil, fmt.Errorf("unknown DATABASE_DRIVER '%s', use 'sqlite' or 'postgres'", cfg.DatabaseDriver)
+	}
+}
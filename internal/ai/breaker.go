@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"time"
+	"video-script-bot/internal/breaker"
+	"video-script-bot/internal/retry"
+)
+
+// GuardGenerator puts a circuit breaker in front of a script generator, so
+// requests fail fast with a *breaker.OpenError while the provider is down.
+func GuardGenerator(generator ScriptGenerator, b *breaker.Breaker) ScriptGenerator {
+	return &guardedGenerator{ScriptGenerator: generator, breaker: b}
+}
+
+type guardedGenerator struct {
+	ScriptGenerator
+	breaker *breaker.Breaker
+}
+
+func (g *guardedGenerator) GenerateScriptFromVideo(ctx context.Context, videoPath, mimeType string, videoDuration time.Duration, style string) (string, error) {
+	if err := g.breaker.Allow(); err != nil {
+		return "", err
+	}
+	script, err := g.ScriptGenerator.GenerateScriptFromVideo(ctx, videoPath, mimeType, videoDuration, style)
+	recordOutcome(ctx, g.breaker, err)
+	return script, err
+}
+
+func (g *guardedGenerator) ReviseScript(ctx context.Context, originalScript, instructions, style string, videoDuration time.Duration) (string, error) {
+	if err := g.breaker.Allow(); err != nil {
+		return "", err
+	}
+	script, err := g.ScriptGenerator.ReviseScript(ctx, originalScript, instructions, style, videoDuration)
+	recordOutcome(ctx, g.breaker, err)
+	return script, err
+}
+
+// GuardSynthesizer puts a circuit breaker in front of a speech provider.
+func GuardSynthesizer(synthesizer SpeechSynthesizer, b *breaker.Breaker) SpeechSynthesizer {
+	return &guardedSynthesizer{SpeechSynthesizer: synthesizer, breaker: b}
+}
+
+type guardedSynthesizer struct {
+	SpeechSynthesizer
+	breaker *breaker.Breaker
+}
+
+func (g *guardedSynthesizer) TextToSpeech(ctx context.Context, voiceID, text string, settings VoiceSettings) ([]byte, error) {
+	if err := g.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	audio, err := g.SpeechSynthesizer.TextToSpeech(ctx, voiceID, text, settings)
+	recordOutcome(ctx, g.breaker, err)
+	return audio, err
+}
+
+// recordOutcome reports a call to the breaker. Only network errors and server
+// errors count as failures: calls cancelled by the user, invalid scripts and
+// requests the provider rejected say nothing about whether it is up.
+func recordOutcome(ctx context.Context, b *breaker.Breaker, err error) {
+	var invalid *invalidScriptError
+	switch {
+	case err == nil:
+		b.Success()
+	case ctx.Err() != nil, errors.As(err, &invalid):
+		b.Skip()
+	case retry.ClassOf(err) == retry.Transient:
+		b.Failure()
+	default:
+		b.Skip()
+	}
+}
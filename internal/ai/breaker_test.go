@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+	"video-script-bot/internal/breaker"
+	"video-script-bot/internal/retry"
+)
+
+// failingGenerator fails every request with err.
+type failingGenerator struct {
+	err error
+}
+
+func (g *failingGenerator) Name() string                        { return "fake" }
+func (g *failingGenerator) Capabilities() GeneratorCapabilities { return GeneratorCapabilities{} }
+func (g *failingGenerator) Close() error                        { return nil }
+
+func (g *failingGenerator) GenerateScriptFromVideo(ctx context.Context, videoPath, mimeType string, videoDuration time.Duration, style string) (string, error) {
+	return "", g.err
+}
+
+func (g *failingGenerator) ReviseScript(ctx context.Context, originalScript, instructions, style string, videoDuration time.Duration) (string, error) {
+	return "", g.err
+}
+
+func TestGuardGeneratorCountsOnlyOutages(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		opens bool
+	}{
+		{"server error", retry.Mark(retry.Transient, errors.New("503 Service Unavailable")), true},
+		{"network error", &url.Error{Op: "Post", URL: "https://example.com", Err: io.EOF}, true},
+		{"invalid script", fmt.Errorf("gemini returned an invalid script 3 times: %w", &invalidScriptError{err: errors.New("line 1: end is not after start")}), false},
+		{"invalid script retried", retry.Mark(retry.Transient, &invalidScriptError{err: errors.New("no content")}), false},
+		{"client error", errors.New("400 Bad Request"), false},
+		{"keys exhausted", retry.Mark(retry.Permanent, errors.New("all keys were exhausted")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := breaker.New("fake", breaker.Settings{FailureThreshold: 2, OpenDuration: time.Minute})
+			generator := GuardGenerator(&failingGenerator{err: tt.err}, b)
+			for i := 0; i < 3; i++ {
+				generator.ReviseScript(context.Background(), "script", "shorter", "style", 0)
+			}
+			if opened := b.Status().State == breaker.Open; opened != tt.opens {
+				t.Fatalf("breaker open = %v after three %s failures, want %v", opened, tt.name, tt.opens)
+			}
+		})
+	}
+}
@@ -2,102 +2,82 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"strings"
-	"os"
 	"time"
+	"unicode/utf8"
 	"video-script-bot/internal/apikeys"
 	"video-script-bot/internal/models"
 	"video-script-bot/internal/proxy"
+	"video-script-bot/internal/retry"
 )
 
-const elevenLabsAPIURL = "https://api.elevenlabs.io/v1"
+// elevenLabsAPIURL is a variable so tests can point it at a local server.
+var elevenLabsAPIURL = "https://api.elevenlabs.io/v1"
 
-type ElevenLabsService struct {
-	keyManager *apikeys.KeyManager
-	proxyManager  *proxy.Manager
-	modelID    string
-	httpClient *http.Client
-	voices     []models.Voice
-	hasProxy      bool
-}
+// elevenLabsQuotaCooldown rests a key whose character quota has run out. The
+// quota only resets with the billing period, so the key is not retried soon.
+const elevenLabsQuotaCooldown = time.Hour
 
-func NewElevenLabsService(keyManager *apikeys.KeyManager, modelID string, proxyURL string) (*ElevenLabsService, error) {
-	transport := &http.Transport{} // <<< KODE BARU DIMULAI
+const elevenLabsTimeout = time.Minute * 2
 
-	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
-		}
-		transport.Proxy = http.ProxyURL(proxy)
-		log.Printf("ElevenLabs service is configured to use proxy: %s", proxyURL)
-	} // <<< KODE BARU BERAKHIR
+// ElevenLabsService calls the ElevenLabs API directly, or through the proxies
+// of proxyManager while it has any. httpClient is only used for direct
+// requests; proxied attempts get their own client from the proxy manager.
+// Rate-limited keys are swapped for another key at once, while network errors
+// and 5xx responses are retried with backoff on the next healthy proxy.
+type ElevenLabsService struct {
+	keyManager   *apikeys.KeyManager
+	proxyManager *proxy.Manager
+	modelID      string
+	httpClient   *http.Client
+	voices       []models.Voice
+	retryPolicy  retry.Policy
+}
 
+func NewElevenLabsService(keyManager *apikeys.KeyManager, modelID string, proxyManager *proxy.Manager, voices []models.Voice) (*ElevenLabsService, error) {
+	if proxyManager != nil {
+		log.Printf("ElevenLabs service is configured to use %d proxies.", proxyManager.GetTotalProxies())
+	}
 
 	service := &ElevenLabsService{
-		keyManager: keyManager,
-		modelID:    modelID,
-		httpClient: &http.Client{
-			Transport: transport, // <<< PERUBAHAN DI SINI
-			Timeout:   time.Minute * 2,
-		},
-	}
-	if err := service.loadVoicesFromFile("voices.json"); err != nil {
-		return nil, fmt.Errorf("failed to load voices from file: %w", err)
+		keyManager:   keyManager,
+		proxyManager: proxyManager,
+		modelID:      modelID,
+		httpClient:   &http.Client{Timeout: elevenLabsTimeout},
+		voices:       voices,
+		retryPolicy:  retry.Default,
 	}
 	return service, nil
-
 }
 
-func isNetworkError(err error) bool {
-	if err == nil {
-		return false
-	}
-	var netErr net.Error
-	if errors.As(err, &netErr) {
-		return true
-	}
-	return strings.Contains(err.Error(), "proxyconnect") || strings.Contains(err.Error(), "EOF")
-}
-
-func (s *ElevenLabsService) loadVoicesFromFile(filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	bytes, err := io.ReadAll(file)
-	if err != nil {
-		return err
-	}
-	var voicesFile models.VoicesFile
-	if err := json.Unmarshal(bytes, &voicesFile); err != nil {
-		return err
-	}
-	s.voices = voicesFile.Voices
-	return nil
+func (s *ElevenLabsService) Name() string {
+	return ProviderElevenLabs
 }
 
 func (s *ElevenLabsService) GetVoices() []models.Voice {
 	return s.voices
 }
 
-func (s *ElevenLabsService) TextToSpeech(voiceID, text string, stability, clarity, speed float32) ([]byte, error) {
+func (s *ElevenLabsService) AudioFormat() string {
+	return "mp3"
+}
+
+func (s *ElevenLabsService) TextToSpeech(ctx context.Context, voiceID, text string, settings VoiceSettings) ([]byte, error) {
 	apiURL := fmt.Sprintf("%s/text-to-speech/%s", elevenLabsAPIURL, voiceID)
 	payload := map[string]interface{}{
 		"text":     text,
 		"model_id": s.modelID,
 		"voice_settings": map[string]float32{
-			"stability":        stability,
-			"similarity_boost": clarity,
+			"stability":        settings.Stability,
+			"similarity_boost": settings.Clarity,
 			"style":            0.5, // Nilai default yang disarankan
 			"use_speaker_boost": 1,
 		},
@@ -110,72 +90,94 @@ func (s *ElevenLabsService) TextToSpeech(voiceID, text string, stability, clarit
 	}
 	jsonPayload, _ := json.Marshal(payload)
 
-	maxKeyRetries := len(s.keyManager.GetAllKeys())
-	for keyAttempt := 0; keyAttempt < maxKeyRetries; keyAttempt++ {
-		
-		maxProxyRetries := 1
-		if s.hasProxy {
-			maxProxyRetries = s.proxyManager.GetTotalProxies()
+	var failedKeys []string
+	var audioBytes []byte
+	err := s.retryPolicy.Do(ctx, func(attempt int) error {
+		lease, err := s.keyManager.Acquire(failedKeys...)
+		if err != nil {
+			return retry.Mark(retry.Permanent, fmt.Errorf("all ElevenLabs API keys and proxies failed or were exhausted: %w", err))
 		}
 
-		// Loop untuk mencoba setiap proxy
-		for proxyAttempt := 0; proxyAttempt < maxProxyRetries; proxyAttempt++ {
-			transport := &http.Transport{}
-			if s.hasProxy {
-				currentProxy := s.proxyManager.GetCurrentProxy()
-				if currentProxy != nil {
-					transport.Proxy = http.ProxyURL(currentProxy)
-				}
+		client := s.httpClient
+		var currentProxy *url.URL
+		if s.proxyManager != nil {
+			// A manager without proxies connects directly.
+			currentProxy = s.proxyManager.GetCurrentProxy()
+			if currentProxy != nil {
+				client = s.proxyManager.Client(currentProxy, elevenLabsTimeout)
+			} else if s.proxyManager.GetTotalProxies() > 0 {
+				err := fmt.Errorf("no healthy ElevenLabs proxies available")
+				lease.Fail(apikeys.ErrorClassNetwork, err)
+				return retry.Mark(retry.Permanent, err)
 			}
-			s.httpClient.Transport = transport
-
-			req, _ := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonPayload))
-			req.Header.Set("xi-api-key", s.keyManager.GetCurrentKey())
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Accept", "audio/mpeg")
+		}
 
-			resp, err := s.httpClient.Do(req)
+		req, _ := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("xi-api-key", lease.Key())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "audio/mpeg")
 
+		resp, err := client.Do(req)
+		if ctx.Err() != nil {
+			lease.Fail(apikeys.ErrorClassCanceled, ctx.Err())
+			return ctx.Err()
+		}
+		if err != nil {
 			// Jika error jaringan, ganti proxy dan coba lagi
-			if isNetworkError(err) {
-				log.Printf("Network/Proxy error during ElevenLabs request: %v", err)
-				if s.hasProxy {
-					s.proxyManager.RotateProxy()
-					continue 
-				}
-				time.Sleep(1 * time.Second)
-				continue
-			}
-			
-			if err != nil {
-				log.Printf("Unhandled error during ElevenLabs request: %v", err)
-				time.Sleep(1 * time.Second)
-				continue
+			log.Printf("Network/Proxy error during ElevenLabs request (attempt %d): %v", attempt, err)
+			if currentProxy != nil && retry.IsNetworkError(err) {
+				s.proxyManager.MarkFailed(currentProxy, err)
 			}
+			lease.Fail(apikeys.ErrorClassNetwork, err)
+			return err
+		}
+		defer resp.Body.Close()
+
+		// Jika error kuota, istirahatkan API key dan coba key lain
+		if resp.StatusCode == http.StatusTooManyRequests {
+			log.Printf("Quota error with ElevenLabs key (Status: %s). Trying another key.", resp.Status)
+			lease.Cooldown(s.keyManager.ParseRetryAfter(resp.Header.Get("Retry-After")), resp.Status)
+			failedKeys = append(failedKeys, lease.Key())
+			return retry.Mark(retry.Quota, fmt.Errorf("ElevenLabs returned %s", resp.Status))
+		}
 
-			// Jika error kuota, ganti API key dan coba lagi dari proxy pertama
-			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusUnauthorized {
-				log.Printf("Quota/Auth error with ElevenLabs key (Status: %s). Rotating key.", resp.Status)
-				resp.Body.Close()
-				s.keyManager.RotateKey()
-				break // Keluar dari loop proxy, masuk ke loop API key berikutnya
+		// ElevenLabs juga memakai 401 untuk kuota karakter yang habis
+		if resp.StatusCode == http.StatusUnauthorized {
+			body, _ := io.ReadAll(resp.Body)
+			failedKeys = append(failedKeys, lease.Key())
+			if strings.Contains(string(body), "quota_exceeded") {
+				log.Printf("ElevenLabs key is out of character quota. Trying another key.")
+				lease.Cooldown(elevenLabsQuotaCooldown, "character quota exceeded")
+				return retry.Mark(retry.Quota, fmt.Errorf("ElevenLabs character quota exceeded"))
 			}
+			reason := fmt.Sprintf("%s - %s", resp.Status, string(body))
+			lease.Disable(reason)
+			return retry.Mark(retry.Auth, errors.New(reason))
+		}
 
-			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				resp.Body.Close()
-				return nil, fmt.Errorf("ElevenLabs returned non-200 status: %s - %s", resp.Status, string(body))
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			err := fmt.Errorf("ElevenLabs returned non-200 status: %s - %s", resp.Status, string(body))
+			if retry.ClassForStatus(resp.StatusCode) == retry.Transient {
+				lease.Fail(apikeys.ErrorClassServer, err)
+				return retry.Mark(retry.Transient, err)
 			}
+			lease.Fail(apikeys.ErrorClassOther, err)
+			return err
+		}
 
-			// Jika berhasil, kembalikan hasil
-			audioBytes, err := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if err != nil {
-				return nil, fmt.Errorf("failed to read audio response body: %w", err)
-			}
-			return audioBytes, nil
+		// Jika berhasil, simpan hasilnya
+		audioBytes, err = io.ReadAll(resp.Body)
+		if err != nil {
+			lease.Fail(apikeys.ErrorClassNetwork, err)
+			return fmt.Errorf("failed to read audio response body: %w", err)
 		}
+		lease.SetUnits(utf8.RuneCountInString(text))
+		lease.Release()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, fmt.Errorf("all ElevenLabs API keys and proxies failed or were exhausted")
+	return audioBytes, nil
 }
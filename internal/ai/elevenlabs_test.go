@@ -0,0 +1,99 @@
+package ai
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/proxy"
+	"video-script-bot/internal/retry"
+)
+
+// closedProxyURL returns the URL of a proxy that refuses connections.
+func closedProxyURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	return "http://" + address
+}
+
+// Run with -race: concurrent requests share the proxy pool while a dead proxy
+// is ejected and the pool is reloaded.
+func TestElevenLabsConcurrentTextToSpeechThroughProxyPool(t *testing.T) {
+	apiURL := elevenLabsAPIURL
+	elevenLabsAPIURL = "http://elevenlabs.test/v1"
+	t.Cleanup(func() { elevenLabsAPIURL = apiURL })
+
+	// The HTTP proxy receives absolute-form requests and answers them itself
+	// in place of ElevenLabs.
+	var proxied atomic.Int64
+	liveProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Host != "elevenlabs.test" || !strings.HasPrefix(r.URL.Path, "/v1/text-to-speech/") {
+			t.Errorf("unexpected proxied request %s %s", r.Method, r.URL)
+		}
+		if !strings.HasPrefix(r.Header.Get("xi-api-key"), "key-") {
+			t.Errorf("request without an API key")
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("audio"))
+	}))
+	defer liveProxy.Close()
+	deadProxy := closedProxyURL(t)
+
+	pool, err := proxy.NewManager([]string{deadProxy, liveProxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := apikeys.NewManager(ProviderElevenLabs, []string{"key-1", "key-2", "key-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewElevenLabsService(keys, "model", pool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.retryPolicy = retry.Policy{MaxRetries: 5}
+
+	const requests = 32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			audio, err := service.TextToSpeech(context.Background(), "voice", "Hello there", VoiceSettings{Stability: 0.5, Clarity: 0.75})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(audio) != "audio" {
+				t.Errorf("got audio %q", audio)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, _, err := pool.Update([]string{deadProxy, liveProxy.URL}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if got := proxied.Load(); got != requests {
+		t.Errorf("the live proxy handled %d requests, want %d", got, requests)
+	}
+}
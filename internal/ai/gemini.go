@@ -2,122 +2,406 @@ package ai
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
+	"time"
 	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/proxy"
+	"video-script-bot/internal/retry"
 
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
 )
 
+// GeminiOptions controls how the Gemini service asks for and checks scripts.
+type GeminiOptions struct {
+	// JSONOutput asks the model for a JSON array of segments matching
+	// scriptResponseSchema instead of free text.
+	JSONOutput bool
+	// MaxValidationAttempts is how many invalid responses are tolerated before
+	// giving up. Each retry uses the next API key.
+	MaxValidationAttempts int
+	// Generation is the model chain and sampling settings used by default.
+	Generation GeminiGeneration
+	// StyleOverrides replaces parts of Generation for specific script styles,
+	// keyed by lower-case style name.
+	StyleOverrides map[string]GeminiGeneration
+	// InlineLimitBytes is the largest video sent inline. Larger videos are
+	// uploaded through the File API.
+	InlineLimitBytes int64
+	// Endpoint overrides the Gemini API endpoint, e.g. to point at a local
+	// stand-in server.
+	Endpoint string
+	// Proxy routes all Gemini traffic through a proxy; nil connects directly.
+	Proxy *proxy.Manager
+}
+
+// Safety thresholds for GeminiGeneration.SafetyThreshold. They apply to every
+// harm category.
+const (
+	SafetyBlockNone           = "block_none"
+	SafetyBlockOnlyHigh       = "block_only_high"
+	SafetyBlockMediumAndAbove = "block_medium_and_above"
+	SafetyBlockLowAndAbove    = "block_low_and_above"
+)
+
+// GeminiGeneration holds the model chain and sampling settings for Gemini
+// requests. Models are tried in order when one runs out of quota. Nil or empty
+// fields keep the model's own defaults.
+type GeminiGeneration struct {
+	Models          []string
+	Temperature     *float32
+	TopP            *float32
+	MaxOutputTokens *int32
+	SafetyThreshold string
+}
+
+// Merge returns g with every field that is set in override replaced.
+func (g GeminiGeneration) Merge(override GeminiGeneration) GeminiGeneration {
+	if len(override.Models) > 0 {
+		g.Models = override.Models
+	}
+	if override.Temperature != nil {
+		g.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		g.TopP = override.TopP
+	}
+	if override.MaxOutputTokens != nil {
+		g.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.SafetyThreshold != "" {
+		g.SafetyThreshold = override.SafetyThreshold
+	}
+	return g
+}
+
 type GeminiService struct {
-	keyManager *apikeys.KeyManager
+	keyManager  *apikeys.KeyManager
+	options     GeminiOptions
+	clients     *geminiClientPool
+	retryPolicy retry.Policy
 }
 
-func NewGeminiService(keyManager *apikeys.KeyManager) *GeminiService {
+func NewGeminiService(keyManager *apikeys.KeyManager, options GeminiOptions) (*GeminiService, error) {
+	if options.MaxValidationAttempts < 1 {
+		options.MaxValidationAttempts = 1
+	}
+	if options.InlineLimitBytes <= 0 {
+		options.InlineLimitBytes = defaultGeminiInlineLimit
+	}
+	if len(options.Generation.Models) == 0 {
+		options.Generation.Models = []string{"gemini-1.5-flash"}
+	}
+	clients := newGeminiClientPool(options.Proxy, options.Endpoint)
+	if keyManager != nil {
+		keyManager.OnRemove(clients.retire)
+	}
 	return &GeminiService{
-		keyManager: keyManager,
+		keyManager:  keyManager,
+		options:     options,
+		clients:     clients,
+		retryPolicy: scriptRetryPolicy,
+	}, nil
+}
+
+// Close closes the pooled genai clients. The service cannot be used afterwards.
+func (s *GeminiService) Close() error {
+	return s.clients.Close()
+}
+
+// scriptResponseSchema describes the JSON array returned in JSON output mode.
+var scriptResponseSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"start":   {Type: genai.TypeString, Description: "Segment start time in HH:MM:SS format."},
+			"end":     {Type: genai.TypeString, Description: "Segment end time in HH:MM:SS format, after start."},
+			"text":    {Type: genai.TypeString, Description: "Narration for this segment."},
+			"speaker": {Type: genai.TypeString, Description: "Optional speaker name.", Nullable: true},
+			"notes":   {Type: genai.TypeString, Description: "Optional production notes, not narrated.", Nullable: true},
+		},
+		Required: []string{"start", "end", "text"},
+	},
+}
+
+func (s *GeminiService) Name() string {
+	return ProviderGemini
+}
+
+func (s *GeminiService) Capabilities() GeneratorCapabilities {
+	return GeneratorCapabilities{VideoInput: true, MaxUploadBytes: geminiFileAPILimit}
+}
+
+func (s *GeminiService) GenerateScriptFromVideo(ctx context.Context, videoPath, mimeType string, videoDuration time.Duration, style string) (string, error) {
+	video, err := newVideoSource(videoPath, mimeType)
+	if err != nil {
+		return "", err
 	}
+	prompt := scriptPrompt(style, s.options.JSONOutput)
+
+	return s.generate(ctx, "script generation", style, videoDuration, video, prompt)
 }
 
-func (s *GeminiService) GenerateScriptFromVideo(ctx context.Context, videoData []byte, mimeType string, style string) (string, error) {
-	prompt := fmt.Sprintf(
-		"Analyze this video and create a concise, scene-by-scene script. The format must be exactly 'HH:MM:SS-HH:MM:SS: description'. The descriptions must be brief and directly correspond to the visual action in that video segment. Do not add information that is not present in the video. The requested style is: '%s'.",
-		style,
-	)
-	videoPart := genai.Blob{MIMEType: mimeType, Data: videoData}
+func (s *GeminiService) ReviseScript(ctx context.Context, originalScript, instructions, style string, videoDuration time.Duration) (string, error) {
+	prompt := revisionPrompt(originalScript, instructions, s.options.JSONOutput)
 
-	for i := 0; i < len(s.keyManager.GetAllKeys()); i++ {
-		if ctx.Err() != nil {
-			log.Printf("Context cancelled before attempting API call with key %d.", i+1)
-			return "", ctx.Err()
+	return s.generate(ctx, "script revision", style, videoDuration, nil, prompt)
+}
+
+// generationFor returns the default generation settings with any override for
+// style applied.
+func (s *GeminiService) generationFor(style string) GeminiGeneration {
+	override, ok := s.options.StyleOverrides[strings.ToLower(strings.TrimSpace(style))]
+	if !ok {
+		return s.options.Generation
+	}
+	return s.options.Generation.Merge(override)
+}
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	SafetyBlockNone:           genai.HarmBlockNone,
+	SafetyBlockOnlyHigh:       genai.HarmBlockOnlyHigh,
+	SafetyBlockMediumAndAbove: genai.HarmBlockMediumAndAbove,
+	SafetyBlockLowAndAbove:    genai.HarmBlockLowAndAbove,
+}
+
+// configureModel applies the sampling, safety and output settings to model.
+func (s *GeminiService) configureModel(model *genai.GenerativeModel, generation GeminiGeneration) {
+	if generation.Temperature != nil {
+		model.SetTemperature(*generation.Temperature)
+	}
+	if generation.TopP != nil {
+		model.SetTopP(*generation.TopP)
+	}
+	if generation.MaxOutputTokens != nil {
+		model.SetMaxOutputTokens(*generation.MaxOutputTokens)
+	}
+	if threshold, ok := safetyThresholds[generation.SafetyThreshold]; ok {
+		for _, category := range []genai.HarmCategory{
+			genai.HarmCategoryHarassment,
+			genai.HarmCategoryHateSpeech,
+			genai.HarmCategorySexuallyExplicit,
+			genai.HarmCategoryDangerousContent,
+		} {
+			model.SafetySettings = append(model.SafetySettings, &genai.SafetySetting{Category: category, Threshold: threshold})
 		}
+	}
+	if s.options.JSONOutput {
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = scriptResponseSchema
+	}
+}
+
+// generate leases a key from the pool for each attempt. With each key the
+// configured models are tried in order, falling back to the next model on a
+// quota error. Keys that are out of quota on every model cool down, keys that
+// fail authentication are disabled, transient server and network errors are
+// retried with backoff, and in JSON output mode responses that fail schema or
+// timestamp validation are retried with another key while one is healthy.
+func (s *GeminiService) generate(ctx context.Context, purpose, style string, videoDuration time.Duration, video *videoSource, prompt string) (string, error) {
+	generation := s.generationFor(style)
+	var failedKeys, invalidKeys []string
+	invalidResponses := 0
 
-		apiKey := s.keyManager.GetCurrentKey()
-		client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	var text string
+	err := s.retryPolicy.Do(ctx, func(attempt int) error {
+		lease, err := s.keyManager.Acquire(slices.Concat(failedKeys, invalidKeys)...)
+		if errors.Is(err, apikeys.ErrAllKeysExhausted) && len(invalidKeys) > 0 {
+			// No other key is healthy, so try again with one that returned an
+			// invalid script.
+			lease, err = s.keyManager.Acquire(failedKeys...)
+		}
 		if err != nil {
-			log.Printf("Failed to create genai client with key %d: %v. Rotating key.", i+1, err)
-			s.keyManager.RotateKey()
-			continue
+			return retry.Mark(retry.Permanent, fmt.Errorf("all Gemini API keys failed or were exhausted during %s: %w", purpose, err))
 		}
 
-		model := client.GenerativeModel("gemini-1.5-flash")
-		res, err := model.GenerateContent(ctx, videoPart, genai.Text(prompt))
+		text, err = s.attempt(ctx, lease, purpose, generation, videoDuration, video, prompt)
+		if err == nil {
+			lease.Release()
+			return nil
+		}
 
-		if err != nil {
+		var invalid *invalidScriptError
+		if ctx.Err() != nil {
+			lease.Fail(apikeys.ErrorClassCanceled, ctx.Err())
 			if ctx.Err() == context.Canceled {
-				log.Println("Gemini script generation cancelled by user.")
-				return "", err
+				log.Printf("Gemini %s cancelled by user.", purpose)
 			}
-			if isQuotaError(err) {
-				log.Printf("Quota error detected with Gemini key %d. Rotating key.", i+1)
-				s.keyManager.RotateKey()
-				continue
+			return ctx.Err()
+		}
+		if errors.As(err, &invalid) {
+			lease.Fail(apikeys.ErrorClassInvalidResponse, invalid.err)
+			invalidKeys = append(invalidKeys, lease.Key())
+			invalidResponses++
+			if invalidResponses >= s.options.MaxValidationAttempts {
+				return fmt.Errorf("gemini returned an invalid script %d times during %s: %w", invalidResponses, purpose, invalid)
 			}
-			return "", fmt.Errorf("gemini content generation failed with a non-quota error: %w", err)
+			log.Printf("Gemini returned an invalid script during %s (attempt %d of %d): %v. Retrying.", purpose, invalidResponses, s.options.MaxValidationAttempts, invalid.err)
+			return retry.Mark(retry.Transient, invalid)
 		}
 
-		return extractText(res)
+		switch class := classifyGeminiError(err); class {
+		case retry.Auth:
+			lease.Disable(err.Error())
+			failedKeys = append(failedKeys, lease.Key())
+			return retry.Mark(class, err)
+		case retry.Quota:
+			log.Printf("Quota error detected with a Gemini key during %s. Trying another key.", purpose)
+			lease.Cooldown(retryDelay(s.keyManager, err), err.Error())
+			failedKeys = append(failedKeys, lease.Key())
+			return retry.Mark(class, err)
+		case retry.Transient:
+			log.Printf("Transient Gemini error during %s (attempt %d): %v. Retrying.", purpose, attempt, err)
+			if retry.IsNetworkError(err) {
+				lease.Fail(apikeys.ErrorClassNetwork, err)
+			} else {
+				lease.Fail(apikeys.ErrorClassServer, err)
+			}
+			return retry.Mark(class, fmt.Errorf("gemini %s failed: %w", purpose, err))
+		default:
+			lease.Fail(apikeys.ErrorClassOther, err)
+			return fmt.Errorf("gemini %s failed with a non-retryable error: %w", purpose, err)
+		}
+	})
+	if err != nil {
+		return "", err
 	}
+	return text, nil
+}
 
-	return "", fmt.Errorf("all Gemini API keys failed or were exhausted")
+// invalidScriptError marks a response that arrived but did not hold a valid
+// script. It says nothing about the health of the provider or the key.
+type invalidScriptError struct {
+	err error
 }
 
-func (s *GeminiService) ReviseScript(ctx context.Context, originalScript, instructions string) (string, error) {
-	prompt := fmt.Sprintf(
-		"You are a script editor. Below is an original video script. Revise it based on the user's instructions. Maintain the exact 'HH:MM:SS-HH:MM:SS: description' format for every line. Keep the descriptions concise and relevant to the original script's context.\n\nOriginal Script:\n%s\n\nUser Instructions:\n%s",
-		originalScript,
-		instructions,
-	)
+func (e *invalidScriptError) Error() string {
+	return e.err.Error()
+}
 
-	for i := 0; i < len(s.keyManager.GetAllKeys()); i++ {
-		if ctx.Err() != nil {
-			log.Printf("Context cancelled before attempting API call with key %d.", i+1)
-			return "", ctx.Err()
-		}
-		
-		apiKey := s.keyManager.GetCurrentKey()
-		client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-		if err != nil {
-			log.Printf("Failed to create genai client with key %d for revision: %v. Rotating key.", i+1, err)
-			s.keyManager.RotateKey()
-			continue
-		}
+// attempt makes one request with the leased key, trying each configured model
+// in turn while they report quota errors.
+func (s *GeminiService) attempt(ctx context.Context, lease *apikeys.Lease, purpose string, generation GeminiGeneration, videoDuration time.Duration, video *videoSource, prompt string) (string, error) {
+	client, err := s.clients.get(lease.Key())
+	if err != nil {
+		return "", fmt.Errorf("failed to create genai client: %w", err)
+	}
+	defer s.clients.put(lease.Key())
 
-		model := client.GenerativeModel("gemini-1.5-flash")
-		res, err := model.GenerateContent(ctx, genai.Text(prompt))
+	parts, cleanup, err := s.requestParts(ctx, client, video, prompt)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
 
-		if err != nil {
-			if ctx.Err() == context.Canceled {
-				log.Println("Gemini script revision cancelled by user.")
-				return "", err
-			}
-			if isQuotaError(err) {
-				log.Printf("Quota error detected with Gemini key %d during revision. Rotating key.", i+1)
-				s.keyManager.RotateKey()
-				continue
-			}
-			return "", fmt.Errorf("gemini revision failed with a non-quota error: %w", err)
+	var res *genai.GenerateContentResponse
+	var usedModel string
+	for i, modelName := range generation.Models {
+		model := client.GenerativeModel(modelName)
+		s.configureModel(model, generation)
+		usedModel = modelName
+		res, err = model.GenerateContent(ctx, parts...)
+		if err == nil || ctx.Err() != nil || classifyGeminiError(err) != retry.Quota {
+			break
 		}
+		if i < len(generation.Models)-1 {
+			log.Printf("Quota error on Gemini model %s during %s. Falling back to %s.", modelName, purpose, generation.Models[i+1])
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if res.UsageMetadata != nil {
+		lease.SetUnits(int(res.UsageMetadata.TotalTokenCount))
+	}
+
+	text, err := s.scriptFromResponse(res, videoDuration)
+	if err != nil {
+		return "", &invalidScriptError{err: err}
+	}
+	reportModel(ctx, usedModel)
+	return text, nil
+}
 
-		return extractText(res)
+// scriptFromResponse returns the script text from the response. In JSON output
+// mode the response is validated and converted to the line-based format.
+func (s *GeminiService) scriptFromResponse(res *genai.GenerateContentResponse, videoDuration time.Duration) (string, error) {
+	text, err := extractText(res)
+	if err != nil || !s.options.JSONOutput {
+		return text, err
 	}
 
-	return "", fmt.Errorf("all Gemini API keys failed or were exhausted during revision")
+	return normalizeJSONScript(text, videoDuration)
 }
 
-func isQuotaError(err error) bool {
+// classifyGeminiError sorts a Gemini API error by its HTTP status, falling back
+// to the error text for errors that carry no status. A bad API key is found by
+// its text first: Gemini reports it as 400 Bad Request, which would otherwise
+// count as permanent and leave the key in rotation.
+func classifyGeminiError(err error) retry.Class {
 	errorString := strings.ToLower(err.Error())
-	return strings.Contains(errorString, "429") || strings.Contains(errorString, "quota") || strings.Contains(errorString, "limit exceeded")
+	if strings.Contains(errorString, "api key not valid") || strings.Contains(errorString, "api_key_invalid") {
+		return retry.Auth
+	}
+	if code := httpStatus(err); code != 0 {
+		return retry.ClassForStatus(code)
+	}
+	if class := retry.ClassOf(err); class != retry.Permanent {
+		return class
+	}
+	if strings.Contains(errorString, "429") || strings.Contains(errorString, "quota") || strings.Contains(errorString, "limit exceeded") {
+		return retry.Quota
+	}
+	return retry.Permanent
+}
+
+// httpStatus returns the HTTP status of a Gemini API error, or 0 if unknown.
+func httpStatus(err error) int {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPCode()
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code
+	}
+	return 0
+}
+
+// retryDelay returns how long Gemini asked us to wait before retrying, or 0.
+func retryDelay(keyManager *apikeys.KeyManager, err error) time.Duration {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if info := apiErr.Details().RetryInfo; info != nil && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return keyManager.ParseRetryAfter(googleErr.Header.Get("Retry-After"))
+	}
+	return 0
 }
 
 func extractText(res *genai.GenerateContentResponse) (string, error) {
-	if len(res.Candidates) == 0 || len(res.Candidates[0].Content.Parts) == 0 {
+	if len(res.Candidates) == 0 || res.Candidates[0].Content == nil || len(res.Candidates[0].Content.Parts) == 0 {
 		return "", fmt.Errorf("gemini returned no content")
 	}
-	if textPart, ok := res.Candidates[0].Content.Parts[0].(genai.Text); ok {
-		return string(textPart), nil
+
+	var builder strings.Builder
+	for _, part := range res.Candidates[0].Content.Parts {
+		if textPart, ok := part.(genai.Text); ok {
+			builder.WriteString(string(textPart))
+		}
+	}
+	if builder.Len() == 0 {
+		return "", fmt.Errorf("gemini response did not contain text")
 	}
-	return "", fmt.Errorf("gemini response did not contain text")
+	return builder.String(), nil
 }
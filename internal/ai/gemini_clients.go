@@ -0,0 +1,158 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"video-script-bot/internal/proxy"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// geminiClientPool keeps one genai client per API key so connections are
+// reused across requests instead of being opened for every attempt. All
+// clients share one HTTP transport. Clients of keys that were removed are
+// closed once the last request using them is done.
+type geminiClientPool struct {
+	mu        sync.Mutex
+	clients   map[string]*pooledClient
+	transport http.RoundTripper
+	endpoint  string
+	closed    bool
+}
+
+type pooledClient struct {
+	client *genai.Client
+	// users counts the requests using the client.
+	users   int
+	retired bool
+}
+
+func newGeminiClientPool(proxyManager *proxy.Manager, endpoint string) *geminiClientPool {
+	var transport http.RoundTripper
+	if proxyManager != nil {
+		transport = proxyManager.Transport()
+		log.Printf("Gemini service is configured to use %d proxy(ies).", proxyManager.GetTotalProxies())
+	} else {
+		direct := http.DefaultTransport.(*http.Transport).Clone()
+		direct.MaxIdleConnsPerHost = 32
+		transport = direct
+	}
+
+	return &geminiClientPool{
+		clients:   make(map[string]*pooledClient),
+		transport: transport,
+		endpoint:  endpoint,
+	}
+}
+
+// get returns the client for apiKey, creating it on first use. The caller
+// must call put with the key once it is done with the client.
+func (p *geminiClientPool) get(apiKey string) (*genai.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errors.New("gemini client pool is closed")
+	}
+	if pooled, ok := p.clients[apiKey]; ok {
+		// A key that was removed and added back keeps its client.
+		pooled.retired = false
+		pooled.users++
+		return pooled.client, nil
+	}
+
+	// The HTTP client carries the key for the REST clients; WithAPIKey is
+	// still needed for the cache client, which genai builds without it.
+	httpClient := &http.Client{Transport: &apiKeyTransport{apiKey: apiKey, base: p.transport}}
+	options := []option.ClientOption{option.WithAPIKey(apiKey), option.WithHTTPClient(httpClient)}
+	if p.endpoint != "" {
+		options = append(options, option.WithEndpoint(p.endpoint))
+	}
+
+	// Clients outlive the request that created them, so they are not tied to
+	// its context.
+	client, err := genai.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[apiKey] = &pooledClient{client: client, users: 1}
+	return client, nil
+}
+
+// put hands back a client taken with get, closing it if its key was removed
+// in the meantime and nobody else is using it.
+func (p *geminiClientPool) put(apiKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pooled, ok := p.clients[apiKey]
+	if !ok {
+		return
+	}
+	pooled.users--
+	if pooled.retired && pooled.users == 0 {
+		p.closeClient(apiKey, pooled)
+	}
+}
+
+// retire closes the clients of removed keys, waiting for requests that still
+// use them to finish.
+func (p *geminiClientPool) retire(apiKeys []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, apiKey := range apiKeys {
+		pooled, ok := p.clients[apiKey]
+		if !ok {
+			continue
+		}
+		pooled.retired = true
+		if pooled.users == 0 {
+			p.closeClient(apiKey, pooled)
+		}
+	}
+}
+
+// closeClient drops a client from the pool and closes it. The caller must hold
+// the mutex.
+func (p *geminiClientPool) closeClient(apiKey string, pooled *pooledClient) {
+	delete(p.clients, apiKey)
+	if err := pooled.client.Close(); err != nil {
+		log.Printf("Warning: failed to close Gemini client of a removed key: %v", err)
+	}
+}
+
+// Close closes every client and refuses new ones.
+func (p *geminiClientPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	var errs []error
+	for key, pooled := range p.clients {
+		if err := pooled.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(p.clients, key)
+	}
+	if transport, ok := p.transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	return errors.Join(errs...)
+}
+
+// apiKeyTransport adds the Gemini API key header to every request.
+type apiKeyTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.apiKey)
+	return t.base.RoundTrip(req)
+}
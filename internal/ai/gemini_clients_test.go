@@ -0,0 +1,29 @@
+package ai
+
+import "testing"
+
+func TestGeminiClientPoolClosesRemovedKeysWhenUnused(t *testing.T) {
+	pool := newGeminiClientPool(nil, "")
+	defer pool.Close()
+
+	if _, err := pool.get("busy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.get("idle"); err != nil {
+		t.Fatal(err)
+	}
+	pool.put("idle")
+
+	pool.retire([]string{"busy", "idle"})
+	if _, ok := pool.clients["idle"]; ok {
+		t.Error("the client of an unused removed key was kept")
+	}
+	if _, ok := pool.clients["busy"]; !ok {
+		t.Fatal("the client of a removed key was closed while in use")
+	}
+
+	pool.put("busy")
+	if _, ok := pool.clients["busy"]; ok {
+		t.Error("the client of a removed key was kept after its last request")
+	}
+}
@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	// geminiFileAPILimit is the largest file the Gemini File API accepts.
+	geminiFileAPILimit = 2 * 1024 * 1024 * 1024
+	// defaultGeminiInlineLimit keeps inline requests under Gemini's 20 MB
+	// request limit once the video is base64 encoded.
+	defaultGeminiInlineLimit  = 15 * 1024 * 1024
+	geminiFileProcessTimeout  = 10 * time.Minute
+	geminiFileCleanupDeadline = 30 * time.Second
+)
+
+// geminiFilePollInterval is how often an uploaded file is checked while
+// Gemini processes it.
+var geminiFilePollInterval = 2 * time.Second
+
+// videoSource is a video on disk to send along with a prompt.
+type videoSource struct {
+	path     string
+	mimeType string
+	size     int64
+}
+
+func newVideoSource(path, mimeType string) (*videoSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video file: %w", err)
+	}
+	return &videoSource{path: path, mimeType: mimeType, size: info.Size()}, nil
+}
+
+// requestParts builds the parts of a request for client. Videos up to the
+// inline limit are sent as a blob; larger ones are uploaded with the File API
+// and referenced by URI. The returned cleanup deletes the uploaded file and
+// must be called once the request is done.
+func (s *GeminiService) requestParts(ctx context.Context, client *genai.Client, video *videoSource, prompt string) ([]genai.Part, func(), error) {
+	noCleanup := func() {}
+	if video == nil {
+		return []genai.Part{genai.Text(prompt)}, noCleanup, nil
+	}
+
+	if video.size <= s.options.InlineLimitBytes {
+		data, err := os.ReadFile(video.path)
+		if err != nil {
+			return nil, noCleanup, fmt.Errorf("failed to read video file: %w", err)
+		}
+		return []genai.Part{genai.Blob{MIMEType: video.mimeType, Data: data}, genai.Text(prompt)}, noCleanup, nil
+	}
+
+	file, err := s.uploadVideo(ctx, client, video)
+	if err != nil {
+		return nil, noCleanup, err
+	}
+	cleanup := func() { s.deleteFile(client, file.Name) }
+	return []genai.Part{genai.FileData{MIMEType: file.MIMEType, URI: file.URI}, genai.Text(prompt)}, cleanup, nil
+}
+
+// uploadVideo streams the video to the File API and waits until Gemini has
+// finished processing it. The file is deleted again if processing fails.
+func (s *GeminiService) uploadVideo(ctx context.Context, client *genai.Client, video *videoSource) (*genai.File, error) {
+	f, err := os.Open(video.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video file: %w", err)
+	}
+	defer f.Close()
+
+	// The file is named here rather than by Gemini so it can be deleted even
+	// when UploadFile fails: the upload itself ignores ctx, and a cancelled
+	// ctx only fails the lookup that follows it.
+	name, err := newUploadName()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Uploading %d byte video to the Gemini File API.", video.size)
+	file, err := client.UploadFile(ctx, name, f, &genai.UploadFileOptions{MIMEType: video.mimeType})
+	if err != nil {
+		if ctx.Err() != nil {
+			s.deleteFile(client, name)
+		}
+		return nil, fmt.Errorf("failed to upload video to gemini: %w", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, geminiFileProcessTimeout)
+	defer cancel()
+	for file.State == genai.FileStateProcessing {
+		select {
+		case <-waitCtx.Done():
+			s.deleteFile(client, file.Name)
+			return nil, fmt.Errorf("gemini did not finish processing the video: %w", waitCtx.Err())
+		case <-time.After(geminiFilePollInterval):
+		}
+		name := file.Name
+		if file, err = client.GetFile(waitCtx, name); err != nil {
+			s.deleteFile(client, name)
+			return nil, fmt.Errorf("failed to check uploaded video state: %w", err)
+		}
+	}
+
+	if file.State != genai.FileStateActive {
+		s.deleteFile(client, file.Name)
+		if file.Error != nil {
+			return nil, fmt.Errorf("gemini could not process the video: %v", file.Error)
+		}
+		return nil, fmt.Errorf("gemini could not process the video (state %s)", file.State)
+	}
+	return file, nil
+}
+
+// newUploadName returns a random name for an uploaded file.
+func newUploadName() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to name the upload: %w", err)
+	}
+	return "video-" + hex.EncodeToString(random), nil
+}
+
+func (s *GeminiService) deleteFile(client *genai.Client, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), geminiFileCleanupDeadline)
+	defer cancel()
+	if err := client.DeleteFile(ctx, name); err != nil {
+		log.Printf("Warning: failed to delete uploaded Gemini file %s: %v", name, err)
+	}
+}
@@ -0,0 +1,189 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"video-script-bot/internal/apikeys"
+)
+
+// fakeFileAPI stands in for the Gemini File API and generateContent for one
+// uploaded file. It stays PROCESSING for processingPolls checks, or for good
+// when processingPolls is negative.
+type fakeFileAPI struct {
+	t               *testing.T
+	processingPolls int
+	// onPoll is called with the number of state checks so far on each check.
+	onPoll func(polls int)
+
+	mu        sync.Mutex
+	name      string
+	uploads   int
+	polls     int
+	generated []string
+	deleted   []string
+}
+
+func (f *fakeFileAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-goog-api-key") != "test-key" {
+		http.Error(w, `{"error":{"code":403,"message":"missing key"}}`, http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file := map[string]string{"name": f.name, "uri": "https://files.example/" + f.name, "mimeType": "video/mp4"}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/v1beta/files":
+		if !strings.Contains(string(body), "fake video") {
+			f.t.Errorf("upload does not carry the video: %q", body)
+		}
+		match := uploadName.FindSubmatch(body)
+		if match == nil {
+			f.t.Errorf("upload is not named: %q", body)
+			http.Error(w, "no name", http.StatusBadRequest)
+			return
+		}
+		f.name = string(match[1])
+		file["name"] = f.name
+		f.uploads++
+		file["state"] = "PROCESSING"
+		writeJSON(w, map[string]any{"file": file})
+	case r.Method == http.MethodGet && f.name != "" && r.URL.Path == "/v1beta/"+f.name:
+		f.polls++
+		file["state"] = "PROCESSING"
+		if f.processingPolls >= 0 && f.polls > f.processingPolls {
+			file["state"] = "ACTIVE"
+		}
+		if f.onPoll != nil {
+			f.onPoll(f.polls)
+		}
+		writeJSON(w, file)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, ":generateContent"):
+		f.generated = append(f.generated, string(body))
+		writeJSON(w, map[string]any{
+			"candidates": []any{map[string]any{
+				"content":      map[string]any{"role": "model", "parts": []any{map[string]string{"text": "00:00:00-00:00:05: Opening shot"}}},
+				"finishReason": "STOP",
+			}},
+			"usageMetadata": map[string]int{"totalTokenCount": 42},
+		})
+	case r.Method == http.MethodDelete && f.name != "" && r.URL.Path == "/v1beta/"+f.name:
+		f.deleted = append(f.deleted, f.name)
+		writeJSON(w, map[string]any{})
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+var uploadName = regexp.MustCompile(`"name":"(files/[a-z0-9-]+)"`)
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// newFileAPIService returns a Gemini service that uploads every video to api.
+func newFileAPIService(t *testing.T, api *fakeFileAPI) *GeminiService {
+	t.Helper()
+	interval := geminiFilePollInterval
+	geminiFilePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { geminiFilePollInterval = interval })
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	keys, err := apikeys.NewManager(ProviderGemini, []string{"test-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewGeminiService(keys, GeminiOptions{InlineLimitBytes: 1, Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Close() })
+	return service
+}
+
+func writeVideo(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, []byte("fake video"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGeminiFileAPIUploadsPollsGeneratesAndDeletes(t *testing.T) {
+	api := &fakeFileAPI{t: t, processingPolls: 2}
+	service := newFileAPIService(t, api)
+
+	text, err := service.GenerateScriptFromVideo(context.Background(), writeVideo(t), "video/mp4", 10*time.Second, "narrative")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "00:00:00-00:00:05: Opening shot" {
+		t.Errorf("got script %q", text)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.uploads != 1 {
+		t.Errorf("uploaded %d times, want 1", api.uploads)
+	}
+	if api.polls != 3 {
+		t.Errorf("checked the file state %d times, want 3", api.polls)
+	}
+	if len(api.generated) != 1 || !strings.Contains(api.generated[0], "https://files.example/files/video-") {
+		t.Errorf("generate requests do not reference the uploaded file: %q", api.generated)
+	}
+	if len(api.deleted) != 1 {
+		t.Errorf("deleted %d files, want 1", len(api.deleted))
+	}
+}
+
+// The first state check is made by UploadFile itself, the later ones while
+// waiting for processing to finish.
+func TestGeminiFileAPICancelledWhileProcessing(t *testing.T) {
+	for name, cancelOnPoll := range map[string]int{"during upload": 1, "while waiting": 2} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			api := &fakeFileAPI{t: t, processingPolls: -1, onPoll: func(polls int) {
+				if polls == cancelOnPoll {
+					cancel()
+				}
+			}}
+			service := newFileAPIService(t, api)
+
+			_, err := service.GenerateScriptFromVideo(ctx, writeVideo(t), "video/mp4", 10*time.Second, "narrative")
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want context.Canceled", err)
+			}
+
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			if api.uploads != 1 {
+				t.Errorf("uploaded %d times, want 1", api.uploads)
+			}
+			if len(api.generated) != 0 {
+				t.Errorf("generated %d times after the context was cancelled", len(api.generated))
+			}
+			if len(api.deleted) != 1 {
+				t.Errorf("deleted %d files, want 1", len(api.deleted))
+			}
+		})
+	}
+}
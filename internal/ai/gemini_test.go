@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"video-script-bot/internal/apikeys"
+)
+
+// Gemini answers a bad API key with 400 Bad Request, which must disable the
+// key rather than count as a permanent request error.
+func TestGeminiDisablesKeyRejectedWithBadRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT",` +
+			`"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"API_KEY_INVALID","domain":"googleapis.com"}]}}`))
+	}))
+	defer server.Close()
+
+	keys, err := apikeys.NewManager(ProviderGemini, []string{"bad-key", "other-bad-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewGeminiService(keys, GeminiOptions{Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+
+	if _, err := service.ReviseScript(context.Background(), "00:00:00-00:00:05: Opening shot", "shorter", "narrative", 10*time.Second); err == nil {
+		t.Fatal("revision with rejected keys succeeded")
+	}
+	for _, status := range keys.Status() {
+		if status.State != apikeys.KeyDisabled {
+			t.Errorf("key %s is %s, want disabled", status.Fingerprint, status.State)
+		}
+	}
+}
+
+// fakeScriptAPI answers generateContent with a broken script for the keys in
+// invalid and a valid JSON script for any other key, and records the key of
+// every request.
+type fakeScriptAPI struct {
+	invalid map[string]bool
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (f *fakeScriptAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("x-goog-api-key")
+	f.mu.Lock()
+	f.keys = append(f.keys, key)
+	f.mu.Unlock()
+
+	text := `[{"start":"00:00:00","end":"00:00:05","text":"Opening shot"}]`
+	if f.invalid[key] {
+		text = `[{"start":"00:00:05","end":"00:00:01","text":"Backwards"}]`
+	}
+	writeJSON(w, map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]string{"text": text}}},
+			"finishReason": "STOP",
+		}},
+	})
+}
+
+func (f *fakeScriptAPI) usedKeys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.keys)
+}
+
+func newScriptService(t *testing.T, api *fakeScriptAPI, keys ...string) (*GeminiService, *apikeys.KeyManager) {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	keyManager, err := apikeys.NewManager(ProviderGemini, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewGeminiService(keyManager, GeminiOptions{JSONOutput: true, MaxValidationAttempts: 3, Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.retryPolicy.InitialInterval = time.Millisecond
+	t.Cleanup(func() { service.Close() })
+	return service, keyManager
+}
+
+// A key that returned an invalid script is not used again while another key
+// is healthy, even if the other key is busier.
+func TestGeminiRetriesInvalidScriptWithAnotherKey(t *testing.T) {
+	api := &fakeScriptAPI{invalid: map[string]bool{"broken-key": true}}
+	service, keys := newScriptService(t, api, "broken-key", "good-key")
+
+	// Keep good-key busy so that least-in-flight rotation alone would pick
+	// broken-key again.
+	busy, err := keys.Acquire("broken-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Release()
+
+	if _, err := service.ReviseScript(context.Background(), "00:00:00-00:00:05: Opening shot", "shorter", "narrative", 10*time.Second); err != nil {
+		t.Fatalf("ReviseScript: %v", err)
+	}
+	if used := api.usedKeys(); !slices.Equal(used, []string{"broken-key", "good-key"}) {
+		t.Fatalf("requests used keys %q, want broken-key then good-key", used)
+	}
+}
+
+// With no other healthy key, the key that returned an invalid script is tried
+// again rather than giving up.
+func TestGeminiRetriesInvalidScriptWithSameKeyAsLastResort(t *testing.T) {
+	api := &fakeScriptAPI{invalid: map[string]bool{"only-key": true}}
+	service, _ := newScriptService(t, api, "only-key")
+
+	_, err := service.ReviseScript(context.Background(), "00:00:00-00:00:05: Opening shot", "shorter", "narrative", 10*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "invalid script 3 times") {
+		t.Fatalf("ReviseScript error = %v, want three invalid scripts", err)
+	}
+	if used := api.usedKeys(); len(used) != 3 {
+		t.Fatalf("made %d requests, want 3", len(used))
+	}
+}
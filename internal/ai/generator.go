@@ -0,0 +1,59 @@
+package ai
+
+import (
+	"context"
+	"time"
+	"video-script-bot/internal/retry"
+)
+
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+)
+
+// scriptRetryPolicy allows more time than retry.Default because a single
+// script request with a long video can take minutes.
+var scriptRetryPolicy = retry.Policy{
+	InitialInterval: time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.5,
+	MaxRetries:      3,
+	MaxElapsed:      15 * time.Minute,
+}
+
+// GeneratorCapabilities describes what a script generator can accept.
+type GeneratorCapabilities struct {
+	// VideoInput reports whether the provider can watch a video.
+	VideoInput bool
+	// MaxUploadBytes is the largest video the provider accepts, or 0 for no limit.
+	MaxUploadBytes int64
+}
+
+// ScriptGenerator writes and revises timestamped video scripts. Videos are
+// passed as a path to a local file so providers can stream large uploads. The
+// returned scripts use the 'HH:MM:SS-HH:MM:SS: description' format.
+type ScriptGenerator interface {
+	Name() string
+	Capabilities() GeneratorCapabilities
+	GenerateScriptFromVideo(ctx context.Context, videoPath, mimeType string, videoDuration time.Duration, style string) (string, error)
+	ReviseScript(ctx context.Context, originalScript, instructions, style string, videoDuration time.Duration) (string, error)
+	// Close releases connections held by the generator.
+	Close() error
+}
+
+type modelReportKey struct{}
+
+// WithModelReport returns a context in which a script generator reports the
+// model that wrote the script, and a function that returns it afterwards.
+func WithModelReport(ctx context.Context) (context.Context, func() string) {
+	var model string
+	return context.WithValue(ctx, modelReportKey{}, &model), func() string { return model }
+}
+
+// reportModel records the model that answered a request made with ctx.
+func reportModel(ctx context.Context, model string) {
+	if report, ok := ctx.Value(modelReportKey{}).(*string); ok {
+		*report = model
+	}
+}
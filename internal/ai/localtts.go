@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"video-script-bot/internal/models"
+)
+
+const (
+	LocalEngineEspeak = "espeak"
+	LocalEnginePiper  = "piper"
+)
+
+// LocalTTSService synthesizes speech offline with an espeak-ng or Piper
+// subprocess. For espeak the voice ID is an espeak voice name such as "en-us";
+// for Piper it is the name of a .onnx voice model in modelDir. Both produce
+// WAV audio.
+type LocalTTSService struct {
+	engine     string
+	binaryPath string
+	modelDir   string
+	voices     []models.Voice
+}
+
+// NewLocalTTSService returns a nil service and logs a warning when the engine
+// binary or its voices cannot be found, so the bot can start without it.
+func NewLocalTTSService(engine, binary, modelDir string, voices []models.Voice) (*LocalTTSService, error) {
+	switch engine {
+	case LocalEngineEspeak:
+		if binary == "" {
+			binary = "espeak-ng"
+		}
+	case LocalEnginePiper:
+		if binary == "" {
+			binary = "piper"
+		}
+	default:
+		return nil, fmt.Errorf("unknown local TTS engine '%s'", engine)
+	}
+
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		log.Printf("Warning: local TTS engine '%s' not found at '%s'. Local voices will be disabled.", engine, binary)
+		return nil, nil
+	}
+	if len(voices) == 0 {
+		log.Printf("Warning: no voices with provider '%s' in the voice catalog. Local voices will be disabled.", engine)
+		return nil, nil
+	}
+
+	return &LocalTTSService{engine: engine, binaryPath: path, modelDir: modelDir, voices: voices}, nil
+}
+
+func (s *LocalTTSService) Name() string {
+	return s.engine
+}
+
+func (s *LocalTTSService) GetVoices() []models.Voice {
+	return s.voices
+}
+
+func (s *LocalTTSService) AudioFormat() string {
+	return "wav"
+}
+
+func (s *LocalTTSService) TextToSpeech(ctx context.Context, voiceID, text string, settings VoiceSettings) ([]byte, error) {
+	speed := settings.Speed
+	if speed <= 0 {
+		speed = models.DefaultSpeed
+	}
+
+	var args []string
+	switch s.engine {
+	case LocalEngineEspeak:
+		// espeak-ng speaks at 175 words per minute by default.
+		wordsPerMinute := int(175 * speed)
+		args = []string{"-v", voiceID, "-s", strconv.Itoa(wordsPerMinute), "--stdout", "--stdin"}
+	case LocalEnginePiper:
+		lengthScale := strconv.FormatFloat(float64(1/speed), 'f', 3, 32)
+		modelPath := filepath.Join(s.modelDir, voiceID)
+		if !strings.HasSuffix(modelPath, ".onnx") {
+			modelPath += ".onnx"
+		}
+		args = []string{"--model", modelPath, "--length_scale", lengthScale, "--output_file", "-"}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.binaryPath, args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", s.engine, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s produced no audio", s.engine)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,272 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/proxy"
+	"video-script-bot/internal/retry"
+)
+
+// OpenAIOptions configures an OpenAI-compatible chat completions endpoint.
+type OpenAIOptions struct {
+	BaseURL string
+	Model   string
+	// VideoInput sends the video as a base64 data URL in a "video_url" content
+	// part. Only enable it for servers whose model accepts video.
+	VideoInput            bool
+	MaxUploadBytes        int64
+	JSONOutput            bool
+	MaxValidationAttempts int
+	// Proxy routes requests through a proxy; nil connects directly.
+	Proxy *proxy.Manager
+}
+
+// OpenAIService generates scripts through any server that implements the
+// OpenAI chat completions API, such as a local LLM server. The key manager is
+// optional; without it requests are sent unauthenticated.
+type OpenAIService struct {
+	keyManager  *apikeys.KeyManager
+	options     OpenAIOptions
+	httpClient  *http.Client
+	retryPolicy retry.Policy
+}
+
+func NewOpenAIService(keyManager *apikeys.KeyManager, options OpenAIOptions) *OpenAIService {
+	if options.MaxValidationAttempts < 1 {
+		options.MaxValidationAttempts = 1
+	}
+	options.BaseURL = strings.TrimRight(options.BaseURL, "/")
+	return &OpenAIService{
+		keyManager:  keyManager,
+		options:     options,
+		httpClient:  proxy.HTTPClient(options.Proxy, time.Minute*5),
+		retryPolicy: scriptRetryPolicy,
+	}
+}
+
+func (s *OpenAIService) Name() string {
+	return ProviderOpenAI
+}
+
+func (s *OpenAIService) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func (s *OpenAIService) Capabilities() GeneratorCapabilities {
+	return GeneratorCapabilities{VideoInput: s.options.VideoInput, MaxUploadBytes: s.options.MaxUploadBytes}
+}
+
+type chatMessage struct {
+	Role    string        `json:"role"`
+	Content []contentPart `json:"content"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	VideoURL *mediaURL `json:"video_url,omitempty"`
+}
+
+type mediaURL struct {
+	URL string `json:"url"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// openAIScriptFormat wraps the segment array in an object, because structured
+// output in the chat completions API requires an object at the top level.
+var openAIScriptFormat = map[string]interface{}{
+	"type": "json_schema",
+	"json_schema": map[string]interface{}{
+		"name":   "video_script",
+		"strict": true,
+		"schema": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"required":             []string{"segments"},
+			"properties": map[string]interface{}{
+				"segments": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type":                 "object",
+						"additionalProperties": false,
+						"required":             []string{"start", "end", "text"},
+						"properties": map[string]interface{}{
+							"start": map[string]string{"type": "string", "description": "Segment start time in HH:MM:SS format."},
+							"end":   map[string]string{"type": "string", "description": "Segment end time in HH:MM:SS format, after start."},
+							"text":  map[string]string{"type": "string", "description": "Narration for this segment."},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func (s *OpenAIService) GenerateScriptFromVideo(ctx context.Context, videoPath, mimeType string, videoDuration time.Duration, style string) (string, error) {
+	if !s.options.VideoInput {
+		return "", fmt.Errorf("the %s script provider is not configured for video input", s.Name())
+	}
+	videoData, err := os.ReadFile(videoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read video file: %w", err)
+	}
+
+	dataURL := fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(videoData))
+	message := chatMessage{
+		Role: "user",
+		Content: []contentPart{
+			{Type: "video_url", VideoURL: &mediaURL{URL: dataURL}},
+			{Type: "text", Text: scriptPrompt(style, s.options.JSONOutput)},
+		},
+	}
+	return s.generate(ctx, "script generation", videoDuration, message)
+}
+
+func (s *OpenAIService) ReviseScript(ctx context.Context, originalScript, instructions, style string, videoDuration time.Duration) (string, error) {
+	message := chatMessage{
+		Role:    "user",
+		Content: []contentPart{{Type: "text", Text: revisionPrompt(originalScript, instructions, s.options.JSONOutput)}},
+	}
+	return s.generate(ctx, "script revision", videoDuration, message)
+}
+
+// generate mirrors GeminiService.generate: keys that return 429 cool down,
+// keys that return 401 are disabled, network errors and 5xx responses are
+// retried with backoff, and invalid scripts in JSON output mode are retried.
+func (s *OpenAIService) generate(ctx context.Context, purpose string, videoDuration time.Duration, message chatMessage) (string, error) {
+	payload := map[string]interface{}{
+		"model":    s.options.Model,
+		"messages": []chatMessage{message},
+	}
+	if s.options.JSONOutput {
+		payload["response_format"] = openAIScriptFormat
+	}
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s request: %w", purpose, err)
+	}
+
+	var failedKeys []string
+	invalidResponses := 0
+
+	var text string
+	err = s.retryPolicy.Do(ctx, func(attempt int) error {
+		var lease *apikeys.Lease
+		if s.keyManager != nil {
+			var err error
+			if lease, err = s.keyManager.Acquire(failedKeys...); err != nil {
+				return retry.Mark(retry.Permanent, fmt.Errorf("all OpenAI-compatible API keys failed or were exhausted during %s: %w", purpose, err))
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", s.options.BaseURL+"/chat/completions", bytes.NewReader(jsonPayload))
+		if err != nil {
+			lease.Fail(apikeys.ErrorClassOther, err)
+			return fmt.Errorf("failed to build %s request: %w", purpose, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if lease != nil {
+			req.Header.Set("Authorization", "Bearer "+lease.Key())
+		}
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				lease.Fail(apikeys.ErrorClassCanceled, ctx.Err())
+				if ctx.Err() == context.Canceled {
+					log.Printf("OpenAI-compatible %s cancelled by user.", purpose)
+				}
+				return ctx.Err()
+			}
+			lease.Fail(apikeys.ErrorClassNetwork, err)
+			return fmt.Errorf("openai-compatible %s request failed: %w", purpose, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lease.Fail(apikeys.ErrorClassNetwork, err)
+			return fmt.Errorf("failed to read %s response: %w", purpose, err)
+		}
+
+		if lease != nil && resp.StatusCode == http.StatusTooManyRequests {
+			log.Printf("Quota error with an OpenAI-compatible key during %s (Status: %s). Trying another key.", purpose, resp.Status)
+			lease.Cooldown(s.keyManager.ParseRetryAfter(resp.Header.Get("Retry-After")), resp.Status)
+			failedKeys = append(failedKeys, lease.Key())
+			return retry.Mark(retry.Quota, fmt.Errorf("openai-compatible %s returned %s", purpose, resp.Status))
+		}
+		if lease != nil && resp.StatusCode == http.StatusUnauthorized {
+			lease.Disable(resp.Status)
+			failedKeys = append(failedKeys, lease.Key())
+			return retry.Mark(retry.Auth, fmt.Errorf("openai-compatible %s returned %s", purpose, resp.Status))
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("openai-compatible %s returned non-200 status: %s - %s", purpose, resp.Status, string(body))
+			if retry.ClassForStatus(resp.StatusCode) == retry.Transient {
+				lease.Fail(apikeys.ErrorClassServer, err)
+				return retry.Mark(retry.Transient, err)
+			}
+			lease.Fail(apikeys.ErrorClassOther, err)
+			return err
+		}
+
+		var units int
+		text, units, err = s.scriptFromResponse(body, videoDuration)
+		lease.SetUnits(units)
+		if err != nil {
+			lease.Fail(apikeys.ErrorClassInvalidResponse, err)
+			invalidResponses++
+			if invalidResponses >= s.options.MaxValidationAttempts {
+				return fmt.Errorf("openai-compatible provider returned an invalid script %d times during %s: %w", invalidResponses, purpose, &invalidScriptError{err: err})
+			}
+			log.Printf("OpenAI-compatible provider returned an invalid script during %s (attempt %d of %d): %v. Retrying.", purpose, invalidResponses, s.options.MaxValidationAttempts, err)
+			return retry.Mark(retry.Transient, &invalidScriptError{err: err})
+		}
+		lease.Release()
+		reportModel(ctx, s.options.Model)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// scriptFromResponse returns the script and the number of tokens used.
+func (s *OpenAIService) scriptFromResponse(body []byte, videoDuration time.Duration) (string, int, error) {
+	var response chatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", 0, fmt.Errorf("invalid chat completion response: %w", err)
+	}
+	units := response.Usage.TotalTokens
+	if len(response.Choices) == 0 || strings.TrimSpace(response.Choices[0].Message.Content) == "" {
+		return "", units, fmt.Errorf("openai-compatible provider returned no content")
+	}
+	text := response.Choices[0].Message.Content
+	if !s.options.JSONOutput {
+		return text, units, nil
+	}
+
+	script, err := normalizeJSONScript(text, videoDuration)
+	return script, units, err
+}
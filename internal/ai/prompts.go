@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"video-script-bot/internal/script"
+)
+
+func scriptPrompt(style string, jsonOutput bool) string {
+	if jsonOutput {
+		return fmt.Sprintf(
+			"Analyze this video and create a concise, scene-by-scene script. Return a JSON array of segments ordered by time. Each segment has 'start' and 'end' timestamps in 'HH:MM:SS' format and a 'text' description. Segments must not overlap and must not run past the end of the video. The descriptions must be brief and directly correspond to the visual action in that video segment. Do not add information that is not present in the video. The requested style is: '%s'.",
+			style,
+		)
+	}
+	return fmt.Sprintf(
+		"Analyze this video and create a concise, scene-by-scene script. The format must be exactly 'HH:MM:SS-HH:MM:SS: description'. The descriptions must be brief and directly correspond to the visual action in that video segment. Do not add information that is not present in the video. The requested style is: '%s'.",
+		style,
+	)
+}
+
+func revisionPrompt(originalScript, instructions string, jsonOutput bool) string {
+	if jsonOutput {
+		return fmt.Sprintf(
+			"You are a script editor. Below is an original video script in 'HH:MM:SS-HH:MM:SS: description' format. Revise it based on the user's instructions and return it as a JSON array of segments, each with 'start' and 'end' timestamps in 'HH:MM:SS' format and a 'text' description. Keep the segments ordered and non-overlapping. Keep the descriptions concise and relevant to the original script's context.\n\nOriginal Script:\n%s\n\nUser Instructions:\n%s",
+			originalScript,
+			instructions,
+		)
+	}
+	return fmt.Sprintf(
+		"You are a script editor. Below is an original video script. Revise it based on the user's instructions. Maintain the exact 'HH:MM:SS-HH:MM:SS: description' format for every line. Keep the descriptions concise and relevant to the original script's context.\n\nOriginal Script:\n%s\n\nUser Instructions:\n%s",
+		originalScript,
+		instructions,
+	)
+}
+
+// normalizeJSONScript validates a JSON script and converts it to the
+// line-based format. The prompts ask for a bare array of segments, but a
+// provider whose response format requires an object at the top level returns
+// the array as {"segments": [...]}, so both shapes are accepted.
+func normalizeJSONScript(text string, videoDuration time.Duration) (string, error) {
+	data := []byte(strings.TrimSpace(text))
+	if bytes.HasPrefix(data, []byte("{")) {
+		var wrapper struct {
+			Segments json.RawMessage `json:"segments"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return "", fmt.Errorf("invalid script JSON: %w", err)
+		}
+		if wrapper.Segments == nil {
+			return "", fmt.Errorf("invalid script JSON: no segments")
+		}
+		data = wrapper.Segments
+	}
+	parsed, err := script.ParseJSON(data)
+	if err != nil {
+		return "", err
+	}
+	if err := parsed.Validate(videoDuration); err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
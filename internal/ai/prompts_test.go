@@ -0,0 +1,28 @@
+package ai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeJSONScriptAcceptsBothShapes(t *testing.T) {
+	const want = "00:00:00-00:00:05: Opening shot\n00:00:05-00:00:09: Close-up"
+	segments := `[{"start":"00:00:00","end":"00:00:05","text":"Opening shot"},{"start":"00:00:05","end":"00:00:09","text":"Close-up"}]`
+
+	for name, text := range map[string]string{
+		"array":  segments,
+		"object": `{"segments":` + segments + `}`,
+	} {
+		got, err := normalizeJSONScript(text, 10*time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := normalizeJSONScript(`{"scenes":[]}`, 10*time.Second); err == nil {
+		t.Error("an object without segments was accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"video-script-bot/internal/models"
+)
+
+// ProviderElevenLabs is the provider assumed for voices that do not name one.
+const ProviderElevenLabs = "elevenlabs"
+
+// VoiceSettings are the user's synthesis parameters. Providers ignore the ones
+// they do not support.
+type VoiceSettings struct {
+	Stability float32
+	Clarity   float32
+	Speed     float32
+}
+
+// SpeechSynthesizer is a text-to-speech engine with its own voice catalog.
+type SpeechSynthesizer interface {
+	// Name is the provider name used in voices.json and user settings.
+	Name() string
+	// GetVoices returns the voices this provider can synthesize.
+	GetVoices() []models.Voice
+	// AudioFormat is the file extension of the audio returned by TextToSpeech.
+	AudioFormat() string
+	TextToSpeech(ctx context.Context, voiceID, text string, settings VoiceSettings) ([]byte, error)
+}
+
+// LoadVoiceCatalog reads the voice list from a voices.json file. Voices without
+// a provider are assigned to ElevenLabs.
+func LoadVoiceCatalog(filePath string) ([]models.Voice, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var voicesFile models.VoicesFile
+	if err := json.Unmarshal(data, &voicesFile); err != nil {
+		return nil, err
+	}
+	for i := range voicesFile.Voices {
+		if voicesFile.Voices[i].Provider == "" {
+			voicesFile.Voices[i].Provider = ProviderElevenLabs
+		}
+	}
+	return voicesFile.Voices, nil
+}
+
+// VoicesForProvider filters a catalog down to one provider's voices.
+func VoicesForProvider(catalog []models.Voice, provider string) []models.Voice {
+	var voices []models.Voice
+	for _, voice := range catalog {
+		if voice.Provider == provider {
+			voices = append(voices, voice)
+		}
+	}
+	return voices
+}
+
+// SpeechRegistry routes synthesis requests to the provider that owns a voice.
+type SpeechRegistry struct {
+	providers map[string]SpeechSynthesizer
+	order     []string
+}
+
+func NewSpeechRegistry(synthesizers ...SpeechSynthesizer) *SpeechRegistry {
+	registry := &SpeechRegistry{providers: make(map[string]SpeechSynthesizer)}
+	for _, synthesizer := range synthesizers {
+		if synthesizer == nil {
+			continue
+		}
+		registry.providers[synthesizer.Name()] = synthesizer
+		registry.order = append(registry.order, synthesizer.Name())
+	}
+	return registry
+}
+
+// Providers returns the registered provider names in registration order.
+func (r *SpeechRegistry) Providers() []string {
+	return r.order
+}
+
+// Voices returns the voices of one provider, or of every provider when the
+// name is empty or unknown.
+func (r *SpeechRegistry) Voices(provider string) []models.Voice {
+	if synthesizer, ok := r.providers[provider]; ok {
+		return synthesizer.GetVoices()
+	}
+	var voices []models.Voice
+	for _, name := range r.order {
+		voices = append(voices, r.providers[name].GetVoices()...)
+	}
+	return voices
+}
+
+// ForVoice finds the provider that owns the given voice ID.
+func (r *SpeechRegistry) ForVoice(voiceID string) (SpeechSynthesizer, models.Voice, error) {
+	for _, name := range r.order {
+		synthesizer := r.providers[name]
+		for _, voice := range synthesizer.GetVoices() {
+			if voice.VoiceID == voiceID {
+				return synthesizer, voice, nil
+			}
+		}
+	}
+	return nil, models.Voice{}, fmt.Errorf("no speech provider has voice %q", voiceID)
+}
+
+// FindVoiceByName looks a voice up by its display name, case-insensitively,
+// within one provider or all of them.
+func (r *SpeechRegistry) FindVoiceByName(provider, name string) (models.Voice, bool) {
+	for _, voice := range r.Voices(provider) {
+		voiceName := voice.Name
+		if voiceName == "" {
+			voiceName = voice.VoiceID
+		}
+		if strings.EqualFold(voiceName, name) {
+			return voice, true
+		}
+	}
+	return models.Voice{}, false
+}
+
+// Synthesize runs TextToSpeech on the provider that owns the voice and returns
+// the audio with its file extension.
+func (r *SpeechRegistry) Synthesize(ctx context.Context, voiceID, text string, settings VoiceSettings) ([]byte, string, error) {
+	synthesizer, _, err := r.ForVoice(voiceID)
+	if err != nil {
+		return nil, "", err
+	}
+	audio, err := synthesizer.TextToSpeech(ctx, voiceID, text, settings)
+	if err != nil {
+		return nil, "", err
+	}
+	return audio, synthesizer.AudioFormat(), nil
+}
@@ -3,58 +3,411 @@ package apikeys
 import (
 	"errors"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+	"video-script-bot/internal/breaker"
 )
 
 var ErrNoKeysAvailable = errors.New("no API keys available")
 var ErrAllKeysExhausted = errors.New("all available API keys have been exhausted")
 
-// KeyManager handles the rotation of API keys.
+// DefaultCooldown is how long a rate-limited key rests when the provider does
+// not say when to retry.
+const DefaultCooldown = time.Minute
+
+// KeyState is the health of a single key.
+type KeyState int
+
+const (
+	KeyHealthy KeyState = iota
+	KeyCoolingDown
+	KeyDisabled
+	// KeyCircuitOpen means the key's circuit breaker opened after repeated
+	// failures and the key is held back until a probe succeeds.
+	KeyCircuitOpen
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyHealthy:
+		return "healthy"
+	case KeyCoolingDown:
+		return "cooling down"
+	case KeyDisabled:
+		return "disabled"
+	case KeyCircuitOpen:
+		return "circuit open"
+	}
+	return "unknown"
+}
+
+type keyEntry struct {
+	key           string
+	fingerprint   string
+	disabled      bool
+	disabledWhy   string
+	cooldownUntil time.Time
+	inFlight      int
+	breaker       *breaker.Breaker
+}
+
+func (e *keyEntry) state(now time.Time) KeyState {
+	if e.disabled {
+		return KeyDisabled
+	}
+	if now.Before(e.cooldownUntil) {
+		return KeyCoolingDown
+	}
+	if !e.breaker.Ready() {
+		return KeyCircuitOpen
+	}
+	return KeyHealthy
+}
+
+// KeyManager hands out API keys to concurrent requests. Each key tracks its
+// own health: keys that hit a rate limit cool down for a while, and keys that
+// fail authentication are disabled. Keys that keep failing for other reasons
+// are held back by their circuit breaker. Requests take a Lease on a key
+// instead of sharing a cursor, so one request's failure does not skip keys for
+// others.
 type KeyManager struct {
-	keys         []string
-	currentIndex int
-	mutex        sync.Mutex
+	provider        string
+	keys            []*keyEntry
+	next            int
+	mutex           sync.Mutex
+	now             func() time.Time
+	recorder        UsageRecorder
+	breakerSettings breaker.Settings
+	onRemove        []func(keys []string)
 }
 
-// NewManager creates a new KeyManager.
-func NewManager(keys []string) (*KeyManager, error) {
-	if len(keys) == 0 || (len(keys) == 1 && keys[0] == "") {
+// NewManager creates a new KeyManager for the keys of one provider.
+func NewManager(provider string, keys []string) (*KeyManager, error) {
+	var entries []*keyEntry
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			entries = append(entries, newKeyEntry(provider, key, breaker.Settings{}))
+		}
+	}
+	if len(entries) == 0 {
 		return nil, ErrNoKeysAvailable
 	}
-	return &KeyManager{
-		keys:         keys,
-		currentIndex: 0,
-	}, nil
+	return &KeyManager{provider: provider, keys: entries, now: time.Now}, nil
 }
 
-// GetCurrentKey returns the currently active API key.
-func (km *KeyManager) GetCurrentKey() string {
+func newKeyEntry(provider, key string, settings breaker.Settings) *keyEntry {
+	fingerprint := Fingerprint(key)
+	return &keyEntry{key: key, fingerprint: fingerprint, breaker: breaker.New(provider+" key "+fingerprint, settings)}
+}
+
+// Update replaces the set of keys at runtime. Keys that stay keep their
+// cooldown, but disabled keys are re-enabled so a fixed key can be restored by
+// reloading. Requests holding a lease on a removed key finish with it. It
+// returns how many keys were added and removed.
+func (km *KeyManager) Update(keys []string) (added, removed int, err error) {
+	added, removedKeys, callbacks, err := km.replaceKeys(keys)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(removedKeys) > 0 {
+		for _, callback := range callbacks {
+			callback(removedKeys)
+		}
+	}
+	return added, len(removedKeys), nil
+}
+
+// replaceKeys does the work of Update under the mutex. It returns the removed
+// keys with the callbacks to tell about them, which run after unlocking.
+func (km *KeyManager) replaceKeys(keys []string) (added int, removedKeys []string, callbacks []func(keys []string), err error) {
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
-	return km.keys[km.currentIndex]
+
+	existing := make(map[string]*keyEntry, len(km.keys))
+	for _, entry := range km.keys {
+		existing[entry.key] = entry
+	}
+
+	var entries []*keyEntry
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if entry, ok := existing[key]; ok {
+			entry.disabled = false
+			entry.disabledWhy = ""
+			entries = append(entries, entry)
+			continue
+		}
+		entries = append(entries, newKeyEntry(km.provider, key, km.breakerSettings))
+		added++
+	}
+	if len(entries) == 0 {
+		return 0, nil, nil, ErrNoKeysAvailable
+	}
+
+	for _, entry := range km.keys {
+		if !seen[entry.key] {
+			removedKeys = append(removedKeys, entry.key)
+		}
+	}
+	km.keys = entries
+	km.next = 0
+	if added > 0 || len(removedKeys) > 0 {
+		log.Printf("%s API keys updated: %d added, %d removed, %d total.", km.provider, added, len(removedKeys), len(entries))
+	}
+	return added, removedKeys, km.onRemove, nil
 }
 
-// RotateKey moves to the next available API key.
-// It returns ErrAllKeysExhausted if it has looped through all available keys.
-func (km *KeyManager) RotateKey() error {
+// OnRemove registers callback to be called with the keys each Update removes,
+// so that resources held for them can be released.
+func (km *KeyManager) OnRemove(callback func(keys []string)) {
 	km.mutex.Lock()
 	defer km.mutex.Unlock()
+	km.onRemove = append(km.onRemove, callback)
+}
+
+// Provider returns the name of the provider the keys belong to.
+func (km *KeyManager) Provider() string {
+	return km.provider
+}
 
-	log.Printf("API key %d has failed or is exhausted. Rotating to the next key.", km.currentIndex+1)
-	km.currentIndex++
+// SetRecorder makes every finished lease report its outcome to recorder.
+func (km *KeyManager) SetRecorder(recorder UsageRecorder) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	km.recorder = recorder
+}
 
-	// If we've gone past the last key, we've exhausted all options.
-	if km.currentIndex >= len(km.keys) {
-		log.Println("WARNING: All API keys have been tried and failed.")
-		km.currentIndex = 0 // Reset to the first key for the next cycle of requests
-		return ErrAllKeysExhausted
+// SetBreaker gives every key a circuit breaker with settings. Breakers are
+// disabled until this is called.
+func (km *KeyManager) SetBreaker(settings breaker.Settings) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	km.breakerSettings = settings
+	for _, entry := range km.keys {
+		entry.breaker = breaker.New(km.provider+" key "+entry.fingerprint, settings)
 	}
+}
 
-	log.Printf("Switched to API key %d.", km.currentIndex+1)
-	return nil
+// KeyStatus is a snapshot of one key's health, safe to show to admins.
+type KeyStatus struct {
+	Fingerprint   string
+	State         KeyState
+	CooldownUntil time.Time
+	DisabledWhy   string
+	InFlight      int
+	// CircuitRetryAt is when an open circuit breaker lets a probe through.
+	CircuitRetryAt time.Time
 }
 
-// GetAllKeys is used for the retry logic to know how many keys to try.
-func (km *KeyManager) GetAllKeys() []string {
-	return km.keys
+// Status returns the current state of every key, in configuration order.
+func (km *KeyManager) Status() []KeyStatus {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	now := km.now()
+	statuses := make([]KeyStatus, 0, len(km.keys))
+	for _, entry := range km.keys {
+		statuses = append(statuses, KeyStatus{
+			Fingerprint:    entry.fingerprint,
+			State:          entry.state(now),
+			CooldownUntil:  entry.cooldownUntil,
+			DisabledWhy:    entry.disabledWhy,
+			InFlight:       entry.inFlight,
+			CircuitRetryAt: entry.breaker.Status().RetryAt,
+		})
+	}
+	return statuses
+}
+
+// Acquire leases a healthy key that is not in exclude. Among healthy keys it
+// prefers the one with the fewest requests in flight, taking turns on ties.
+// It returns ErrAllKeysExhausted when every key is excluded, cooling down or
+// disabled.
+func (km *KeyManager) Acquire(exclude ...string) (*Lease, error) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	now := km.now()
+	var best *keyEntry
+	for i := range km.keys {
+		entry := km.keys[(km.next+i)%len(km.keys)]
+		if entry.state(now) != KeyHealthy || contains(exclude, entry.key) {
+			continue
+		}
+		if best == nil || entry.inFlight < best.inFlight {
+			best = entry
+		}
+	}
+	if best == nil {
+		return nil, ErrAllKeysExhausted
+	}
+
+	km.next = (km.next + 1) % len(km.keys)
+	// The key was ready above and the mutex is held, so this only reserves the
+	// half-open probe.
+	best.breaker.Allow()
+	best.inFlight++
+	return &Lease{manager: km, entry: best, started: now}, nil
+}
+
+// Size returns the number of keys, whatever their state.
+func (km *KeyManager) Size() int {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	return len(km.keys)
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Lease is a key handed to one request. Exactly one of Release, Fail,
+// Cooldown or Disable should be called when the request is done; later calls
+// are ignored. Methods on a nil Lease do nothing, for providers that can run
+// without keys.
+type Lease struct {
+	manager  *KeyManager
+	entry    *keyEntry
+	started  time.Time
+	units    int
+	released bool
+}
+
+// Key returns the leased API key.
+func (l *Lease) Key() string {
+	if l == nil {
+		return ""
+	}
+	return l.entry.key
+}
+
+// SetUnits records how many characters or tokens the request used.
+func (l *Lease) SetUnits(units int) {
+	if l == nil {
+		return
+	}
+	l.manager.mutex.Lock()
+	defer l.manager.mutex.Unlock()
+	l.units = units
+}
+
+// Release returns the key to the pool after a successful request.
+func (l *Lease) Release() {
+	l.finish("", "", func(entry *keyEntry) { entry.breaker.Success() })
+}
+
+// Fail returns the key to the pool after a request that failed without the
+// provider blaming the key. Repeated network and server errors open the key's
+// circuit breaker; invalid responses, client errors and cancelled requests say
+// nothing about the key's health and are not counted.
+func (l *Lease) Fail(class string, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	l.finish(class, message, func(entry *keyEntry) {
+		if class == ErrorClassNetwork || class == ErrorClassServer {
+			entry.breaker.Failure()
+			return
+		}
+		entry.breaker.Skip()
+	})
+}
+
+// Cooldown returns the key and rests it for retryAfter, or DefaultCooldown if
+// retryAfter is not positive.
+func (l *Lease) Cooldown(retryAfter time.Duration, reason string) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultCooldown
+	}
+	l.finish(ErrorClassRateLimit, reason, func(entry *keyEntry) {
+		entry.breaker.Skip()
+		until := l.manager.now().Add(retryAfter)
+		if until.After(entry.cooldownUntil) {
+			entry.cooldownUntil = until
+		}
+		log.Printf("API key %s is rate limited. Cooling down until %s.", entry.fingerprint, until.Format(time.TimeOnly))
+	})
+}
+
+// Disable returns the key and takes it out of rotation, e.g. after an
+// authentication failure.
+func (l *Lease) Disable(reason string) {
+	l.finish(ErrorClassAuth, reason, func(entry *keyEntry) {
+		entry.breaker.Skip()
+		entry.disabled = true
+		entry.disabledWhy = reason
+		log.Printf("WARNING: API key %s has been disabled: %s", entry.fingerprint, reason)
+	})
+}
+
+// finish ends the lease, applies update to the key and reports the outcome to
+// the recorder. An empty errorClass means the request succeeded.
+func (l *Lease) finish(errorClass, message string, update func(*keyEntry)) {
+	if l == nil {
+		return
+	}
+	l.manager.mutex.Lock()
+	if l.released {
+		l.manager.mutex.Unlock()
+		return
+	}
+	l.released = true
+	l.entry.inFlight--
+	update(l.entry)
+	recorder := l.manager.recorder
+	usage := Usage{
+		Provider:    l.manager.provider,
+		Fingerprint: l.entry.fingerprint,
+		Success:     errorClass == "",
+		ErrorClass:  errorClass,
+		Error:       message,
+		Latency:     l.manager.now().Sub(l.started),
+		Units:       l.units,
+		At:          l.started,
+	}
+	l.manager.mutex.Unlock()
+
+	if recorder != nil {
+		recorder.RecordKeyUsage(usage)
+	}
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date. It returns 0 when the header is missing or malformed.
+func ParseRetryAfter(value string) time.Duration {
+	return parseRetryAfter(value, time.Now())
+}
+
+// ParseRetryAfter is ParseRetryAfter with HTTP dates measured against the
+// manager's clock.
+func (km *KeyManager) ParseRetryAfter(value string) time.Duration {
+	return parseRetryAfter(value, km.now())
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
 }
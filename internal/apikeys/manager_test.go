@@ -0,0 +1,288 @@
+package apikeys
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+	"video-script-bot/internal/breaker"
+)
+
+// fakeClock is a manually advanced time source for KeyManager.now.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestManager(t *testing.T, keys ...string) (*KeyManager, *fakeClock) {
+	t.Helper()
+	km, err := NewManager("test", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	km.now = clock.Now
+	return km, clock
+}
+
+func acquire(t *testing.T, km *KeyManager, exclude ...string) *Lease {
+	t.Helper()
+	lease, err := km.Acquire(exclude...)
+	if err != nil {
+		t.Fatalf("Acquire(%q): %v", exclude, err)
+	}
+	return lease
+}
+
+type recordedUsage struct {
+	mu     sync.Mutex
+	usages []Usage
+}
+
+func (r *recordedUsage) RecordKeyUsage(usage Usage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usages = append(r.usages, usage)
+}
+
+func TestNewManagerSkipsBlankKeys(t *testing.T) {
+	if _, err := NewManager("test", []string{" ", ""}); !errors.Is(err, ErrNoKeysAvailable) {
+		t.Fatalf("NewManager without keys = %v, want ErrNoKeysAvailable", err)
+	}
+	km, _ := newTestManager(t, " a ", "", "b")
+	if km.Size() != 2 {
+		t.Fatalf("Size = %d, want 2", km.Size())
+	}
+}
+
+func TestAcquirePrefersFewestInFlight(t *testing.T) {
+	km, _ := newTestManager(t, "a", "b", "c")
+
+	// Held leases spread over every key before any key gets a second one.
+	var held []*Lease
+	for _, want := range []string{"a", "b", "c"} {
+		lease := acquire(t, km)
+		if lease.Key() != want {
+			t.Fatalf("Acquire = %s, want %s", lease.Key(), want)
+		}
+		held = append(held, lease)
+	}
+
+	// Releasing b makes it the only key with nothing in flight.
+	held[1].Release()
+	if lease := acquire(t, km); lease.Key() != "b" {
+		t.Fatalf("Acquire after releasing b = %s, want b", lease.Key())
+	}
+}
+
+func TestAcquireTakesTurnsOnTies(t *testing.T) {
+	km, _ := newTestManager(t, "a", "b", "c")
+	var got []string
+	for i := 0; i < 5; i++ {
+		lease := acquire(t, km)
+		got = append(got, lease.Key())
+		lease.Release()
+	}
+	if fmt.Sprint(got) != "[a b c a b]" {
+		t.Fatalf("keys in turn = %v, want [a b c a b]", got)
+	}
+}
+
+func TestAcquireExclude(t *testing.T) {
+	km, _ := newTestManager(t, "a", "b")
+	for i := 0; i < 3; i++ {
+		lease := acquire(t, km, "a")
+		if lease.Key() != "b" {
+			t.Fatalf("Acquire excluding a = %s", lease.Key())
+		}
+		lease.Release()
+	}
+	if _, err := km.Acquire("a", "b"); !errors.Is(err, ErrAllKeysExhausted) {
+		t.Fatalf("Acquire excluding every key = %v, want ErrAllKeysExhausted", err)
+	}
+}
+
+func TestCooldownExpires(t *testing.T) {
+	km, clock := newTestManager(t, "a", "b")
+
+	acquire(t, km, "b").Cooldown(30*time.Second, "429")
+	status := km.Status()[0]
+	if status.State != KeyCoolingDown || !status.CooldownUntil.Equal(clock.Now().Add(30*time.Second)) {
+		t.Fatalf("status after Cooldown = %+v", status)
+	}
+	if _, err := km.Acquire("b"); !errors.Is(err, ErrAllKeysExhausted) {
+		t.Fatalf("Acquire of a cooling key = %v, want ErrAllKeysExhausted", err)
+	}
+
+	clock.Advance(29 * time.Second)
+	if _, err := km.Acquire("b"); !errors.Is(err, ErrAllKeysExhausted) {
+		t.Fatalf("Acquire before the cooldown ended = %v, want ErrAllKeysExhausted", err)
+	}
+	clock.Advance(time.Second)
+	if lease := acquire(t, km, "b"); lease.Key() != "a" {
+		t.Fatalf("Acquire after the cooldown = %s, want a", lease.Key())
+	}
+}
+
+func TestCooldownDefaultsAndNeverShortens(t *testing.T) {
+	km, clock := newTestManager(t, "a")
+
+	acquire(t, km).Cooldown(0, "429")
+	until := clock.Now().Add(DefaultCooldown)
+	if got := km.Status()[0].CooldownUntil; !got.Equal(until) {
+		t.Fatalf("CooldownUntil = %v, want the default cooldown %v", got, until)
+	}
+
+	clock.Advance(DefaultCooldown)
+	lease := acquire(t, km)
+	other := acquire(t, km)
+	lease.Cooldown(2*time.Minute, "429")
+	other.Cooldown(time.Second, "429")
+	if got, want := km.Status()[0].CooldownUntil, clock.Now().Add(2*time.Minute); !got.Equal(want) {
+		t.Fatalf("CooldownUntil = %v, want the longer cooldown %v", got, want)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	km, clock := newTestManager(t, "a", "b")
+
+	acquire(t, km, "b").Disable("401 Unauthorized")
+	clock.Advance(24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		lease := acquire(t, km)
+		if lease.Key() != "b" {
+			t.Fatalf("Acquire returned disabled key %s", lease.Key())
+		}
+		lease.Release()
+	}
+	status := km.Status()[0]
+	if status.State != KeyDisabled || status.DisabledWhy != "401 Unauthorized" {
+		t.Fatalf("status of the disabled key = %+v", status)
+	}
+
+	// Reloading the key enables it again.
+	if _, _, err := km.Update([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := km.Status()[0]; status.State != KeyHealthy {
+		t.Fatalf("status after Update = %+v, want healthy", status)
+	}
+}
+
+func TestLeaseFinishesOnce(t *testing.T) {
+	km, clock := newTestManager(t, "a")
+	recorder := &recordedUsage{}
+	km.SetRecorder(recorder)
+
+	lease := acquire(t, km)
+	lease.SetUnits(12)
+	clock.Advance(250 * time.Millisecond)
+	lease.Release()
+	lease.Disable("ignored")
+	lease.Fail(ErrorClassOther, errors.New("ignored"))
+
+	status := km.Status()[0]
+	if status.State != KeyHealthy || status.InFlight != 0 {
+		t.Fatalf("status after finishing twice = %+v", status)
+	}
+	if len(recorder.usages) != 1 {
+		t.Fatalf("recorded %d usages, want 1", len(recorder.usages))
+	}
+	usage := recorder.usages[0]
+	if !usage.Success || usage.Units != 12 || usage.Latency != 250*time.Millisecond || usage.Fingerprint != Fingerprint("a") {
+		t.Fatalf("recorded usage = %+v", usage)
+	}
+}
+
+func TestConcurrentLeases(t *testing.T) {
+	km, _ := newTestManager(t, "a", "b", "c", "d")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				lease, err := km.Acquire()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if i%8 == 0 && j%10 == 0 {
+					lease.Fail(ErrorClassNetwork, errors.New("reset"))
+				} else {
+					lease.Release()
+				}
+				km.Status()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, status := range km.Status() {
+		if status.InFlight != 0 || status.State != KeyHealthy {
+			t.Errorf("key %s after concurrent leases: %+v", status.Fingerprint, status)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	km, clock := newTestManager(t, "a")
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{clock.Now().Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := km.ParseRetryAfter(tt.value); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// Only network and server errors count towards a key's circuit breaker.
+func TestFailOpensBreakerOnlyForOutages(t *testing.T) {
+	tests := []struct {
+		class string
+		opens bool
+	}{
+		{ErrorClassNetwork, true},
+		{ErrorClassServer, true},
+		{ErrorClassInvalidResponse, false},
+		{ErrorClassOther, false},
+		{ErrorClassCanceled, false},
+	}
+	for _, tt := range tests {
+		km, _ := newTestManager(t, "a")
+		km.SetBreaker(breaker.Settings{FailureThreshold: 2, OpenDuration: time.Minute})
+		for i := 0; i < 3; i++ {
+			lease, err := km.Acquire()
+			if err != nil {
+				break
+			}
+			lease.Fail(tt.class, errors.New(tt.class))
+		}
+		if opened := km.Status()[0].State == KeyCircuitOpen; opened != tt.opens {
+			t.Errorf("circuit open = %v after three %s failures, want %v", opened, tt.class, tt.opens)
+		}
+	}
+}
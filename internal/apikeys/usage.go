@@ -0,0 +1,43 @@
+package apikeys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Error classes reported with failed requests. Network and server errors are
+// failures on the provider's side that may go away, such as 5xx responses.
+const (
+	ErrorClassRateLimit       = "rate_limit"
+	ErrorClassAuth            = "auth"
+	ErrorClassInvalidResponse = "invalid_response"
+	ErrorClassNetwork         = "network"
+	ErrorClassServer          = "server"
+	ErrorClassCanceled        = "canceled"
+	ErrorClassOther           = "error"
+)
+
+// Usage describes one request made with a leased key.
+type Usage struct {
+	Provider    string
+	Fingerprint string
+	Success     bool
+	ErrorClass  string
+	Error       string
+	Latency     time.Duration
+	// Units is the number of characters or tokens the request used, if known.
+	Units int
+	At    time.Time
+}
+
+// UsageRecorder stores the outcome of key usage, e.g. in the database.
+type UsageRecorder interface {
+	RecordKeyUsage(usage Usage)
+}
+
+// Fingerprint identifies a key in logs and statistics without revealing it.
+func Fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,214 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrFFmpegUnavailable = errors.New("ffmpeg is not installed or could not be found")
+
+// OverrunPolicy decides what happens when a clip is longer than its script slot.
+type OverrunPolicy string
+
+const (
+	// OverrunWarn keeps the clip as it is and reports the overrun.
+	OverrunWarn OverrunPolicy = "warn"
+	// OverrunStretch speeds the clip up so it fits its slot.
+	OverrunStretch OverrunPolicy = "stretch"
+)
+
+// ParseOverrunPolicy returns the policy for the given name, defaulting to OverrunWarn.
+func ParseOverrunPolicy(name string) OverrunPolicy {
+	if OverrunPolicy(strings.ToLower(strings.TrimSpace(name))) == OverrunStretch {
+		return OverrunStretch
+	}
+	return OverrunWarn
+}
+
+// Clip is a piece of narration placed on the timeline at Start. Slot is the
+// time available before the next line should begin.
+type Clip struct {
+	Start  time.Duration
+	Slot   time.Duration
+	Data   []byte
+	Format string
+}
+
+// Overrun describes a clip that is longer than its slot.
+type Overrun struct {
+	Index     int
+	Length    time.Duration
+	Slot      time.Duration
+	Stretched bool
+}
+
+// MixResult is the merged track and any overruns found while building it.
+type MixResult struct {
+	Data     []byte
+	Overruns []Overrun
+}
+
+// Mixer lays narration clips on a single timeline using ffmpeg.
+type Mixer struct {
+	ffmpegPath  string
+	ffprobePath string
+	policy      OverrunPolicy
+}
+
+// NewMixer looks up ffmpeg and ffprobe. A mixer whose binaries cannot be found
+// is still returned; Available reports false and Mix fails with ErrFFmpegUnavailable.
+func NewMixer(ffmpegPath, ffprobePath string, policy OverrunPolicy) *Mixer {
+	mixer := &Mixer{policy: policy}
+	if path, err := exec.LookPath(ffmpegPath); err == nil {
+		mixer.ffmpegPath = path
+	} else {
+		log.Printf("Warning: ffmpeg not found at '%s'. Merged narration tracks will be disabled.", ffmpegPath)
+	}
+	if path, err := exec.LookPath(ffprobePath); err == nil {
+		mixer.ffprobePath = path
+	} else {
+		log.Printf("Warning: ffprobe not found at '%s'. Merged narration tracks will be disabled.", ffprobePath)
+	}
+	return mixer
+}
+
+// Available reports whether ffmpeg and ffprobe were found.
+func (m *Mixer) Available() bool {
+	return m != nil && m.ffmpegPath != "" && m.ffprobePath != ""
+}
+
+// Mix places every clip at its start time, fills the gaps with silence and
+// returns one MP3 track that is exactly total long.
+func (m *Mixer) Mix(ctx context.Context, clips []Clip, total time.Duration) (*MixResult, error) {
+	if !m.Available() {
+		return nil, ErrFFmpegUnavailable
+	}
+	if len(clips) == 0 {
+		return nil, errors.New("no audio clips to mix")
+	}
+
+	workDir, err := os.MkdirTemp("", "narration-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	result := &MixResult{}
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	var filters []string
+	var labels []string
+
+	for i, clip := range clips {
+		clipPath := filepath.Join(workDir, fmt.Sprintf("clip_%03d.%s", i, clip.Format))
+		if err := os.WriteFile(clipPath, clip.Data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write clip %d: %w", i, err)
+		}
+		args = append(args, "-i", clipPath)
+
+		length, err := m.probeDuration(ctx, clipPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read length of clip %d: %w", i, err)
+		}
+
+		var chain []string
+		if clip.Slot > 0 && length > clip.Slot {
+			overrun := Overrun{Index: i, Length: length, Slot: clip.Slot}
+			if m.policy == OverrunStretch {
+				chain = append(chain, atempoChain(float64(length)/float64(clip.Slot))...)
+				overrun.Stretched = true
+			}
+			result.Overruns = append(result.Overruns, overrun)
+		}
+
+		delay := clip.Start.Milliseconds()
+		chain = append(chain, fmt.Sprintf("adelay=%d:all=1", delay))
+		label := fmt.Sprintf("a%d", i)
+		filters = append(filters, fmt.Sprintf("[%d:a]%s[%s]", i, strings.Join(chain, ","), label))
+		labels = append(labels, "["+label+"]")
+	}
+
+	filters = append(filters, fmt.Sprintf(
+		"%samix=inputs=%d:normalize=0:dropout_transition=0,apad,atrim=0:%s[out]",
+		strings.Join(labels, ""), len(clips), formatSeconds(total),
+	))
+
+	outputPath := filepath.Join(workDir, "narration.mp3")
+	args = append(args,
+		"-filter_complex", strings.Join(filters, ";"),
+		"-map", "[out]",
+		"-c:a", "libmp3lame", "-b:a", "128k",
+		outputPath,
+	)
+
+	if err := m.run(ctx, m.ffmpegPath, args...); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merged track: %w", err)
+	}
+	result.Data = data
+	return result, nil
+}
+
+func (m *Mixer) probeDuration(ctx context.Context, path string) (time.Duration, error) {
+	output, err := m.output(ctx, m.ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ffprobe output %q: %w", strings.TrimSpace(output), err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (m *Mixer) output(ctx context.Context, binary string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", filepath.Base(binary), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+func (m *Mixer) run(ctx context.Context, binary string, args ...string) error {
+	_, err := m.output(ctx, binary, args...)
+	return err
+}
+
+// atempoChain builds atempo filters for the given speed-up factor. Older
+// ffmpeg builds only accept 0.5-2.0 per filter, so larger factors are chained.
+func atempoChain(factor float64) []string {
+	var chain []string
+	for factor > 2.0 {
+		chain = append(chain, "atempo=2.0")
+		factor /= 2.0
+	}
+	chain = append(chain, fmt.Sprintf("atempo=%.4f", factor))
+	return chain
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
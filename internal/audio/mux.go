@@ -0,0 +1,147 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AudioMode decides how the narration is combined with the video's own audio.
+type AudioMode string
+
+const (
+	// AudioMix plays the narration over the original audio.
+	AudioMix AudioMode = "mix"
+	// AudioReplace drops the original audio.
+	AudioReplace AudioMode = "replace"
+)
+
+// SubtitleMode decides what happens with the subtitles.
+type SubtitleMode string
+
+const (
+	SubtitlesNone SubtitleMode = "none"
+	// SubtitlesBurn renders the subtitles into the picture. The video is re-encoded.
+	SubtitlesBurn SubtitleMode = "burn"
+	// SubtitlesAttach adds the subtitles as a selectable text track.
+	SubtitlesAttach SubtitleMode = "attach"
+)
+
+// MuxRequest describes the inputs for Mux. Subtitles holds SRT data and is
+// only used when SubtitleMode is not SubtitlesNone.
+type MuxRequest struct {
+	Video        []byte
+	Narration    []byte
+	Subtitles    []byte
+	AudioMode    AudioMode
+	SubtitleMode SubtitleMode
+}
+
+// Mux puts the narration track onto the video and returns an MP4.
+func (m *Mixer) Mux(ctx context.Context, req MuxRequest) ([]byte, error) {
+	if !m.Available() {
+		return nil, ErrFFmpegUnavailable
+	}
+
+	workDir, err := os.MkdirTemp("", "mux-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	videoPath := filepath.Join(workDir, "video")
+	narrationPath := filepath.Join(workDir, "narration.mp3")
+	subtitlePath := filepath.Join(workDir, "subtitles.srt")
+	outputPath := filepath.Join(workDir, "output.mp4")
+
+	if err := os.WriteFile(videoPath, req.Video, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write video: %w", err)
+	}
+	if err := os.WriteFile(narrationPath, req.Narration, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write narration: %w", err)
+	}
+	if req.SubtitleMode != SubtitlesNone {
+		if err := os.WriteFile(subtitlePath, req.Subtitles, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write subtitles: %w", err)
+		}
+	}
+
+	audioMode := req.AudioMode
+	if audioMode == AudioMix {
+		hasAudio, err := m.hasAudioStream(ctx, videoPath)
+		if err != nil {
+			return nil, err
+		}
+		if !hasAudio {
+			audioMode = AudioReplace
+		}
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", videoPath, "-i", narrationPath}
+	if req.SubtitleMode == SubtitlesAttach {
+		args = append(args, "-i", subtitlePath)
+	}
+
+	var videoFilter string
+	if req.SubtitleMode == SubtitlesBurn {
+		videoFilter = fmt.Sprintf("[0:v]subtitles=%s[v]", escapeFilterPath(subtitlePath))
+	}
+
+	var filters []string
+	videoMap := "0:v:0"
+	if videoFilter != "" {
+		filters = append(filters, videoFilter)
+		videoMap = "[v]"
+	}
+	audioMap := "1:a:0"
+	if audioMode == AudioMix {
+		filters = append(filters, "[0:a][1:a]amix=inputs=2:duration=first:normalize=0[a]")
+		audioMap = "[a]"
+	}
+	if len(filters) > 0 {
+		args = append(args, "-filter_complex", strings.Join(filters, ";"))
+	}
+
+	args = append(args, "-map", videoMap, "-map", audioMap)
+	if req.SubtitleMode == SubtitlesAttach {
+		args = append(args, "-map", "2:s:0", "-c:s", "mov_text")
+	}
+	if videoFilter != "" {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23")
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	args = append(args, "-c:a", "aac", "-b:a", "160k", "-movflags", "+faststart", outputPath)
+
+	if err := m.run(ctx, m.ffmpegPath, args...); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read muxed video: %w", err)
+	}
+	return data, nil
+}
+
+func (m *Mixer) hasAudioStream(ctx context.Context, path string) (bool, error) {
+	output, err := m.output(ctx, m.ffprobePath,
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0",
+		path,
+	)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(output) != "", nil
+}
+
+// escapeFilterPath quotes a path for use inside an ffmpeg filter argument.
+func escapeFilterPath(path string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(path)
+	return "'" + escaped + "'"
+}
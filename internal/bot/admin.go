@@ -0,0 +1,240 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/breaker"
+	"video-script-bot/internal/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// maxKeyErrorLength keeps long provider error bodies from flooding the report.
+const maxKeyErrorLength = 200
+
+func (b *Bot) isAdmin(userID int64) bool {
+	for _, adminID := range b.cfg.AdminUserIDs {
+		if adminID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleKeysCommand shows admins the health of every API key with its usage
+// for today and this month and the last error it returned.
+func (b *Bot) handleKeysCommand(chatID, userID int64) {
+	if !b.isAdmin(userID) {
+		b.sendErrorMessage(chatID, "admin_only")
+		return
+	}
+	if len(b.keyManagers) == 0 {
+		b.sendErrorMessage(chatID, "keys_report_no_keys")
+		return
+	}
+
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	today, err := b.db.KeyUsageSince(startOfDay)
+	if err == nil {
+		var month []storage.KeyUsageStats
+		var lastErrors []storage.KeyError
+		if month, err = b.db.KeyUsageSince(startOfMonth); err == nil {
+			if lastErrors, err = b.db.LastKeyErrors(); err == nil {
+				b.sendKeysReport(chatID, today, month, lastErrors)
+				return
+			}
+		}
+	}
+	log.Printf("Error loading API key statistics: %v", err)
+	b.sendErrorMessage(chatID, "keys_report_error")
+}
+
+func (b *Bot) sendKeysReport(chatID int64, today, month []storage.KeyUsageStats, lastErrors []storage.KeyError) {
+	header, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "keys_report_header"})
+	sections := []string{header}
+	if breakers := b.breakerReport(); breakers != "" {
+		sections = append(sections, breakers)
+	}
+
+	for _, keyManager := range b.keyManagers {
+		provider := keyManager.Provider()
+		for _, status := range keyManager.Status() {
+			todayStats := findKeyUsage(today, provider, status.Fingerprint)
+			monthStats := findKeyUsage(month, provider, status.Fingerprint)
+			section, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+				MessageID: "keys_report_key",
+				TemplateData: map[string]interface{}{
+					"Provider":      provider,
+					"Fingerprint":   status.Fingerprint,
+					"State":         keyStateLabel(status),
+					"TodayCalls":    todayStats.Calls,
+					"TodayFailures": todayStats.Failures,
+					"TodayUnits":    todayStats.Units,
+					"TodayLatency":  todayStats.AvgLatency.Round(time.Millisecond),
+					"MonthCalls":    monthStats.Calls,
+					"MonthFailures": monthStats.Failures,
+					"MonthUnits":    monthStats.Units,
+					"MonthLatency":  monthStats.AvgLatency.Round(time.Millisecond),
+				},
+			})
+
+			for _, keyError := range lastErrors {
+				if keyError.Provider != provider || keyError.Fingerprint != status.Fingerprint {
+					continue
+				}
+				message := truncate(keyError.Message, maxKeyErrorLength)
+				lastError, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+					MessageID: "keys_report_last_error",
+					TemplateData: map[string]string{
+						"Time":    keyError.At.Format("2006-01-02 15:04"),
+						"Class":   keyError.ErrorClass,
+						"Message": html.EscapeString(message),
+					},
+				})
+				section += "\n" + lastError
+			}
+			sections = append(sections, section)
+		}
+	}
+
+	// With many keys the report is sent in several messages, split between
+	// sections so no HTML tag is cut in half.
+	for _, text := range joinSections(sections, maxMessageLength) {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		b.api.Send(msg)
+	}
+}
+
+// joinSections joins sections with blank lines into as few texts of at most
+// limit bytes as it can. A section that is longer than limit on its own gets
+// a text of its own.
+func joinSections(sections []string, limit int) []string {
+	var texts []string
+	var current string
+	for _, section := range sections {
+		if current != "" && len(current)+len("\n\n")+len(section) > limit {
+			texts = append(texts, current)
+			current = ""
+		}
+		if current != "" {
+			current += "\n\n"
+		}
+		current += section
+	}
+	if current != "" {
+		texts = append(texts, current)
+	}
+	return texts
+}
+
+// breakerReport lists the provider circuit breakers, or returns "" when no
+// provider has been called yet.
+func (b *Bot) breakerReport() string {
+	var lines []string
+	for _, status := range b.breakers.Statuses() {
+		messageID := "keys_report_breaker"
+		if status.State != breaker.Closed {
+			messageID = "keys_report_breaker_open"
+		}
+		line, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID: messageID,
+			TemplateData: map[string]interface{}{
+				"Name":     status.Name,
+				"State":    status.State.String(),
+				"RetryAt":  status.RetryAt.Format("15:04:05"),
+				"Failures": status.Failures,
+			},
+		})
+		lines = append(lines, "- "+line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    "keys_report_breakers",
+		TemplateData: map[string]string{"Breakers": strings.Join(lines, "\n")},
+	})
+	return text
+}
+
+func findKeyUsage(stats []storage.KeyUsageStats, provider, fingerprint string) storage.KeyUsageStats {
+	for _, stat := range stats {
+		if stat.Provider == provider && stat.Fingerprint == fingerprint {
+			return stat
+		}
+	}
+	return storage.KeyUsageStats{}
+}
+
+func keyStateLabel(status apikeys.KeyStatus) string {
+	switch status.State {
+	case apikeys.KeyCoolingDown:
+		return fmt.Sprintf("%s until %s", status.State, status.CooldownUntil.Format("15:04:05"))
+	case apikeys.KeyDisabled:
+		return fmt.Sprintf("%s: %s", status.State, html.EscapeString(status.DisabledWhy))
+	case apikeys.KeyCircuitOpen:
+		return fmt.Sprintf("%s until %s", status.State, status.CircuitRetryAt.Format("15:04:05"))
+	}
+	return status.State.String()
+}
+
+// handleReloadCommand re-reads the reload file and applies new API keys and
+// proxies without restarting the bot.
+func (b *Bot) handleReloadCommand(chatID, userID int64) {
+	if !b.isAdmin(userID) {
+		b.sendErrorMessage(chatID, "admin_only")
+		return
+	}
+
+	changes, err := b.reloader.Reload()
+	if err != nil {
+		log.Printf("Admin %d reload failed: %v", userID, err)
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID:    "reload_error",
+			TemplateData: map[string]string{"File": b.cfg.ReloadFile, "Error": err.Error()},
+		})
+		b.api.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+	log.Printf("Admin %d reloaded %s: %d variable(s) changed.", userID, b.cfg.ReloadFile, len(changes))
+
+	if len(changes) == 0 {
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID:    "reload_no_changes",
+			TemplateData: map[string]string{"File": b.cfg.ReloadFile},
+		})
+		b.api.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+
+	var lines []string
+	for _, change := range changes {
+		var line string
+		if change.Err != nil {
+			line, _ = b.localizer.Localize(&i18n.LocalizeConfig{
+				MessageID:    "reload_change_failed",
+				TemplateData: map[string]string{"Variable": change.Variable, "Error": change.Err.Error()},
+			})
+		} else {
+			line, _ = b.localizer.Localize(&i18n.LocalizeConfig{
+				MessageID:    "reload_change",
+				TemplateData: map[string]interface{}{"Variable": change.Variable, "Added": change.Added, "Removed": change.Removed},
+			})
+		}
+		lines = append(lines, "- "+line)
+	}
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    "reload_changes",
+		TemplateData: map[string]string{"File": b.cfg.ReloadFile, "Changes": strings.Join(lines, "\n")},
+	})
+	b.api.Send(tgbotapi.NewMessage(chatID, text))
+}
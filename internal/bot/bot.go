@@ -2,32 +2,91 @@ package bot
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"sync"
+	"time"
 	"video-script-bot/internal/ai"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/audio"
+	"video-script-bot/internal/breaker"
 	"video-script-bot/internal/config"
+	"video-script-bot/internal/hotreload"
+	"video-script-bot/internal/jobs"
 	"video-script-bot/internal/models"
+	"video-script-bot/internal/retry"
 	"video-script-bot/internal/storage"
+	"video-script-bot/internal/workpool"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
+// HTTPClients are the clients the bot uses for the Telegram Bot API and for
+// downloading files from Telegram. A nil client means http.DefaultClient.
+type HTTPClients struct {
+	Telegram  *http.Client
+	Downloads *http.Client
+}
+
+// downloadRetryPolicy retries Telegram file downloads.
+var downloadRetryPolicy = retry.Policy{
+	InitialInterval: time.Second,
+	MaxInterval:     10 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.5,
+	MaxRetries:      3,
+	MaxElapsed:      2 * time.Minute,
+}
+
 type Bot struct {
 	api               *tgbotapi.BotAPI
 	cfg               *config.Config
 	localizer         *i18n.Localizer
-	db                *storage.Storage
-	geminiService     *ai.GeminiService
-	elevenlabsService *ai.ElevenLabsService
-	activeTasks       sync.Map
+	db                storage.Repository
+	scriptGenerator   ai.ScriptGenerator
+	speech            *ai.SpeechRegistry
+	mixer             *audio.Mixer
+	keyManagers       []*apikeys.KeyManager
+	reloader          *hotreload.Reloader
+	breakers          *breaker.Set
+	downloads         *http.Client
+	jobs              *jobs.Queue
+	updatePool        *workpool.Pool
+	aiPool            *workpool.Pool
+	ttsPool           *workpool.Pool
 	userLocks         sync.Map
+	inFlight          sync.WaitGroup
+
+	// workCtx is the parent of work that handlers start outside the job
+	// queue. Shutdown cancels it when that work runs past the timeout.
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	userWorkMu sync.Mutex
+	userWork   map[int64]*userWork
 }
 
-func New(cfg *config.Config, localizer *i18n.Localizer, db *storage.Storage, geminiService *ai.GeminiService, elevenlabsService *ai.ElevenLabsService) (*Bot, error) {
-	api, err := tgbotapi.NewBotAPI(cfg.TelegramBotToken)
+// userWork is the work a user started outside the job queue, which /cancel
+// stops.
+type userWork struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	active int
+}
+
+func New(cfg *config.Config, localizer *i18n.Localizer, db storage.Repository, scriptGenerator ai.ScriptGenerator, speech *ai.SpeechRegistry, mixer *audio.Mixer, keyManagers []*apikeys.KeyManager, reloader *hotreload.Reloader, breakers *breaker.Set, clients HTTPClients) (*Bot, error) {
+	if clients.Telegram == nil {
+		clients.Telegram = http.DefaultClient
+	}
+	if clients.Downloads == nil {
+		clients.Downloads = http.DefaultClient
+	}
+
+	api, err := tgbotapi.NewBotAPIWithClient(cfg.TelegramBotToken, tgbotapi.APIEndpoint, clients.Telegram)
 	if err != nil {
 		return nil, err
 	}
@@ -35,17 +94,31 @@ func New(cfg *config.Config, localizer *i18n.Localizer, db *storage.Storage, gem
 	api.Debug = false
 	log.Printf("Authorized on account %s", api.Self.UserName)
 
+	workCtx, cancelWork := context.WithCancel(context.Background())
 	bot := &Bot{
 		api:               api,
 		cfg:               cfg,
 		localizer:         localizer,
 		db:                db,
-		geminiService:     geminiService,
-		elevenlabsService: elevenlabsService,
-		activeTasks:       sync.Map{},
+		scriptGenerator:   scriptGenerator,
+		speech:            speech,
+		mixer:             mixer,
+		keyManagers:       keyManagers,
+		reloader:          reloader,
+		breakers:          breakers,
+		downloads:         clients.Downloads,
+		jobs:              jobs.NewQueue(db, cfg.JobWorkers, cfg.JobMaxAttempts),
+		updatePool:        workpool.New("update", cfg.UpdateWorkers, cfg.UpdateQueue),
+		aiPool:            workpool.New("AI", cfg.AIWorkers, cfg.AIQueue),
+		ttsPool:           workpool.New("TTS", cfg.TTSWorkers, cfg.TTSQueue),
 		userLocks:         sync.Map{},
+		workCtx:           workCtx,
+		cancelWork:        cancelWork,
+		userWork:          make(map[int64]*userWork),
 	}
 
+	bot.registerJobHandlers()
+
 	if err := bot.setCommands(); err != nil {
 		log.Printf("Warning: Failed to set bot commands: %v", err)
 	}
@@ -60,6 +133,8 @@ func (b *Bot) setCommands() error {
 		{Command: "voice", Description: "Ubah teks menjadi audio"},
 		{Command: "listvoices", Description: "Tampilkan daftar suara"},
 		{Command: "help", Description: "Tampilkan pesan bantuan"},
+		{Command: "projects", Description: "Kelola proyek video"},
+		{Command: "history", Description: "Riwayat versi naskah"},
 		{Command: "cancel", Description: "Batalkan proses saat ini"},
 	}
 	config := tgbotapi.NewSetMyCommands(commands...)
@@ -67,90 +142,251 @@ func (b *Bot) setCommands() error {
 	return err
 }
 
-func (b *Bot) Start() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+// Start handles updates and runs jobs until ctx is cancelled, then shuts down
+// gracefully: it stops receiving updates and gives handlers and jobs that are
+// already running the shutdown timeout to finish. Jobs still running after
+// that are stopped, put back in the queue and their users told. It fails only
+// when updates cannot be received at all.
+func (b *Bot) Start(ctx context.Context) error {
+	updates, stopUpdates, err := b.receiveUpdates()
+	if err != nil {
+		return err
+	}
 
-	updates := b.api.GetUpdatesChan(u)
+	// The job queue stops taking jobs when either ctx ends or the update
+	// channel closes.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
 
-	for update := range updates {
-		go func(upd tgbotapi.Update) {
-			if upd.InlineQuery != nil {
-				b.handleInlineQuery(upd.InlineQuery)
-				return
-			}
+	jobsDone := make(chan []*jobs.Job, 1)
+	go func() {
+		jobsDone <- b.jobs.Run(ctx)
+	}()
 
-			var userID int64
-			var chatID int64
-			var isCallback bool
-
-			if upd.CallbackQuery != nil {
-				userID = upd.CallbackQuery.From.ID
-				chatID = upd.CallbackQuery.Message.Chat.ID
-				isCallback = true
-			} else if upd.Message != nil {
-				userID = upd.Message.From.ID
-				chatID = upd.Message.Chat.ID
-			} else {
-				return
+	for {
+		select {
+		case <-ctx.Done():
+			stopUpdates()
+			b.shutdown(jobsDone)
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				log.Println("Warning: Telegram update channel closed, shutting down.")
+				stop()
+				b.shutdown(jobsDone)
+				return nil
 			}
-
-			mu, _ := b.userLocks.LoadOrStore(userID, &sync.Mutex{})
-			userMutex := mu.(*sync.Mutex)
-			userMutex.Lock()
-			defer userMutex.Unlock()
-
-			userData, err := b.db.GetUserData(userID)
+			var onQueued func(position int)
+			if chat := update.FromChat(); chat != nil {
+				onQueued = b.notifyQueuePosition(chat.ID)
+			}
+			b.inFlight.Add(1)
+			err := b.updatePool.Go(context.Background(), onQueued, func() {
+				defer b.inFlight.Done()
+				b.handleUpdate(update)
+			})
 			if err != nil {
-				log.Printf("FATAL: Could not get or create user data for user %d: %v", userID, err)
-				b.sendErrorMessage(chatID, "database_error")
-				return
+				b.inFlight.Done()
 			}
-
-			if isCallback {
-				b.handleCallbackQuery(upd.CallbackQuery, userData)
-				return
+			if errors.Is(err, workpool.ErrQueueFull) {
+				log.Printf("Warning: update queue is full, dropping update %d.", update.UpdateID)
+				if chat := update.FromChat(); chat != nil {
+					b.sendErrorMessage(chat.ID, "bot_busy")
+				}
 			}
+		}
+	}
+}
 
-			if upd.Message != nil {
-				log.Printf("Received message from [ID: %d] with state [%s]", userID, userData.State)
-				if upd.Message.IsCommand() {
-					b.handleCommand(upd.Message, userData)
-					return
-				}
+// shutdown waits up to ShutdownTimeoutSeconds for jobs and update handlers to
+// finish, then cancels whatever is left and waits for it to stop, so storage
+// can be closed safely once Start returns.
+func (b *Bot) shutdown(jobsDone <-chan []*jobs.Job) {
+	timeout := time.Duration(b.cfg.ShutdownTimeoutSeconds) * time.Second
+	log.Printf("Shutting down, waiting up to %s for running work to finish...", timeout)
 
-				switch userData.State {
-				case models.StateWaitingForVideo:
-					b.handleVideoUpload(upd.Message, userData)
-				case models.StateWaitingForCustomStyle:
-					b.handleCustomStyleInput(upd.Message, userData)
-				case models.StateWaitingForRevision:
-					b.handleRevisionInput(upd.Message, userData)
-				case models.StateWaitingForStability:
-					b.handleStabilityInput(upd.Message, userData)
-				case models.StateWaitingForClarity:
-					b.handleClarityInput(upd.Message, userData)
-				case models.StateWaitingForSpeed:
-					b.handleSpeedInput(upd.Message, userData)
-				}
+	handlersDone := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(handlersDone)
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	var interrupted []*jobs.Job
+	jobsFinished := false
+	for !jobsFinished || handlersDone != nil {
+		select {
+		case interrupted = <-jobsDone:
+			jobsFinished = true
+		case <-handlersDone:
+			handlersDone = nil
+		case <-deadline.C:
+			b.cancelWork()
+			if !jobsFinished {
+				log.Printf("Warning: jobs did not finish within %s, interrupting them.", timeout)
+				b.jobs.Interrupt()
+				interrupted = <-jobsDone
+			}
+			if handlersDone != nil {
+				log.Printf("Warning: some update handlers did not finish within %s, cancelling them.", timeout)
+				<-handlersDone
 			}
-		}(update)
+			jobsFinished, handlersDone = true, nil
+		}
+	}
+
+	b.cancelWork()
+
+	for _, job := range interrupted {
+		b.sendErrorMessage(job.ChatID, "job_interrupted")
+	}
+	log.Printf("Shutdown complete, %d job(s) will resume on the next start.", len(interrupted))
+}
+
+func (b *Bot) handleUpdate(upd tgbotapi.Update) {
+	if upd.InlineQuery != nil {
+		b.handleInlineQuery(upd.InlineQuery)
+		return
+	}
+
+	var userID int64
+	var chatID int64
+	var isCallback bool
+
+	if upd.CallbackQuery != nil {
+		userID = upd.CallbackQuery.From.ID
+		chatID = upd.CallbackQuery.Message.Chat.ID
+		isCallback = true
+	} else if upd.Message != nil {
+		userID = upd.Message.From.ID
+		chatID = upd.Message.Chat.ID
+	} else {
+		return
+	}
+
+	mu, _ := b.userLocks.LoadOrStore(userID, &sync.Mutex{})
+	userMutex := mu.(*sync.Mutex)
+	userMutex.Lock()
+	defer userMutex.Unlock()
+
+	userData, err := b.db.GetUserData(userID)
+	if err != nil {
+		log.Printf("FATAL: Could not get or create user data for user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return
+	}
+
+	if isCallback {
+		b.handleCallbackQuery(upd.CallbackQuery, userData)
+		return
+	}
+
+	if upd.Message != nil {
+		log.Printf("Received message from [ID: %d] with state [%s]", userID, userData.State)
+		if upd.Message.IsCommand() {
+			b.handleCommand(upd.Message, userData)
+			return
+		}
+
+		switch userData.State {
+		case models.StateWaitingForVideo:
+			b.handleVideoUpload(upd.Message, userData)
+		case models.StateWaitingForCustomStyle:
+			b.handleCustomStyleInput(upd.Message, userData)
+		case models.StateWaitingForRevision:
+			b.handleRevisionInput(upd.Message, userData)
+		case models.StateWaitingForStability:
+			b.handleStabilityInput(upd.Message, userData)
+		case models.StateWaitingForClarity:
+			b.handleClarityInput(upd.Message, userData)
+		case models.StateWaitingForSpeed:
+			b.handleSpeedInput(upd.Message, userData)
+		case models.StateWaitingForProjectName:
+			b.handleProjectNameInput(upd.Message, userData)
+		}
 	}
 }
 
-func (b *Bot) getFileBytes(fileID string) ([]byte, error) {
+// getFileBytes downloads a Telegram file into memory, retrying dropped
+// connections and server errors.
+func (b *Bot) getFileBytes(ctx context.Context, fileID string) ([]byte, error) {
 	fileURL, err := b.api.GetFileDirectURL(fileID)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Get(fileURL)
+	var data []byte
+	err = downloadRetryPolicy.Do(ctx, func(attempt int) error {
+		resp, err := b.getFile(ctx, fileURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		data, err = io.ReadAll(resp.Body)
+		return err
+	})
+	return data, err
+}
+
+// downloadToTempFile streams a Telegram file to a temporary file without
+// holding it in memory. The caller must remove the returned path.
+func (b *Bot) downloadToTempFile(ctx context.Context, fileID string) (string, error) {
+	fileURL, err := b.api.GetFileDirectURL(fileID)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	var path string
+	err = downloadRetryPolicy.Do(ctx, func(attempt int) error {
+		resp, err := b.getFile(ctx, fileURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		tmp, err := os.CreateTemp("", "video-script-bot-*")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tmp, resp.Body); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		path = tmp.Name()
+		return nil
+	})
+	return path, err
+}
+
+// getFile starts a download and classifies unsuccessful responses for retry.
+func (b *Bot) getFile(ctx context.Context, fileURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.downloads.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("telegram file download returned status %s", resp.Status)
+		switch retry.ClassForStatus(resp.StatusCode) {
+		case retry.Transient:
+			return nil, retry.Mark(retry.Transient, err)
+		case retry.Quota:
+			return nil, retry.After(retry.Transient, apikeys.ParseRetryAfter(resp.Header.Get("Retry-After")), err)
+		}
+		return nil, err
+	}
+	return resp, nil
 }
 
 func (b *Bot) sendErrorMessage(chatID int64, messageID string) {
@@ -159,24 +395,79 @@ func (b *Bot) sendErrorMessage(chatID int64, messageID string) {
 	b.api.Send(msg)
 }
 
-func (b *Bot) registerBackgroundTask(userID int64) (context.Context, context.CancelFunc) {
-	b.cancelBackgroundTask(userID)
+// sendIfUnavailable tells the user when to retry if err came from an open
+// circuit breaker, and reports whether it did.
+func (b *Bot) sendIfUnavailable(chatID int64, err error) bool {
+	var openErr *breaker.OpenError
+	if !errors.As(err, &openErr) {
+		return false
+	}
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: "service_unavailable",
+		TemplateData: map[string]string{
+			"Service": openErr.Name,
+			"RetryAt": openErr.RetryAt.Format("15:04"),
+		},
+	})
+	b.api.Send(tgbotapi.NewMessage(chatID, text))
+	return true
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	b.activeTasks.Store(userID, cancel)
-	return ctx, cancel
+// acquire takes a slot in pool for work on behalf of chatID. If the user has
+// to wait they are told their place in the queue, and if the queue is full
+// that the bot is busy.
+func (b *Bot) acquire(ctx context.Context, pool *workpool.Pool, chatID int64) (func(), error) {
+	release, err := pool.Acquire(ctx, b.notifyQueuePosition(chatID))
+	if errors.Is(err, workpool.ErrQueueFull) {
+		log.Printf("Warning: %s queue is full, turning away work for chat %d.", pool.Name(), chatID)
+		b.sendErrorMessage(chatID, "bot_busy")
+	}
+	return release, err
 }
 
-func (b *Bot) cancelBackgroundTask(userID int64) {
-	if cancelFunc, ok := b.activeTasks.Load(userID); ok {
-		if cf, isCancelFunc := cancelFunc.(context.CancelFunc); isCancelFunc {
-			cf()
-			log.Printf("Cancelled background task for user %d", userID)
+// notifyQueuePosition returns a workpool callback that tells chatID its place
+// in the queue.
+func (b *Bot) notifyQueuePosition(chatID int64) func(position int) {
+	return func(position int) {
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID:    "queue_position",
+			TemplateData: map[string]int{"Position": position},
+		})
+		b.api.Send(tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// startUserWork returns the context for work that userID starts outside the
+// job queue, such as /voice. It ends when the user sends /cancel or when
+// shutdown stops waiting. Call done when the work is finished.
+func (b *Bot) startUserWork(userID int64) (ctx context.Context, done func()) {
+	b.userWorkMu.Lock()
+	defer b.userWorkMu.Unlock()
+
+	work := b.userWork[userID]
+	if work == nil {
+		work = &userWork{}
+		work.ctx, work.cancel = context.WithCancel(b.workCtx)
+		b.userWork[userID] = work
+	}
+	work.active++
+	return work.ctx, func() {
+		b.userWorkMu.Lock()
+		defer b.userWorkMu.Unlock()
+		work.active--
+		if work.active == 0 && b.userWork[userID] == work {
+			work.cancel()
+			delete(b.userWork, userID)
 		}
-		b.activeTasks.Delete(userID)
 	}
 }
 
-func (b *Bot) clearBackgroundTask(userID int64) {
-	b.activeTasks.Delete(userID)
+// cancelUserWork stops the work userID started outside the job queue.
+func (b *Bot) cancelUserWork(userID int64) {
+	b.userWorkMu.Lock()
+	defer b.userWorkMu.Unlock()
+	if work := b.userWork[userID]; work != nil {
+		work.cancel()
+		delete(b.userWork, userID)
+	}
 }
@@ -4,11 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html"
 	"log"
-	"regexp"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+	"video-script-bot/internal/ai"
+	"video-script-bot/internal/audio"
 	"video-script-bot/internal/models"
+	"video-script-bot/internal/script"
+	"video-script-bot/internal/storage"
+	"video-script-bot/internal/subtitle"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/google/uuid"
@@ -17,6 +24,9 @@ import (
 
 const voicesPerPage = 6
 
+// inlineQueueTimeout is how long an inline query waits for a TTS slot.
+const inlineQueueTimeout = 5 * time.Second
+
 func (b *Bot) handleInlineQuery(inlineQuery *tgbotapi.InlineQuery) {
 	query := inlineQuery.Query
 	userID := inlineQuery.From.ID
@@ -49,18 +59,36 @@ func (b *Bot) handleInlineQuery(inlineQuery *tgbotapi.InlineQuery) {
 		userData = models.NewDefaultUserData()
 	}
 
+	ctx, done := b.startUserWork(userID)
+	defer done()
+
+	// Inline results are only useful within a few seconds, so there is no
+	// point in waiting long for a TTS slot.
+	queueCtx, cancel := context.WithTimeout(ctx, inlineQueueTimeout)
+	release, err := b.ttsPool.Acquire(queueCtx, nil)
+	cancel()
+	if err != nil {
+		log.Printf("Skipping inline audio for user %d: %v", userID, err)
+		return
+	}
+	defer release()
+
 	var results []interface{}
-	allVoices := b.elevenlabsService.GetVoices()
+	allVoices := b.speech.Voices(userData.TTSProvider)
 
 	for _, voice := range allVoices {
 		if strings.HasPrefix(strings.ToLower(voice.Name), strings.ToLower(voiceName)) {
-			audioBytes, err := b.elevenlabsService.TextToSpeech(voice.VoiceID, textToConvert, userData.Stability, userData.Clarity, userData.Speed)			
+			audioBytes, format, err := b.speech.Synthesize(ctx, voice.VoiceID, textToConvert, voiceSettings(userData))
+			if ctx.Err() != nil {
+				log.Printf("Inline audio for user %d stopped: %v", userID, ctx.Err())
+				return
+			}
 			if err != nil {
 				log.Printf("Inline audio generation failed for voice %s: %v", voice.Name, err)
 				continue
 			}
 
-			descriptiveFilename := fmt.Sprintf("%s.mp3", textToConvert)
+			descriptiveFilename := fmt.Sprintf("%s.%s", textToConvert, format)
 			audioFile := tgbotapi.FileBytes{Name: descriptiveFilename, Bytes: audioBytes}
 			audioMsg := tgbotapi.NewAudio(b.cfg.StorageChannelID, audioFile)
 			audioMsg.Title = textToConvert
@@ -138,8 +166,16 @@ func (b *Bot) handleCallbackQuery(callback *tgbotapi.CallbackQuery, userData *mo
 		b.handleStyleSelection(callback, userData)
 		return
 	}
+	if strings.HasPrefix(callback.Data, "mux_") {
+		b.handleMuxSelection(callback, userData)
+		return
+	}
+	if strings.HasPrefix(callback.Data, "project") {
+		b.handleProjectCallback(callback, userData)
+		return
+	}
 	if strings.HasPrefix(callback.Data, "voice_page_") {
-		b.handleVoicePage(callback)
+		b.handleVoicePage(callback, userData)
 		return
 	}
 	if strings.HasPrefix(callback.Data, "voice_") {
@@ -185,6 +221,14 @@ func (b *Bot) handleCallbackQuery(callback *tgbotapi.CallbackQuery, userData *mo
 		b.api.Send(msg)
 		userData.State = models.StateWaitingForSpeed
 		b.db.SetUserData(userID, userData)
+	case "cycle_tts_provider":
+		userData.TTSProvider = b.nextProvider(userData.TTSProvider)
+		b.db.SetUserData(userID, userData)
+		b.sendSettingsMenu(chatID, userData, callback.Message.MessageID)
+	case "toggle_merge_audio":
+		userData.MergeAudio = !userData.MergeAudio
+		b.db.SetUserData(userID, userData)
+		b.sendSettingsMenu(chatID, userData, callback.Message.MessageID)
 	case "back_to_main_menu":
 		b.handleStartCommand(chatID)
 		editMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
@@ -207,20 +251,32 @@ func (b *Bot) handleCommand(message *tgbotapi.Message, userData *models.UserData
 	case "voice":
 		b.handleVoiceCommand(message, userData)
 	case "listvoices":
-		b.handleListVoicesCommand(message.Chat.ID)
+		b.handleListVoicesCommand(message.Chat.ID, userData)
 	case "help":
 		b.handleHelpCommand(message.Chat.ID)
 	case "donate": // <-- PENAMBAHAN DI SINI
         b.handleDonateCommand(message.Chat.ID)
+	case "keys":
+		b.handleKeysCommand(message.Chat.ID, message.From.ID)
+	case "reload":
+		b.handleReloadCommand(message.Chat.ID, message.From.ID)
 	case "cancel":
 		b.handleCancelCommand(message, userData)
+	case "projects":
+		b.handleProjectsCommand(message.Chat.ID, message.From.ID, userData)
+	case "history":
+		b.handleHistoryCommand(message.Chat.ID, message.From.ID, userData)
+	case "diff":
+		b.handleDiffCommand(message, userData)
+	case "restore":
+		b.handleRestoreCommand(message, userData)
 	default:
 		log.Printf("Received an unknown command: %s", message.Command())
 	}
 }
 
-func (b *Bot) handleListVoicesCommand(chatID int64) {
-	b.sendPaginatedVoices(chatID, 0, false)
+func (b *Bot) handleListVoicesCommand(chatID int64, userData *models.UserData) {
+	b.sendPaginatedVoices(chatID, userData.TTSProvider, 0, false)
 }
 
 func (b *Bot) handleHelpCommand(chatID int64) {
@@ -242,7 +298,10 @@ func (b *Bot) handleCancelCommand(message *tgbotapi.Message, userData *models.Us
 	userID := message.From.ID
 	chatID := message.Chat.ID
 
-	b.cancelBackgroundTask(userID)
+	if err := b.jobs.Cancel(userID); err != nil {
+		log.Printf("Could not cancel jobs of user %d: %v", userID, err)
+	}
+	b.cancelUserWork(userID)
 
 	*userData = *models.NewDefaultUserData()
 	b.db.SetUserData(userID, userData)
@@ -268,24 +327,9 @@ func (b *Bot) handleVoiceCommand(message *tgbotapi.Message, userData *models.Use
 		return
 	}
 
-	voiceName := strings.ToLower(parts[0])
 	textToConvert := parts[1]
 
-	voices := b.elevenlabsService.GetVoices()
-	var voiceID string
-	var found bool
-	for _, voice := range voices {
-		name := voice.Name
-		if name == "" {
-			name = voice.VoiceID
-		}
-		if strings.ToLower(name) == voiceName {
-			voiceID = voice.VoiceID
-			found = true
-			break
-		}
-	}
-
+	voice, found := b.speech.FindVoiceByName(userData.TTSProvider, parts[0])
 	if !found {
 		notFoundText, _ := b.localizer.Localize(&i18n.LocalizeConfig{
 			MessageID: "voice_not_found",
@@ -303,16 +347,32 @@ func (b *Bot) handleVoiceCommand(message *tgbotapi.Message, userData *models.Use
 	msg := tgbotapi.NewMessage(chatID, generatingText)
 	b.api.Send(msg)
 
+	ctx, done := b.startUserWork(message.From.ID)
+	b.inFlight.Add(1)
 	go func() {
-		audioBytes, err := b.elevenlabsService.TextToSpeech(voiceID, textToConvert, userData.Stability, userData.Clarity, userData.Speed)
+		defer b.inFlight.Done()
+		defer done()
+		release, err := b.acquire(ctx, b.ttsPool, chatID)
+		if err != nil {
+			return
+		}
+		defer release()
+
+		audioBytes, format, err := b.speech.Synthesize(ctx, voice.VoiceID, textToConvert, voiceSettings(userData))
+		if ctx.Err() != nil {
+			log.Printf("Direct audio for user %d stopped: %v", message.From.ID, ctx.Err())
+			return
+		}
 		if err != nil {
 			log.Printf("Failed to generate direct audio for user %d: %v", message.From.ID, err)
-			b.sendErrorMessage(chatID, "audio_generation_error")
+			if !b.sendIfUnavailable(chatID, err) {
+				b.sendErrorMessage(chatID, "audio_generation_error")
+			}
 			return
 		}
 
 		audioFile := tgbotapi.FileBytes{
-			Name:  fmt.Sprintf("voice_%d.mp3", message.From.ID),
+			Name:  fmt.Sprintf("voice_%d.%s", message.From.ID, format),
 			Bytes: audioBytes,
 		}
 
@@ -367,9 +427,21 @@ func (b *Bot) handleVideoUpload(message *tgbotapi.Message, userData *models.User
 		return
 	}
 
+	// A new video starts a new project, so the work on the previous one is
+	// kept.
+	if userData.VideoFileID != "" || userData.GeneratedScript != "" {
+		if !b.switchToNewProject(chatID, message.From.ID, userData) {
+			return
+		}
+		b.sendHTMLMessage(chatID, "project_started", map[string]string{"Name": html.EscapeString(userData.ProjectName)})
+	}
+
 	userData.State = models.StateWaitingForStyle
 	userData.VideoFileID = message.Video.FileID
 	userData.VideoMimeType = message.Video.MimeType
+	userData.VideoDuration = message.Video.Duration
+	userData.VideoFileSize = message.Video.FileSize
+	userData.NarrationFileID = ""
 	b.db.SetUserData(message.From.ID, userData)
 
 	chooseStyleText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "choose_script_style"})
@@ -383,6 +455,10 @@ func (b *Bot) handleStyleSelection(callback *tgbotapi.CallbackQuery, userData *m
 	userID := callback.From.ID
 	style := strings.TrimPrefix(callback.Data, "style_")
 
+	if b.videoTooLargeToDownload(chatID, userData) {
+		return
+	}
+
 	generatingText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "generating_script"})
 	msg := tgbotapi.NewMessage(chatID, generatingText)
 	b.api.Send(msg)
@@ -391,8 +467,7 @@ func (b *Bot) handleStyleSelection(callback *tgbotapi.CallbackQuery, userData *m
 	userData.ScriptStyle = style
 	b.db.SetUserData(userID, userData)
 
-	ctx, _ := b.registerBackgroundTask(userID)
-	go b.generateScript(ctx, chatID, userID, userData)
+	b.submitJob(jobGenerate, chatID, userID, jobPayload{ProjectID: userData.ProjectID})
 }
 
 func (b *Bot) promptForCustomStyle(chatID int64, userData *models.UserData) {
@@ -410,6 +485,12 @@ func (b *Bot) handleCustomStyleInput(message *tgbotapi.Message, userData *models
 	userID := message.From.ID
 	style := message.Text
 
+	if b.videoTooLargeToDownload(chatID, userData) {
+		userData.State = models.StateIdle
+		b.db.SetUserData(userID, userData)
+		return
+	}
+
 	generatingText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "generating_script"})
 	msg := tgbotapi.NewMessage(chatID, generatingText)
 	b.api.Send(msg)
@@ -418,66 +499,147 @@ func (b *Bot) handleCustomStyleInput(message *tgbotapi.Message, userData *models
 	userData.ScriptStyle = style
 	b.db.SetUserData(userID, userData)
 
-	ctx, _ := b.registerBackgroundTask(userID)
-	go b.generateScript(ctx, chatID, userID, userData)
+	b.submitJob(jobGenerate, chatID, userID, jobPayload{ProjectID: userData.ProjectID})
 }
 
-func (b *Bot) generateScript(ctx context.Context, chatID int64, userID int64, userData *models.UserData) {
-	defer b.clearBackgroundTask(userID)
-
+// generateScript writes a script for the user's video and returns it. Errors
+// have already been reported to the user.
+func (b *Bot) generateScript(ctx context.Context, chatID int64, userID int64, userData *models.UserData) (string, error) {
 	if userData.VideoFileID == "" || userData.ScriptStyle == "" {
 		log.Printf("Error for user %d: missing data for script generation", userID)
 		b.sendErrorMessage(chatID, "analysis_error")
-		return
+		return "", errors.New("missing video or style for script generation")
+	}
+
+	capabilities := b.scriptGenerator.Capabilities()
+	if !capabilities.VideoInput {
+		log.Printf("Script provider %s cannot take video input, user %d", b.scriptGenerator.Name(), userID)
+		b.sendErrorMessage(chatID, "script_provider_no_video")
+		return "", fmt.Errorf("script provider %s cannot take video input", b.scriptGenerator.Name())
+	}
+	if capabilities.MaxUploadBytes > 0 && int64(userData.VideoFileSize) > capabilities.MaxUploadBytes {
+		log.Printf("Video of user %d is %d bytes, over the %s limit", userID, userData.VideoFileSize, b.scriptGenerator.Name())
+		b.sendLimitMessage(chatID, "video_too_large_for_provider", userData.VideoFileSize/(1024*1024), int(capabilities.MaxUploadBytes/(1024*1024)))
+		return "", fmt.Errorf("video is over the %s upload limit", b.scriptGenerator.Name())
+	}
+
+	if b.videoTooLargeToDownload(chatID, userData) {
+		return "", errors.New("video is over the download limit")
 	}
 
-	videoBytes, err := b.getFileBytes(userData.VideoFileID)
+	videoPath, err := b.downloadToTempFile(ctx, userData.VideoFileID)
 	if err != nil {
-		log.Printf("Error getting file bytes for user %d: %v", userID, err)
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Script generation cancelled for user %d", userID)
+			return "", err
+		}
+		log.Printf("Error downloading video for user %d: %v", userID, err)
 		b.sendErrorMessage(chatID, "analysis_error")
-		return
+		return "", err
 	}
+	defer os.Remove(videoPath)
 
-	script, err := b.geminiService.GenerateScriptFromVideo(ctx, videoBytes, userData.VideoMimeType, userData.ScriptStyle)
+	ctx, usedModel := ai.WithModelReport(ctx)
+	generatedScript, err := b.scriptGenerator.GenerateScriptFromVideo(ctx, videoPath, userData.VideoMimeType, userData.VideoLength(), userData.ScriptStyle)
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Script generation cancelled for user %d", userID)
 		} else {
-			log.Printf("Error generating script from Gemini for user %d: %v", userID, err)
-			b.sendErrorMessage(chatID, "analysis_error")
+			log.Printf("Error generating script from %s for user %d: %v", b.scriptGenerator.Name(), userID, err)
+			if !b.sendIfUnavailable(chatID, err) {
+				b.sendErrorMessage(chatID, "analysis_error")
+			}
 		}
-		return
+		return "", err
 	}
 
-	userData.GeneratedScript = script
-	b.db.SetUserData(userID, userData)
+	version := storage.ScriptVersion{Source: storage.ScriptGenerated, Model: usedModel()}
+	return b.storeGeneratedScript(chatID, userID, userData, generatedScript, version), nil
+}
+
+// storeGeneratedScript saves the script to the project of userData, adds it to
+// the script history as version, and shows it to the user. Only the script is
+// written, over the user's current data, since scripts can take a while to
+// generate. Scripts that pass validation are stored in their normalized form;
+// invalid ones are kept as they are so they can still be revised, and the
+// problems are reported.
+func (b *Bot) storeGeneratedScript(chatID, userID int64, userData *models.UserData, rawScript string, version storage.ScriptVersion) string {
+	generatedScript := rawScript
+	parsed, err := script.ParseAndValidate(rawScript, userData.VideoLength())
+	if err != nil {
+		log.Printf("Generated script for user %d failed validation: %v", userID, err)
+	} else {
+		generatedScript = parsed.String()
+	}
+	saved, saveErr := b.updateProject(userID, userData.ProjectID, func(current *models.UserData) {
+		current.GeneratedScript = generatedScript
+	})
+	if saveErr != nil {
+		log.Printf("Warning: could not save the script of user %d: %v", userID, saveErr)
+	} else {
+		b.recordScriptVersion(userID, saved, version)
+	}
 
-	b.sendScriptMessage(chatID, script)
+	b.sendScriptMessage(chatID, generatedScript)
+	if err != nil {
+		b.sendScriptProblems(chatID, err)
+	}
+	return generatedScript
 }
 
 func (b *Bot) handleAgreeScript(callback *tgbotapi.CallbackQuery, userData *models.UserData) {
 	chatID := callback.Message.Chat.ID
 	userID := callback.From.ID
 
+	parsed, err := script.ParseAndValidate(userData.GeneratedScript, userData.VideoLength())
+	if err != nil {
+		log.Printf("User %d tried to approve an invalid script: %v", userID, err)
+		b.sendScriptProblems(chatID, err)
+		return
+	}
+
 	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "agreed_to_script"})
 	b.api.Send(tgbotapi.NewMessage(chatID, text))
 
+	b.sendSubtitleFiles(chatID, userID, parsed)
+
 	userData.State = models.StateWaitingForVoiceSelection
 	b.db.SetUserData(userID, userData)
 
-	b.sendPaginatedVoices(chatID, 0, true)
+	b.sendPaginatedVoices(chatID, userData.TTSProvider, 0, true)
 
 	editMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
 	b.api.Send(editMsg)
 }
 
+// sendSubtitleFiles sends the approved script as SRT and WebVTT documents.
+func (b *Bot) sendSubtitleFiles(chatID, userID int64, parsed *script.Script) {
+	cues := subtitle.BuildCues(parsed, b.cfg.SubtitleMaxChars)
+	files := []tgbotapi.FileBytes{
+		{Name: fmt.Sprintf("script_%d.srt", userID), Bytes: subtitle.SRT(cues)},
+		{Name: fmt.Sprintf("script_%d.vtt", userID), Bytes: subtitle.VTT(cues)},
+	}
+
+	caption, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "subtitle_files_caption"})
+	for _, file := range files {
+		doc := tgbotapi.NewDocument(chatID, file)
+		doc.Caption = caption
+		if _, err := b.api.Send(doc); err != nil {
+			log.Printf("Failed to send subtitle file %s to user %d: %v", file.Name, userID, err)
+		}
+	}
+}
+
 func (b *Bot) handleRegenerateScript(chatID int64, userData *models.UserData) {
+	if b.videoTooLargeToDownload(chatID, userData) {
+		return
+	}
+
 	generatingText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "generating_script"})
 	msg := tgbotapi.NewMessage(chatID, generatingText)
 	b.api.Send(msg)
 
-	ctx, _ := b.registerBackgroundTask(chatID)
-	go b.generateScript(ctx, chatID, chatID, userData)
+	b.submitJob(jobGenerate, chatID, chatID, jobPayload{ProjectID: userData.ProjectID})
 }
 
 func (b *Bot) handleReviseScript(chatID int64, userData *models.UserData) {
@@ -502,33 +664,33 @@ func (b *Bot) handleRevisionInput(message *tgbotapi.Message, userData *models.Us
 	userData.State = models.StateIdle
 	b.db.SetUserData(userID, userData)
 
-	ctx, _ := b.registerBackgroundTask(userID)
-	go b.reviseScript(ctx, chatID, userID, instructions, userData)
+	b.submitJob(jobRevise, chatID, userID, jobPayload{ProjectID: userData.ProjectID, Instructions: instructions})
 }
 
-func (b *Bot) reviseScript(ctx context.Context, chatID, userID int64, instructions string, userData *models.UserData) {
-	defer b.clearBackgroundTask(userID)
+// reviseScript revises the user's script and returns the new one.
+func (b *Bot) reviseScript(ctx context.Context, chatID, userID int64, instructions string, userData *models.UserData) (string, error) {
 	if userData.GeneratedScript == "" {
 		log.Printf("Error for user %d: no script to revise", userID)
 		b.sendErrorMessage(chatID, "analysis_error")
-		return
+		return "", errors.New("no script to revise")
 	}
 
-	revisedScript, err := b.geminiService.ReviseScript(ctx, userData.GeneratedScript, instructions)
+	ctx, usedModel := ai.WithModelReport(ctx)
+	revisedScript, err := b.scriptGenerator.ReviseScript(ctx, userData.GeneratedScript, instructions, userData.ScriptStyle, userData.VideoLength())
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Printf("Script revision cancelled for user %d", userID)
 		} else {
 			log.Printf("Error revising script for user %d: %v", userID, err)
-			b.sendErrorMessage(chatID, "analysis_error")
+			if !b.sendIfUnavailable(chatID, err) {
+				b.sendErrorMessage(chatID, "analysis_error")
+			}
 		}
-		return
+		return "", err
 	}
 
-	userData.GeneratedScript = revisedScript
-	b.db.SetUserData(userID, userData)
-
-	b.sendScriptMessage(chatID, revisedScript)
+	version := storage.ScriptVersion{Source: storage.ScriptRevised, Instructions: instructions, Model: usedModel()}
+	return b.storeGeneratedScript(chatID, userID, userData, revisedScript, version), nil
 }
 
 func (b *Bot) sendScriptMessage(chatID int64, script string) {
@@ -540,8 +702,30 @@ func (b *Bot) sendScriptMessage(chatID int64, script string) {
 	b.api.Send(msg)
 }
 
-func (b *Bot) sendPaginatedVoices(chatID int64, page int, forSelection bool) {
-	voices := b.elevenlabsService.GetVoices()
+func (b *Bot) sendScriptProblems(chatID int64, err error) {
+	var problems []string
+	var scriptErrors script.Errors
+	if errors.As(err, &scriptErrors) {
+		for _, lineErr := range scriptErrors {
+			problems = append(problems, lineErr.Error())
+		}
+	} else {
+		problems = append(problems, err.Error())
+	}
+
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: "script_validation_error",
+		TemplateData: map[string]string{
+			"Problems": html.EscapeString(strings.Join(problems, "\n")),
+		},
+	})
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	b.api.Send(msg)
+}
+
+func (b *Bot) sendPaginatedVoices(chatID int64, provider string, page int, forSelection bool) {
+	voices := b.speech.Voices(provider)
 	if len(voices) == 0 {
 		log.Println("Error: no voices loaded from file")
 		b.sendErrorMessage(chatID, "audio_generation_error")
@@ -560,7 +744,7 @@ func (b *Bot) sendPaginatedVoices(chatID int64, page int, forSelection bool) {
 	b.api.Send(msg)
 }
 
-func (b *Bot) handleVoicePage(callback *tgbotapi.CallbackQuery) {
+func (b *Bot) handleVoicePage(callback *tgbotapi.CallbackQuery, userData *models.UserData) {
 	pageStr := strings.TrimPrefix(callback.Data, "voice_page_")
 	page, err := strconv.Atoi(pageStr)
 	if err != nil {
@@ -568,7 +752,7 @@ func (b *Bot) handleVoicePage(callback *tgbotapi.CallbackQuery) {
 		return
 	}
 
-	voices := b.elevenlabsService.GetVoices()
+	voices := b.speech.Voices(userData.TTSProvider)
 	if len(voices) == 0 {
 		return
 	}
@@ -612,69 +796,307 @@ func (b *Bot) handleVoiceSelection(callback *tgbotapi.CallbackQuery, userData *m
 	b.api.Send(editMsg)
 
 	userData.State = models.StateIdle
+	userData.VoiceID = voiceID
 	b.db.SetUserData(userID, userData)
 
-	ctx, _ := b.registerBackgroundTask(userID)
-	go b.generateAndSendAudio(ctx, chatID, userID, voiceID, userData)
+	b.submitJob(jobSynthesize, chatID, userID, jobPayload{ProjectID: userData.ProjectID, VoiceID: voiceID})
 }
 
-func (b *Bot) generateAndSendAudio(ctx context.Context, chatID, userID int64, voiceID string, userData *models.UserData) {
-	defer b.clearBackgroundTask(userID)
+// generateAndSendAudio narrates every line of the script and returns a short
+// summary of what was sent.
+func (b *Bot) generateAndSendAudio(ctx context.Context, chatID, userID int64, voiceID string, userData *models.UserData) (string, error) {
 	if userData.GeneratedScript == "" {
 		log.Printf("User %d has no script to generate audio from", userID)
-		return
+		return "", errors.New("no script to generate audio from")
+	}
+
+	parsed, err := script.ParseAndValidate(userData.GeneratedScript, userData.VideoLength())
+	if err != nil {
+		log.Printf("User %d has an invalid script, cannot generate audio: %v", userID, err)
+		b.sendScriptProblems(chatID, err)
+		return "", err
 	}
 
-	re := regexp.MustCompile(`\r?\n`)
-	lines := re.Split(userData.GeneratedScript, -1)
+	mergeAudio := userData.MergeAudio
+	if mergeAudio && !b.mixer.Available() {
+		b.sendErrorMessage(chatID, "audio_merge_unavailable")
+		mergeAudio = false
+	}
 
-	for _, line := range lines {
+	var clips []audio.Clip
+	var clipSegments []script.Segment
+	generated := 0
+	for _, segment := range parsed.Segments {
 		if ctx.Err() != nil {
 			log.Printf("Audio generation cancelled for user %d", userID)
 			break
 		}
 
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine == "" {
+		audioBytes, format, err := b.speech.Synthesize(ctx, voiceID, segment.Text, voiceSettings(userData))
+		if err != nil {
+			log.Printf("Failed to generate audio for line '%s': %v", segment.String(), err)
+			// The provider is down; the remaining lines would fail too.
+			if b.sendIfUnavailable(chatID, err) {
+				return "", err
+			}
 			continue
 		}
+		generated++
 
-		parts := strings.SplitN(trimmedLine, ": ", 2)
-		var textToSpeak string
-		if len(parts) > 1 {
-			textToSpeak = parts[1]
-		} else {
-			textToSpeak = parts[0]
+		if mergeAudio {
+			clips = append(clips, audio.Clip{Start: segment.Start, Data: audioBytes, Format: format})
+			clipSegments = append(clipSegments, segment)
+			continue
 		}
+		b.sendSegmentAudio(chatID, userID, segment, audioBytes, format)
+	}
 
-		if strings.TrimSpace(textToSpeak) == "" {
-			continue
+	if mergeAudio && ctx.Err() == nil && len(clips) > 0 {
+		if err := b.sendMergedNarration(ctx, chatID, userID, userData, parsed, clips, clipSegments); err != nil {
+			log.Printf("Failed to merge narration for user %d: %v", userID, err)
+			b.sendErrorMessage(chatID, "audio_merge_error")
+			for i, clip := range clips {
+				b.sendSegmentAudio(chatID, userID, clipSegments[i], clip.Data, clip.Format)
+			}
 		}
+	}
+
+	if ctx.Err() == nil {
+		completionText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "audio_generation_complete"})
+		finalMsg := tgbotapi.NewMessage(chatID, completionText)
+		b.api.Send(finalMsg)
+	}
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if generated == 0 {
+		return "", errors.New("no line could be narrated")
+	}
+	return fmt.Sprintf("%d of %d lines narrated", generated, len(parsed.Segments)), nil
+}
 
-		audioBytes, err := b.elevenlabsService.TextToSpeech(voiceID, textToSpeak, userData.Stability, userData.Clarity, userData.Speed)
+func (b *Bot) sendSegmentAudio(chatID, userID int64, segment script.Segment, audioBytes []byte, format string) {
+	audioFile := tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("audio_%d.%s", userID, format),
+		Bytes: audioBytes,
+	}
+
+	audioMsg := tgbotapi.NewAudio(chatID, audioFile)
+	audioMsg.Caption = segment.String()
+	if _, err := b.api.Send(audioMsg); err != nil {
+		log.Printf("Failed to send audio file: %v", err)
+	}
+}
+
+// sendMergedNarration mixes the clips into one track as long as the video and
+// sends it, followed by a note about any lines that ran past their slot. A
+// clip's slot lasts until the next line starts, or until the end of the video.
+func (b *Bot) sendMergedNarration(ctx context.Context, chatID, userID int64, userData *models.UserData, parsed *script.Script, clips []audio.Clip, clipSegments []script.Segment) error {
+	total := userData.VideoLength()
+	if total < parsed.Duration() {
+		total = parsed.Duration()
+	}
+	for i := range clips {
+		next := total
+		if i+1 < len(clips) {
+			next = clips[i+1].Start
+		}
+		clips[i].Slot = next - clips[i].Start
+	}
+
+	result, err := b.mixer.Mix(ctx, clips, total)
+	if err != nil {
+		return err
+	}
+
+	audioMsg := tgbotapi.NewAudio(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("narration_%d.mp3", userID),
+		Bytes: result.Data,
+	})
+	audioMsg.Caption, _ = b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "merged_audio_caption"})
+	sentMsg, err := b.api.Send(audioMsg)
+	if err != nil {
+		return fmt.Errorf("failed to send merged narration: %w", err)
+	}
+	if sentMsg.Audio != nil {
+		fileID := sentMsg.Audio.FileID
+		if _, err := b.updateProject(userID, userData.ProjectID, func(current *models.UserData) {
+			current.NarrationFileID = fileID
+		}); err != nil {
+			log.Printf("Warning: could not save the narration of user %d: %v", userID, err)
+		}
+		defer b.sendMuxOffer(chatID)
+	}
+
+	if len(result.Overruns) == 0 {
+		return nil
+	}
+	var lines []string
+	stretched := false
+	for _, overrun := range result.Overruns {
+		stretched = overrun.Stretched
+		lines = append(lines, fmt.Sprintf("%s (%.1fs / %.1fs)", clipSegments[overrun.Index].String(), overrun.Length.Seconds(), overrun.Slot.Seconds()))
+	}
+	messageID := "audio_overrun_warning"
+	if stretched {
+		messageID = "audio_overrun_stretched"
+	}
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]string{
+			"Lines": html.EscapeString(strings.Join(lines, "\n")),
+		},
+	})
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	b.api.Send(msg)
+	return nil
+}
+
+func (b *Bot) sendMuxOffer(chatID int64) {
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "mux_offer"})
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = b.getMuxAudioKeyboard()
+	b.api.Send(msg)
+}
+
+// handleMuxSelection walks through the two mux choices. The callback data is
+// "mux_<audio mode>" for the first step and "mux_<audio mode>_<subtitle mode>"
+// for the second, which starts the job.
+func (b *Bot) handleMuxSelection(callback *tgbotapi.CallbackQuery, userData *models.UserData) {
+	chatID := callback.Message.Chat.ID
+	userID := callback.From.ID
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "mux_"), "_")
+
+	if parts[0] == "skip" {
+		editMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+		b.api.Send(editMsg)
+		return
+	}
+
+	audioMode := audio.AudioMode(parts[0])
+	if audioMode != audio.AudioMix && audioMode != audio.AudioReplace {
+		log.Printf("Received unknown mux callback data: %s", callback.Data)
+		return
+	}
+
+	if len(parts) == 1 {
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "mux_subtitle_prompt"})
+		editMsg := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+		keyboard := b.getMuxSubtitleKeyboard(audioMode)
+		editMsg.ReplyMarkup = &keyboard
+		b.api.Send(editMsg)
+		return
+	}
+
+	subtitleMode := audio.SubtitleMode(parts[1])
+	switch subtitleMode {
+	case audio.SubtitlesNone, audio.SubtitlesBurn, audio.SubtitlesAttach:
+	default:
+		log.Printf("Received unknown mux callback data: %s", callback.Data)
+		return
+	}
+
+	editMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, callback.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	b.api.Send(editMsg)
+
+	if userData.NarrationFileID == "" || userData.VideoFileID == "" {
+		b.sendErrorMessage(chatID, "mux_no_narration")
+		return
+	}
+
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "muxing_video"})
+	b.api.Send(tgbotapi.NewMessage(chatID, text))
+
+	b.submitJob(jobMux, chatID, userID, jobPayload{ProjectID: userData.ProjectID, AudioMode: audioMode, SubtitleMode: subtitleMode})
+}
+
+// muxNarration puts the narration into the user's video and sends it back.
+func (b *Bot) muxNarration(ctx context.Context, chatID, userID int64, userData *models.UserData, audioMode audio.AudioMode, subtitleMode audio.SubtitleMode) error {
+	if b.videoTooLargeToDownload(chatID, userData) {
+		return errors.New("video is over the download limit")
+	}
+
+	req := audio.MuxRequest{AudioMode: audioMode, SubtitleMode: subtitleMode}
+	if subtitleMode != audio.SubtitlesNone {
+		parsed, err := script.ParseAndValidate(userData.GeneratedScript, userData.VideoLength())
 		if err != nil {
-			log.Printf("Failed to generate audio for line '%s': %v", trimmedLine, err)
-			continue
+			log.Printf("User %d has an invalid script, cannot build subtitles: %v", userID, err)
+			b.sendScriptProblems(chatID, err)
+			return err
 		}
+		req.Subtitles = subtitle.SRT(subtitle.BuildCues(parsed, b.cfg.SubtitleMaxChars))
+	}
 
-		audioFile := tgbotapi.FileBytes{
-			Name:  fmt.Sprintf("audio_%d.mp3", userID),
-			Bytes: audioBytes,
+	var err error
+	if req.Video, err = b.getFileBytes(ctx, userData.VideoFileID); err != nil {
+		log.Printf("Error downloading video for user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "mux_error")
+		return err
+	}
+	if req.Narration, err = b.getFileBytes(ctx, userData.NarrationFileID); err != nil {
+		log.Printf("Error downloading narration for user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "mux_error")
+		return err
+	}
+
+	output, err := b.mixer.Mux(ctx, req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Video muxing cancelled for user %d", userID)
+			return err
 		}
+		log.Printf("Failed to mux narration for user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "mux_error")
+		return err
+	}
 
-		audioMsg := tgbotapi.NewAudio(chatID, audioFile)
-		audioMsg.Caption = trimmedLine
-		if _, err := b.api.Send(audioMsg); err != nil {
-			log.Printf("Failed to send audio file: %v", err)
+	uploadLimit := b.cfg.TelegramUploadLimitMB * 1024 * 1024
+	if len(output) > uploadLimit {
+		log.Printf("Muxed video for user %d is %d bytes, over the upload limit", userID, len(output))
+		b.sendLimitMessage(chatID, "video_too_large_upload", (len(output)+1024*1024-1)/(1024*1024), b.cfg.TelegramUploadLimitMB)
+		return errors.New("muxed video is over the upload limit")
+	}
+
+	videoMsg := tgbotapi.NewVideo(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("narrated_%d.mp4", userID),
+		Bytes: output,
+	})
+	videoMsg.Caption, _ = b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "mux_video_caption"})
+	videoMsg.SupportsStreaming = true
+	if _, err := b.api.Send(videoMsg); err != nil {
+		log.Printf("Failed to send muxed video to user %d: %v", userID, err)
+		if strings.Contains(err.Error(), "Request Entity Too Large") {
+			b.sendLimitMessage(chatID, "video_too_large_upload", (len(output)+1024*1024-1)/(1024*1024), b.cfg.TelegramUploadLimitMB)
+		} else {
+			b.sendErrorMessage(chatID, "mux_error")
 		}
+		return err
 	}
+	return nil
+}
 
-	if ctx.Err() == nil {
-		completionText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "audio_generation_complete"})
-		finalMsg := tgbotapi.NewMessage(chatID, completionText)
-		b.api.Send(finalMsg)
+// videoTooLargeToDownload tells the user when their video is over the size
+// Telegram lets bots download, which is far below what bots can receive.
+func (b *Bot) videoTooLargeToDownload(chatID int64, userData *models.UserData) bool {
+	if userData.VideoFileSize <= b.cfg.TelegramDownloadLimitMB*1024*1024 {
+		return false
 	}
+	log.Printf("Video of chat %d is %d bytes, over the %d MB download limit", chatID, userData.VideoFileSize, b.cfg.TelegramDownloadLimitMB)
+	b.sendLimitMessage(chatID, "video_too_large_download", 0, b.cfg.TelegramDownloadLimitMB)
+	return true
+}
 
+func (b *Bot) sendLimitMessage(chatID int64, messageID string, sizeMB, limitMB int) {
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]int{
+			"Size":  sizeMB,
+			"Limit": limitMB,
+		},
+	})
+	b.api.Send(tgbotapi.NewMessage(chatID, text))
 }
 
 func (b *Bot) sendSettingsMenu(chatID int64, userData *models.UserData, messageID int) {
@@ -683,6 +1105,8 @@ func (b *Bot) sendSettingsMenu(chatID int64, userData *models.UserData, messageI
 		userData.Stability,
 		userData.Clarity,
 		userData.Speed,
+		b.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: onOffMessageID(userData.MergeAudio)}),
+		b.providerLabel(userData.TTSProvider),
 	)
 
 	keyboard := b.getSettingsKeyboard()
@@ -772,11 +1196,15 @@ func (b *Bot) getStartKeyboard() tgbotapi.InlineKeyboardMarkup {
 	createScriptButtonText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "create_script_button"})
 	textToVoiceButtonText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_text_to_voice"})
 	settingsButtonText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_main"})
+	projectsButtonText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "projects_button_main"})
 
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(createScriptButtonText, "create_script"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(projectsButtonText, "projects"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(textToVoiceButtonText, "show_voice_tutorial"),
 		),
@@ -831,6 +1259,41 @@ func (b *Bot) getScriptActionKeyboard() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
+func (b *Bot) getMuxAudioKeyboard() tgbotapi.InlineKeyboardMarkup {
+	mixText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_mux_mix"})
+	replaceText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_mux_replace"})
+	skipText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_mux_skip"})
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(mixText, "mux_"+string(audio.AudioMix)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(replaceText, "mux_"+string(audio.AudioReplace)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(skipText, "mux_skip"),
+		),
+	)
+}
+
+func (b *Bot) getMuxSubtitleKeyboard(audioMode audio.AudioMode) tgbotapi.InlineKeyboardMarkup {
+	noneText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_subtitles_none"})
+	burnText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_subtitles_burn"})
+	attachText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_subtitles_attach"})
+	prefix := "mux_" + string(audioMode) + "_"
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(noneText, prefix+string(audio.SubtitlesNone)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(burnText, prefix+string(audio.SubtitlesBurn)),
+			tgbotapi.NewInlineKeyboardButtonData(attachText, prefix+string(audio.SubtitlesAttach)),
+		),
+	)
+}
+
 func (b *Bot) getVoiceSelectionKeyboard(voices []models.Voice, page int, forSelection bool) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 
@@ -918,18 +1381,61 @@ func (b *Bot) getSettingsKeyboard() tgbotapi.InlineKeyboardMarkup {
 	stabilityBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_stability"})
 	clarityBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_clarity"})
 	speedBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_speed"})
+	mergeBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_merge_audio"})
+	providerBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_tts_provider"})
 	backBtn, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "settings_button_back"})
 
-	return tgbotapi.NewInlineKeyboardMarkup(
+	rows := [][]tgbotapi.InlineKeyboardButton{
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(stabilityBtn, "set_stability"),
 			tgbotapi.NewInlineKeyboardButtonData(clarityBtn, "set_clarity"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(speedBtn, "set_speed"),
+			tgbotapi.NewInlineKeyboardButtonData(mergeBtn, "toggle_merge_audio"),
 		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(backBtn, "back_to_main_menu"),
-		),
-	)
+	}
+	if len(b.speech.Providers()) > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(providerBtn, "cycle_tts_provider"),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(backBtn, "back_to_main_menu"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (b *Bot) providerLabel(provider string) string {
+	if provider == "" {
+		return b.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "settings_value_all_providers"})
+	}
+	return provider
+}
+
+// nextProvider cycles the user's voice engine through "all" and each registered provider.
+func (b *Bot) nextProvider(current string) string {
+	options := append([]string{""}, b.speech.Providers()...)
+	for i, option := range options {
+		if option == current {
+			return options[(i+1)%len(options)]
+		}
+	}
+	return ""
+}
+
+func onOffMessageID(enabled bool) string {
+	if enabled {
+		return "settings_value_on"
+	}
+	return "settings_value_off"
+}
+
+func voiceSettings(userData *models.UserData) ai.VoiceSettings {
+	return ai.VoiceSettings{
+		Stability: userData.Stability,
+		Clarity:   userData.Clarity,
+		Speed:     userData.Speed,
+	}
 }
@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"video-script-bot/internal/models"
+	"video-script-bot/internal/script"
+	"video-script-bot/internal/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// historyLimit is how many script versions /history lists.
+const historyLimit = 10
+
+// maxMessageLength leaves room for markup under Telegram's 4096 character
+// limit.
+const maxMessageLength = 3800
+
+// recordScriptVersion adds the user's current script to their history.
+// History is a convenience, so failures are only logged.
+func (b *Bot) recordScriptVersion(userID int64, userData *models.UserData, version storage.ScriptVersion) {
+	version.UserID = userID
+	version.ProjectID = userData.ProjectID
+	version.Content = userData.GeneratedScript
+	version.Style = userData.ScriptStyle
+	if version.Provider == "" {
+		version.Provider = b.scriptGenerator.Name()
+	}
+	if err := b.db.AddScriptVersion(&version); err != nil {
+		log.Printf("Warning: could not save script history for user %d: %v", userID, err)
+	}
+}
+
+func (b *Bot) handleHistoryCommand(chatID, userID int64, userData *models.UserData) {
+	versions, err := b.db.ScriptVersions(userID, userData.ProjectID, historyLimit)
+	if err != nil {
+		log.Printf("Error loading script history of user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "history_error")
+		return
+	}
+	if len(versions) == 0 {
+		b.sendErrorMessage(chatID, "history_empty")
+		return
+	}
+
+	header, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    "history_header",
+		TemplateData: map[string]string{"Project": html.EscapeString(userData.ProjectName)},
+	})
+	lines := []string{header}
+	for _, version := range versions {
+		model := version.Model
+		if model == "" {
+			model = version.Provider
+		}
+		entry, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID: "history_entry",
+			TemplateData: map[string]string{
+				"Version": strconv.Itoa(version.Version),
+				"Source":  b.scriptSourceLabel(version),
+				"Time":    version.CreatedAt.Format("2006-01-02 15:04"),
+				"Model":   html.EscapeString(model),
+			},
+		})
+		lines = append(lines, entry)
+		if version.Instructions != "" {
+			lines = append(lines, fmt.Sprintf("<i>%s</i>", html.EscapeString(truncate(version.Instructions, 120))))
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, strings.Join(lines, "\n"))
+	msg.ParseMode = tgbotapi.ModeHTML
+	b.api.Send(msg)
+}
+
+func (b *Bot) scriptSourceLabel(version storage.ScriptVersion) string {
+	label, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    "history_source_" + version.Source,
+		TemplateData: map[string]int{"Version": version.RestoredFrom},
+	})
+	if label == "" {
+		return version.Source
+	}
+	return label
+}
+
+// handleDiffCommand compares two script versions, or one version with the
+// latest when only one is given.
+func (b *Bot) handleDiffCommand(message *tgbotapi.Message, userData *models.UserData) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 1 || len(args) > 2 {
+		b.sendHTMLMessage(chatID, "diff_usage", nil)
+		return
+	}
+	from, ok := b.loadScriptVersion(chatID, userID, userData.ProjectID, args[0])
+	if !ok {
+		return
+	}
+
+	var to *storage.ScriptVersion
+	if len(args) == 2 {
+		if to, ok = b.loadScriptVersion(chatID, userID, userData.ProjectID, args[1]); !ok {
+			return
+		}
+	} else {
+		latest, err := b.db.ScriptVersions(userID, userData.ProjectID, 1)
+		if err != nil || len(latest) == 0 {
+			log.Printf("Error loading the latest script of user %d: %v", userID, err)
+			b.sendErrorMessage(chatID, "history_error")
+			return
+		}
+		to = &latest[0]
+	}
+
+	versions := map[string]int{"From": from.Version, "To": to.Version}
+	diff := script.Diff(from.Content, to.Content)
+	changed := false
+	var lines []string
+	for _, line := range diff {
+		prefix := "  "
+		switch line.Op {
+		case script.DiffRemoved:
+			prefix, changed = "- ", true
+		case script.DiffAdded:
+			prefix, changed = "+ ", true
+		}
+		lines = append(lines, html.EscapeString(prefix+line.Text))
+	}
+	if !changed {
+		b.sendHTMLMessage(chatID, "diff_no_changes", versions)
+		return
+	}
+
+	header, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "diff_header", TemplateData: versions})
+	const layout = "%s\n\n<pre>%s</pre>"
+	room := maxMessageLength - utf8.RuneCountInString(fmt.Sprintf(layout, header, ""))
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(layout, header, joinLines(lines, room)))
+	msg.ParseMode = tgbotapi.ModeHTML
+	b.api.Send(msg)
+}
+
+// handleRestoreCommand makes an earlier script version the current script
+// again. The restored script is added to the history as a new version.
+func (b *Bot) handleRestoreCommand(message *tgbotapi.Message, userData *models.UserData) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 1 {
+		b.sendHTMLMessage(chatID, "restore_usage", nil)
+		return
+	}
+	old, ok := b.loadScriptVersion(chatID, userID, userData.ProjectID, args[0])
+	if !ok {
+		return
+	}
+
+	b.sendHTMLMessage(chatID, "script_restored", map[string]int{"Version": old.Version})
+	if old.Style != "" {
+		userData.ScriptStyle = old.Style
+	}
+	userData.State = models.StateIdle
+	b.db.SetUserData(userID, userData)
+	b.storeGeneratedScript(chatID, userID, userData, old.Content, storage.ScriptVersion{
+		Source:       storage.ScriptRestored,
+		Provider:     old.Provider,
+		Model:        old.Model,
+		RestoredFrom: old.Version,
+	})
+}
+
+// loadScriptVersion looks up the version of the project named by arg, telling
+// the user when the project has no such version.
+func (b *Bot) loadScriptVersion(chatID, userID, projectID int64, arg string) (*storage.ScriptVersion, bool) {
+	number, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(arg), "v"))
+	if err != nil || number < 1 {
+		b.sendHTMLMessage(chatID, "script_version_not_found", map[string]string{"Version": html.EscapeString(arg)})
+		return nil, false
+	}
+	version, err := b.db.ScriptVersion(userID, projectID, number)
+	if err != nil {
+		log.Printf("Error loading script version %d of user %d: %v", number, userID, err)
+		b.sendErrorMessage(chatID, "history_error")
+		return nil, false
+	}
+	if version == nil {
+		b.sendHTMLMessage(chatID, "script_version_not_found", map[string]string{"Version": strconv.Itoa(number)})
+		return nil, false
+	}
+	return version, true
+}
+
+func (b *Bot) sendHTMLMessage(chatID int64, messageID string, data interface{}) {
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID, TemplateData: data})
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	b.api.Send(msg)
+}
+
+// joinLines joins lines with newlines into at most limit runes. Lines that do
+// not fit are left out whole and replaced by a final "…" line, so escaped HTML
+// is never cut in half.
+func joinLines(lines []string, limit int) string {
+	text := strings.Join(lines, "\n")
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	const marker = "…"
+	size := utf8.RuneCountInString(marker)
+	var kept []string
+	for _, line := range lines {
+		size += utf8.RuneCountInString(line) + 1
+		if size > limit {
+			break
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(append(kept, marker), "\n")
+}
+
+// truncate shortens text to at most limit runes, marking the cut.
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"video-script-bot/internal/audio"
+	"video-script-bot/internal/jobs"
+	"video-script-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// Job types run by the queue.
+const (
+	jobGenerate   = "generate"
+	jobRevise     = "revise"
+	jobSynthesize = "synthesize"
+	jobMux        = "mux"
+)
+
+// jobPayload is what a job needs to run after a restart: the project it works
+// on and the arguments of the job type. The user's data is read again when the
+// job starts and before it saves anything, so changes the user makes while the
+// job runs are kept.
+type jobPayload struct {
+	ProjectID    int64              `json:"project_id"`
+	Instructions string             `json:"instructions,omitempty"`
+	VoiceID      string             `json:"voice_id,omitempty"`
+	AudioMode    audio.AudioMode    `json:"audio_mode,omitempty"`
+	SubtitleMode audio.SubtitleMode `json:"subtitle_mode,omitempty"`
+}
+
+func (b *Bot) registerJobHandlers() {
+	b.jobs.Handle(jobGenerate, func(ctx context.Context, job *jobs.Job) (string, error) {
+		_, userData, err := b.loadJob(job)
+		if err != nil {
+			return "", err
+		}
+		release, err := b.acquire(ctx, b.aiPool, job.ChatID)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return b.generateScript(ctx, job.ChatID, job.UserID, userData)
+	})
+	b.jobs.Handle(jobRevise, func(ctx context.Context, job *jobs.Job) (string, error) {
+		payload, userData, err := b.loadJob(job)
+		if err != nil {
+			return "", err
+		}
+		release, err := b.acquire(ctx, b.aiPool, job.ChatID)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return b.reviseScript(ctx, job.ChatID, job.UserID, payload.Instructions, userData)
+	})
+	b.jobs.Handle(jobSynthesize, func(ctx context.Context, job *jobs.Job) (string, error) {
+		payload, userData, err := b.loadJob(job)
+		if err != nil {
+			return "", err
+		}
+		release, err := b.acquire(ctx, b.ttsPool, job.ChatID)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return b.generateAndSendAudio(ctx, job.ChatID, job.UserID, payload.VoiceID, userData)
+	})
+	b.jobs.Handle(jobMux, func(ctx context.Context, job *jobs.Job) (string, error) {
+		payload, userData, err := b.loadJob(job)
+		if err != nil {
+			return "", err
+		}
+		return "", b.muxNarration(ctx, job.ChatID, job.UserID, userData, payload.AudioMode, payload.SubtitleMode)
+	})
+}
+
+// submitJob queues background work for the user, replacing any job they
+// already have running.
+func (b *Bot) submitJob(jobType string, chatID, userID int64, payload jobPayload) {
+	data, err := json.Marshal(payload)
+	if err == nil {
+		err = b.jobs.Submit(&jobs.Job{Type: jobType, UserID: userID, ChatID: chatID, Payload: data})
+	}
+	if err != nil {
+		log.Printf("Could not queue %s job for user %d: %v", jobType, userID, err)
+		b.sendErrorMessage(chatID, "job_queue_error")
+	}
+}
+
+// loadJob decodes a job's payload, reads the user's current data for the
+// job's project and tells the user when a job interrupted by a restart is
+// picked up again.
+func (b *Bot) loadJob(job *jobs.Job) (*jobPayload, *models.UserData, error) {
+	var payload jobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, nil, fmt.Errorf("invalid payload for %s job %d: %w", job.Type, job.ID, err)
+	}
+	userData, err := b.projectData(job.UserID, payload.ProjectID)
+	if errors.Is(err, errProjectGone) {
+		b.sendErrorMessage(job.ChatID, "job_project_gone")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Attempts > 1 {
+		log.Printf("Resuming %s job %d for user %d (attempt %d).", job.Type, job.ID, job.UserID, job.Attempts)
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "job_resumed"})
+		b.api.Send(tgbotapi.NewMessage(job.ChatID, text))
+	}
+	return &payload, userData, nil
+}
+
+// errProjectGone means the project a job was for has been deleted.
+var errProjectGone = errors.New("project no longer exists")
+
+// projectData returns the user's current data with the fields of projectID,
+// which need not be their active project any more.
+func (b *Bot) projectData(userID, projectID int64) (*models.UserData, error) {
+	userData, err := b.db.GetUserData(userID)
+	if err != nil || userData.ProjectID == projectID {
+		return userData, err
+	}
+	project, err := b.db.Project(userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, fmt.Errorf("project %d of user %d: %w", projectID, userID, errProjectGone)
+	}
+	project.ApplyTo(userData)
+	return userData, nil
+}
+
+// updateProject reads the user's data for projectID again, applies change and
+// saves it. Jobs save their results this way, so that only the fields they
+// produce are replaced.
+func (b *Bot) updateProject(userID, projectID int64, change func(userData *models.UserData)) (*models.UserData, error) {
+	userData, err := b.projectData(userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	change(userData)
+	return userData, b.db.SetUserData(userID, userData)
+}
@@ -0,0 +1,337 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"video-script-bot/internal/models"
+	"video-script-bot/internal/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// maxProjectNameLength is the longest project name accepted, in characters.
+const maxProjectNameLength = 64
+
+func (b *Bot) handleProjectsCommand(chatID, userID int64, userData *models.UserData) {
+	b.sendProjectsMenu(chatID, userID, userData, false, 0)
+}
+
+// sendProjectsMenu lists the user's projects, or their archived ones. With a
+// messageID the menu replaces that message.
+func (b *Bot) sendProjectsMenu(chatID, userID int64, userData *models.UserData, archived bool, messageID int) {
+	projects, err := b.db.Projects(userID, archived)
+	if err != nil {
+		log.Printf("Error loading projects of user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return
+	}
+
+	headerID := "projects_header"
+	if archived {
+		headerID = "projects_archived_header"
+		if len(projects) == 0 {
+			headerID = "projects_archived_empty"
+		}
+	}
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    headerID,
+		TemplateData: map[string]string{"Name": html.EscapeString(userData.ProjectName)},
+	})
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, project := range projects {
+		label := project.Name
+		if project.ID == userData.ProjectID {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("project_open_%d", project.ID)),
+		))
+	}
+	if archived {
+		backText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_back"})
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(backText, "projects"),
+		))
+	} else {
+		newText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_project_new"})
+		archivedText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_projects_archived"})
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(newText, "project_new"),
+			tgbotapi.NewInlineKeyboardButtonData(archivedText, "projects_archived"),
+		))
+	}
+
+	b.sendOrEditMenu(chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// sendProjectDetail shows a project with the actions that apply to it.
+func (b *Bot) sendProjectDetail(chatID int64, userData *models.UserData, project *storage.Project, messageID int) {
+	videoText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "project_no_video"})
+	if project.VideoFileID != "" {
+		videoText, _ = b.localizer.Localize(&i18n.LocalizeConfig{
+			MessageID:    "project_video",
+			TemplateData: map[string]int{"Seconds": project.VideoDuration},
+		})
+	}
+	scriptID := "project_no_script"
+	if project.GeneratedScript != "" {
+		scriptID = "project_script"
+	}
+	scriptText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: scriptID})
+	narrationID := "project_no_narration"
+	if project.NarrationFileID != "" {
+		narrationID = "project_narration"
+	}
+	narrationText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: narrationID})
+
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID: "project_detail",
+		TemplateData: map[string]string{
+			"Name":      html.EscapeString(project.Name),
+			"Video":     videoText,
+			"Script":    scriptText,
+			"Voice":     html.EscapeString(b.voiceName(project.VoiceID)),
+			"Narration": narrationText,
+			"Updated":   project.UpdatedAt.Format("2006-01-02 15:04"),
+		},
+	})
+
+	button := func(messageID, data string) tgbotapi.InlineKeyboardButton {
+		label, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: messageID})
+		return tgbotapi.NewInlineKeyboardButtonData(label, data)
+	}
+	id := project.ID
+	var rows [][]tgbotapi.InlineKeyboardButton
+	switch {
+	case project.Archived:
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(button("button_project_unarchive", fmt.Sprintf("project_unarchive_%d", id))),
+			tgbotapi.NewInlineKeyboardRow(button("button_project_delete", fmt.Sprintf("project_delete_%d", id))),
+			tgbotapi.NewInlineKeyboardRow(button("button_back", "projects_archived")),
+		)
+	case id == userData.ProjectID:
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(
+				button("button_project_rename", "project_rename"),
+				button("button_project_archive", fmt.Sprintf("project_archive_%d", id)),
+			),
+			tgbotapi.NewInlineKeyboardRow(button("button_project_delete", fmt.Sprintf("project_delete_%d", id))),
+			tgbotapi.NewInlineKeyboardRow(button("button_back", "projects")),
+		)
+	default:
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(button("button_project_switch", fmt.Sprintf("project_switch_%d", id))),
+			tgbotapi.NewInlineKeyboardRow(
+				button("button_project_archive", fmt.Sprintf("project_archive_%d", id)),
+				button("button_project_delete", fmt.Sprintf("project_delete_%d", id)),
+			),
+			tgbotapi.NewInlineKeyboardRow(button("button_back", "projects")),
+		)
+	}
+
+	b.sendOrEditMenu(chatID, messageID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// handleProjectCallback handles the buttons of the projects menu.
+func (b *Bot) handleProjectCallback(callback *tgbotapi.CallbackQuery, userData *models.UserData) {
+	chatID := callback.Message.Chat.ID
+	userID := callback.From.ID
+	messageID := callback.Message.MessageID
+
+	switch callback.Data {
+	case "projects":
+		b.sendProjectsMenu(chatID, userID, userData, false, messageID)
+		return
+	case "projects_archived":
+		b.sendProjectsMenu(chatID, userID, userData, true, messageID)
+		return
+	case "project_new":
+		b.startNewProject(chatID, userID, userData)
+		return
+	case "project_rename":
+		b.promptForProjectName(chatID, userID, userData)
+		return
+	}
+
+	action, idText, found := strings.Cut(strings.TrimPrefix(callback.Data, "project_"), "_")
+	projectID, err := strconv.ParseInt(idText, 10, 64)
+	if !found || err != nil {
+		log.Printf("Received unknown callback data: %s", callback.Data)
+		return
+	}
+	project, err := b.db.Project(userID, projectID)
+	if err != nil {
+		log.Printf("Error loading project %d of user %d: %v", projectID, userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return
+	}
+	if project == nil {
+		b.sendErrorMessage(chatID, "project_not_found")
+		b.sendProjectsMenu(chatID, userID, userData, false, messageID)
+		return
+	}
+	name := map[string]string{"Name": html.EscapeString(project.Name)}
+
+	switch action {
+	case "open":
+		b.sendProjectDetail(chatID, userData, project, messageID)
+	case "switch":
+		if err := b.db.SetActiveProject(userID, project.ID); err != nil {
+			log.Printf("Error switching user %d to project %d: %v", userID, project.ID, err)
+			b.sendErrorMessage(chatID, "database_error")
+			return
+		}
+		project.ApplyTo(userData)
+		userData.State = models.StateIdle
+		b.db.SetUserData(userID, userData)
+		b.sendProjectsMenu(chatID, userID, userData, false, messageID)
+		b.sendHTMLMessage(chatID, "project_switched", name)
+		if userData.GeneratedScript != "" {
+			b.sendScriptMessage(chatID, userData.GeneratedScript)
+		}
+	case "archive", "unarchive":
+		archived := action == "archive"
+		if err := b.db.SetProjectArchived(userID, project.ID, archived); err != nil {
+			log.Printf("Error archiving project %d of user %d: %v", project.ID, userID, err)
+			b.sendErrorMessage(chatID, "database_error")
+			return
+		}
+		b.reloadActiveProject(chatID, userID, userData)
+		b.sendProjectsMenu(chatID, userID, userData, !archived, messageID)
+		b.sendHTMLMessage(chatID, "project_"+action+"d", name)
+	case "delete":
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "project_delete_confirm", TemplateData: name})
+		confirmText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_project_delete_confirm"})
+		backText, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "button_back"})
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(confirmText, fmt.Sprintf("project_deleteconfirmed_%d", project.ID))),
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(backText, fmt.Sprintf("project_open_%d", project.ID))),
+		)
+		b.sendOrEditMenu(chatID, messageID, text, keyboard)
+	case "deleteconfirmed":
+		if err := b.db.DeleteProject(userID, project.ID); err != nil {
+			log.Printf("Error deleting project %d of user %d: %v", project.ID, userID, err)
+			b.sendErrorMessage(chatID, "database_error")
+			return
+		}
+		b.reloadActiveProject(chatID, userID, userData)
+		b.sendProjectsMenu(chatID, userID, userData, project.Archived, messageID)
+		b.sendHTMLMessage(chatID, "project_deleted", name)
+	default:
+		log.Printf("Received unknown callback data: %s", callback.Data)
+	}
+}
+
+// reloadActiveProject reloads the user's data after their projects changed,
+// which moves them to another project if the active one was archived or
+// deleted.
+func (b *Bot) reloadActiveProject(chatID, userID int64, userData *models.UserData) {
+	fresh, err := b.db.GetUserData(userID)
+	if err != nil {
+		log.Printf("Error reloading data of user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return
+	}
+	*userData = *fresh
+}
+
+// startNewProject creates an empty project, makes it active and asks for its
+// video.
+func (b *Bot) startNewProject(chatID, userID int64, userData *models.UserData) {
+	if !b.switchToNewProject(chatID, userID, userData) {
+		return
+	}
+	b.sendHTMLMessage(chatID, "project_created", map[string]string{"Name": html.EscapeString(userData.ProjectName)})
+	b.promptForVideoUpload(chatID, userData)
+}
+
+// switchToNewProject creates an empty project and makes it the active one,
+// leaving the previous project as it was. It reports whether it succeeded.
+func (b *Bot) switchToNewProject(chatID, userID int64, userData *models.UserData) bool {
+	project, err := b.db.CreateProject(userID, "")
+	if err == nil {
+		err = b.db.SetActiveProject(userID, project.ID)
+	}
+	if err != nil {
+		log.Printf("Error creating a project for user %d: %v", userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return false
+	}
+	project.ApplyTo(userData)
+	return true
+}
+
+func (b *Bot) promptForProjectName(chatID, userID int64, userData *models.UserData) {
+	text, _ := b.localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    "project_rename_prompt",
+		TemplateData: map[string]string{"Name": html.EscapeString(userData.ProjectName)},
+	})
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = b.getCancelKeyboard()
+	b.api.Send(msg)
+
+	userData.State = models.StateWaitingForProjectName
+	b.db.SetUserData(userID, userData)
+}
+
+func (b *Bot) handleProjectNameInput(message *tgbotapi.Message, userData *models.UserData) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	name := strings.TrimSpace(message.Text)
+	if name == "" || utf8.RuneCountInString(name) > maxProjectNameLength {
+		b.sendHTMLMessage(chatID, "project_name_invalid", map[string]int{"Max": maxProjectNameLength})
+		return
+	}
+	if err := b.db.RenameProject(userID, userData.ProjectID, name); err != nil {
+		log.Printf("Error renaming project %d of user %d: %v", userData.ProjectID, userID, err)
+		b.sendErrorMessage(chatID, "database_error")
+		return
+	}
+
+	userData.ProjectName = name
+	userData.State = models.StateIdle
+	b.db.SetUserData(userID, userData)
+
+	b.sendHTMLMessage(chatID, "project_renamed", map[string]string{"Name": html.EscapeString(name)})
+	b.sendProjectsMenu(chatID, userID, userData, false, 0)
+}
+
+// voiceName returns the name of a voice of any provider, or a placeholder
+// when no voice has been chosen.
+func (b *Bot) voiceName(voiceID string) string {
+	if voiceID == "" {
+		text, _ := b.localizer.Localize(&i18n.LocalizeConfig{MessageID: "project_no_voice"})
+		return text
+	}
+	for _, provider := range b.speech.Providers() {
+		for _, voice := range b.speech.Voices(provider) {
+			if voice.VoiceID == voiceID {
+				return voice.Name
+			}
+		}
+	}
+	return voiceID
+}
+
+// sendOrEditMenu sends an HTML menu, or replaces the message messageID with it.
+func (b *Bot) sendOrEditMenu(chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyMarkup = keyboard
+		b.api.Send(msg)
+		return
+	}
+	editMsg := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	editMsg.ParseMode = tgbotapi.ModeHTML
+	editMsg.ReplyMarkup = &keyboard
+	b.api.Send(editMsg)
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// receiveUpdates starts receiving updates and returns them with a function
+// that stops receiving and closes the channel.
+//
+// Telegram lets only one process poll a bot for updates; a second one gets
+// 409 Conflict. Replicas sharing a bot therefore run in webhook mode, with
+// TELEGRAM_WEBHOOK_URL pointing at a load balancer in front of all of them.
+func (b *Bot) receiveUpdates() (tgbotapi.UpdatesChannel, func(), error) {
+	if b.cfg.TelegramWebhookURL == "" {
+		// A webhook left behind by an earlier deployment makes polling fail.
+		if _, err := b.api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("Warning: could not remove the Telegram webhook: %v", err)
+		}
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+		return b.api.GetUpdatesChan(u), b.api.StopReceivingUpdates, nil
+	}
+	return b.listenForWebhook()
+}
+
+// listenForWebhook registers TELEGRAM_WEBHOOK_URL with Telegram and serves
+// it on TELEGRAM_WEBHOOK_LISTEN. The URL's path should contain a secret,
+// since anyone who knows it can send the bot updates.
+func (b *Bot) listenForWebhook() (tgbotapi.UpdatesChannel, func(), error) {
+	link, err := url.Parse(b.cfg.TelegramWebhookURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid TELEGRAM_WEBHOOK_URL: %w", err)
+	}
+	webhook, err := tgbotapi.NewWebhook(b.cfg.TelegramWebhookURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid TELEGRAM_WEBHOOK_URL: %w", err)
+	}
+	if _, err := b.api.Request(webhook); err != nil {
+		return nil, nil, fmt.Errorf("failed to set the Telegram webhook: %w", err)
+	}
+
+	updates := make(chan tgbotapi.Update, b.api.Buffer)
+	stopping := make(chan struct{})
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		update, err := b.api.HandleUpdate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- *update:
+		case <-stopping:
+			// Telegram sends the update again, possibly to another replica.
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+	server := &http.Server{Addr: b.cfg.TelegramWebhookListen, Handler: mux}
+
+	stop := sync.OnceFunc(func() {
+		close(stopping)
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("Warning: could not stop the webhook server: %v", err)
+		}
+		close(updates)
+	})
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error: webhook server stopped: %v", err)
+			stop()
+		}
+	}()
+	log.Printf("Receiving updates from the Telegram webhook on %s%s.", b.cfg.TelegramWebhookListen, path)
+	return updates, stop, nil
+}
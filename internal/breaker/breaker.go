@@ -0,0 +1,221 @@
+// Package breaker stops calls to an upstream service that keeps failing, so
+// requests fail fast instead of each one walking through every key and proxy
+// before giving up.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOpen is matched by every OpenError.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned while a breaker rejects calls.
+type OpenError struct {
+	Name    string
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s is temporarily unavailable until %s", e.Name, e.RetryAt.Format(time.TimeOnly))
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// State is the position of a breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open rejects calls until its open period ends.
+	Open
+	// HalfOpen lets a single probe call through to test the service.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Settings configure a breaker. A FailureThreshold of 0 disables it.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker.
+	FailureThreshold int
+	// OpenDuration is how long the breaker stays open before a probe is let
+	// through.
+	OpenDuration time.Duration
+}
+
+// Breaker counts consecutive failures of one service. After FailureThreshold
+// failures it opens and rejects calls for OpenDuration. Then it lets one probe
+// through: a success closes it again and a failure reopens it.
+type Breaker struct {
+	name     string
+	settings Settings
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	retryAt  time.Time
+	probing  bool
+}
+
+// New creates a closed breaker for the named service.
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{name: name, settings: settings, now: time.Now}
+}
+
+// Ready reports whether Allow would let a call through, without reserving
+// the half-open probe.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ready(b.now())
+}
+
+func (b *Breaker) ready(now time.Time) bool {
+	switch b.state {
+	case Open:
+		return !now.Before(b.retryAt)
+	case HalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// Allow returns nil if a call may go ahead, or an *OpenError. Once the open
+// period is over the first caller becomes the probe, and every call that is
+// allowed must be followed by Success, Failure or Skip.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.ready(now) {
+		return &OpenError{Name: b.name, RetryAt: b.retryAt}
+	}
+	if b.state == Open {
+		b.state = HalfOpen
+		log.Printf("Circuit breaker for %s is half-open, probing.", b.name)
+	}
+	if b.state == HalfOpen {
+		b.probing = true
+	}
+	return nil
+}
+
+// Success closes the breaker and resets its failure count.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Closed {
+		log.Printf("Circuit breaker for %s closed after a successful probe.", b.name)
+	}
+	b.state = Closed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure counts a failed call, opening the breaker at the threshold or when
+// the half-open probe fails.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.settings.FailureThreshold <= 0 {
+		return
+	}
+	b.failures++
+	b.probing = false
+	if b.state == HalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.state = Open
+		b.retryAt = b.now().Add(b.settings.OpenDuration)
+		log.Printf("WARNING: Circuit breaker for %s opened after %d consecutive failure(s). Retrying at %s.", b.name, b.failures, b.retryAt.Format(time.TimeOnly))
+	}
+}
+
+// Skip ends an allowed call that says nothing about the service, such as one
+// cancelled by the user, freeing the probe slot if it held it.
+func (b *Breaker) Skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Status is a snapshot of a breaker, safe to show to admins.
+type Status struct {
+	Name     string
+	State    State
+	Failures int
+	RetryAt  time.Time
+}
+
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Status{Name: b.name, State: b.state, Failures: b.failures, RetryAt: b.retryAt}
+}
+
+// Set holds one breaker per upstream provider, created on first use.
+type Set struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewSet(settings Settings) *Set {
+	return &Set{settings: settings, breakers: make(map[string]*Breaker)}
+}
+
+// Settings returns the settings every breaker in the set uses.
+func (s *Set) Settings() Settings {
+	return s.settings
+}
+
+// Get returns the breaker for name.
+func (s *Set) Get(name string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	breaker, ok := s.breakers[name]
+	if !ok {
+		breaker = New(name, s.settings)
+		s.breakers[name] = breaker
+	}
+	return breaker
+}
+
+// Statuses returns the status of every breaker, sorted by name.
+func (s *Set) Statuses() []Status {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, breaker := range s.breakers {
+		breakers = append(breakers, breaker)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,199 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a time source that only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// step is one thing that happens to a breaker in a transition test.
+type step struct {
+	// do is "allow", "success", "failure", "skip" or "wait".
+	do string
+	// wait is how far the clock moves for "wait".
+	wait time.Duration
+	// rejected says "allow" should fail.
+	rejected bool
+	// state and failures are checked after the step.
+	state    State
+	failures int
+}
+
+func TestTransitions(t *testing.T) {
+	settings := Settings{FailureThreshold: 2, OpenDuration: time.Minute}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "closed until the threshold",
+			steps: []step{
+				{do: "allow", state: Closed},
+				{do: "failure", state: Closed, failures: 1},
+				{do: "allow", state: Closed, failures: 1},
+				{do: "failure", state: Open, failures: 2},
+				{do: "allow", rejected: true, state: Open, failures: 2},
+			},
+		},
+		{
+			name: "success resets the count",
+			steps: []step{
+				{do: "failure", state: Closed, failures: 1},
+				{do: "success", state: Closed},
+				{do: "failure", state: Closed, failures: 1},
+				{do: "skip", state: Closed, failures: 1},
+				{do: "failure", state: Open, failures: 2},
+			},
+		},
+		{
+			name: "open until the open duration ends",
+			steps: []step{
+				{do: "failure", failures: 1},
+				{do: "failure", state: Open, failures: 2},
+				{do: "wait", wait: 59 * time.Second, state: Open, failures: 2},
+				{do: "allow", rejected: true, state: Open, failures: 2},
+				{do: "wait", wait: time.Second, state: Open, failures: 2},
+				{do: "allow", state: HalfOpen, failures: 2},
+			},
+		},
+		{
+			name: "successful probe closes",
+			steps: []step{
+				{do: "failure", failures: 1},
+				{do: "failure", state: Open, failures: 2},
+				{do: "wait", wait: time.Minute, state: Open, failures: 2},
+				{do: "allow", state: HalfOpen, failures: 2},
+				{do: "success", state: Closed},
+				{do: "allow", state: Closed},
+			},
+		},
+		{
+			name: "failed probe reopens",
+			steps: []step{
+				{do: "failure", failures: 1},
+				{do: "failure", state: Open, failures: 2},
+				{do: "wait", wait: time.Minute, state: Open, failures: 2},
+				{do: "allow", state: HalfOpen, failures: 2},
+				{do: "failure", state: Open, failures: 3},
+				{do: "allow", rejected: true, state: Open, failures: 3},
+				{do: "wait", wait: time.Minute, state: Open, failures: 3},
+				{do: "allow", state: HalfOpen, failures: 3},
+			},
+		},
+		{
+			name: "single half-open probe",
+			steps: []step{
+				{do: "failure", failures: 1},
+				{do: "failure", state: Open, failures: 2},
+				{do: "wait", wait: time.Minute, state: Open, failures: 2},
+				{do: "allow", state: HalfOpen, failures: 2},
+				{do: "allow", rejected: true, state: HalfOpen, failures: 2},
+				{do: "wait", wait: time.Hour, state: HalfOpen, failures: 2},
+				{do: "allow", rejected: true, state: HalfOpen, failures: 2},
+				// A skipped probe frees the slot for the next caller.
+				{do: "skip", state: HalfOpen, failures: 2},
+				{do: "allow", state: HalfOpen, failures: 2},
+				{do: "allow", rejected: true, state: HalfOpen, failures: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: start}
+			b := New("test", settings)
+			b.now = clock.Now
+
+			for i, s := range tt.steps {
+				switch s.do {
+				case "allow":
+					err := b.Allow()
+					if rejected := err != nil; rejected != s.rejected {
+						t.Fatalf("step %d: Allow() = %v, want rejected %v", i, err, s.rejected)
+					}
+					if err != nil && !errors.Is(err, ErrOpen) {
+						t.Fatalf("step %d: Allow() = %v, want ErrOpen", i, err)
+					}
+					if ready := b.Ready(); err != nil && ready {
+						t.Fatalf("step %d: Ready() = true after a rejected Allow", i)
+					}
+				case "success":
+					b.Success()
+				case "failure":
+					b.Failure()
+				case "skip":
+					b.Skip()
+				case "wait":
+					clock.now = clock.now.Add(s.wait)
+				}
+				status := b.Status()
+				if status.State != s.state || status.Failures != s.failures {
+					t.Fatalf("step %d (%s): state %s with %d failures, want %s with %d", i, s.do, status.State, status.Failures, s.state, s.failures)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	clock := &fakeClock{now: start}
+	b := New("gemini", Settings{FailureThreshold: 1, OpenDuration: 90 * time.Second})
+	b.now = clock.Now
+
+	clock.now = start.Add(10 * time.Second)
+	b.Failure()
+	wantRetryAt := start.Add(100 * time.Second)
+	if got := b.Status().RetryAt; !got.Equal(wantRetryAt) {
+		t.Fatalf("RetryAt = %v, want %v", got, wantRetryAt)
+	}
+
+	var openErr *OpenError
+	if err := b.Allow(); !errors.As(err, &openErr) || openErr.Name != "gemini" || !openErr.RetryAt.Equal(wantRetryAt) {
+		t.Fatalf("Allow() = %v, want an OpenError retrying at %v", err, wantRetryAt)
+	}
+
+	// A failed probe starts a new open period from the time it failed.
+	clock.now = wantRetryAt.Add(5 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after the open period = %v", err)
+	}
+	b.Failure()
+	if got, want := b.Status().RetryAt, clock.now.Add(90*time.Second); !got.Equal(want) {
+		t.Fatalf("RetryAt after a failed probe = %v, want %v", got, want)
+	}
+}
+
+func TestDisabledBreakerNeverOpens(t *testing.T) {
+	b := New("test", Settings{})
+	for i := 0; i < 100; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("disabled breaker rejected call %d: %v", i, err)
+		}
+		b.Failure()
+	}
+	if status := b.Status(); status.State != Closed || status.Failures != 0 {
+		t.Fatalf("disabled breaker status = %+v", status)
+	}
+}
+
+func TestSetSharesBreakersByName(t *testing.T) {
+	set := NewSet(Settings{FailureThreshold: 1, OpenDuration: time.Minute})
+	set.Get("tts").Failure()
+	if set.Get("tts").Allow() == nil {
+		t.Fatal("Get returned a new breaker for a known name")
+	}
+	set.Get("gemini")
+	statuses := set.Statuses()
+	if len(statuses) != 2 || statuses[0].Name != "gemini" || statuses[1].Name != "tts" || statuses[1].State != Open {
+		t.Fatalf("Statuses = %+v, want gemini then an open tts", statuses)
+	}
+}
@@ -5,21 +5,70 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"video-script-bot/internal/ai"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	TelegramBotToken  string
-	GeminiAPIKeys     []string
-	ElevenLabsAPIKeys []string
-	ElevenLabsModelID string
-	DefaultLang       string
-	DatabasePath      string
-	StorageChannelID  int64
-	SaweriaLink       string
-	BuyMeACoffeeLink  string
-	ProxyURL          string 
+	TelegramBotToken            string
+	GeminiAPIKeys               []string
+	ElevenLabsAPIKeys           []string
+	ElevenLabsModelID           string
+	DefaultLang                 string
+	DatabaseDriver              string
+	DatabasePath                string
+	DatabaseURL                 string
+	DatabaseAutoMigrate         bool
+	StateCacheTTLSeconds        int
+	StateFlushSeconds           int
+	StorageChannelID            int64
+	SaweriaLink                 string
+	BuyMeACoffeeLink            string
+	ProxyURL                    string
+	GeminiJSONOutput            bool
+	GeminiMaxValidationAttempts int
+	SubtitleMaxChars            int
+	FFmpegPath                  string
+	FFprobePath                 string
+	AudioOverrunPolicy          string
+	TelegramDownloadLimitMB     int
+	TelegramUploadLimitMB       int
+	VoicesFile                  string
+	LocalTTSEngine              string
+	LocalTTSBinary              string
+	LocalTTSModelDir            string
+	ScriptProvider              string
+	OpenAIBaseURL               string
+	OpenAIModel                 string
+	OpenAIAPIKeys               []string
+	OpenAIVideoInput            bool
+	OpenAIMaxUploadMB           int
+	GeminiGeneration            ai.GeminiGeneration
+	GeminiStyleOverrides        map[string]ai.GeminiGeneration
+	GeminiInlineLimitMB         int
+	GeminiAPIEndpoint           string
+	AdminUserIDs                []int64
+	ReloadFile                  string
+	ReloadIntervalSeconds       int
+	ProxyURLs                   []string
+	ProxyHealthURL              string
+	ProxyHealthIntervalSeconds  int
+	ProxyHealthTimeoutSeconds   int
+	ProxyRoutes                 map[string]string
+	BreakerFailureThreshold     int
+	BreakerOpenSeconds          int
+	JobWorkers                  int
+	JobMaxAttempts              int
+	UpdateWorkers               int
+	UpdateQueue                 int
+	AIWorkers                   int
+	AIQueue                     int
+	TTSWorkers                  int
+	TTSQueue                    int
+	ShutdownTimeoutSeconds      int
+	TelegramWebhookURL          string
+	TelegramWebhookListen       string
 }
 
 func LoadConfig() *Config {
@@ -27,7 +76,8 @@ func LoadConfig() *Config {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	geminiKeys := strings.Split(getEnv("GEMINI_API_KEYS", "", true), ",")
+	scriptProvider := getEnv("SCRIPT_PROVIDER", "gemini", false)
+	geminiKeys := strings.Split(getEnv("GEMINI_API_KEYS", "", scriptProvider == "gemini"), ",")
 	elevenKeys := strings.Split(getEnv("ELEVENLABS_API_KEYS", "", true), ",")
 	token := getEnv("TELEGRAM_BOT_TOKEN", "", true)
 	storageIDStr := getEnv("STORAGE_CHANNEL_ID", "", true)
@@ -38,16 +88,64 @@ func LoadConfig() *Config {
 	}
 
 	return &Config{
-		TelegramBotToken:  token,
-		GeminiAPIKeys:     geminiKeys,
-		ElevenLabsAPIKeys: elevenKeys,
-		ElevenLabsModelID: getEnv("ELEVENLABS_MODEL_ID", "eleven_multilingual_v2", false),
-		DefaultLang:       getEnv("DEFAULT_LANG", "en", false),
-		DatabasePath:      getEnv("DATABASE_PATH", "./bot_data.db", false),
-		StorageChannelID:  storageID,
-		SaweriaLink:       getEnv("SAWERIA_LINK", "", false),
-		BuyMeACoffeeLink:  getEnv("BUYMEACOFFEE_LINK", "", false),
-		ProxyURL:          getEnv("PROXY_URL", "", false), 
+		TelegramBotToken:            token,
+		GeminiAPIKeys:               geminiKeys,
+		ElevenLabsAPIKeys:           elevenKeys,
+		ElevenLabsModelID:           getEnv("ELEVENLABS_MODEL_ID", "eleven_multilingual_v2", false),
+		DefaultLang:                 getEnv("DEFAULT_LANG", "en", false),
+		DatabaseDriver:              strings.ToLower(getEnv("DATABASE_DRIVER", "sqlite", false)),
+		DatabasePath:                getEnv("DATABASE_PATH", "./bot_data.db", false),
+		DatabaseURL:                 getEnv("DATABASE_URL", "", false),
+		DatabaseAutoMigrate:         getEnvBool("DATABASE_AUTO_MIGRATE", true),
+		StateCacheTTLSeconds:        getEnvInt("STATE_CACHE_TTL_SECONDS", 600),
+		StateFlushSeconds:           getEnvInt("STATE_FLUSH_SECONDS", 2),
+		StorageChannelID:            storageID,
+		SaweriaLink:                 getEnv("SAWERIA_LINK", "", false),
+		BuyMeACoffeeLink:            getEnv("BUYMEACOFFEE_LINK", "", false),
+		ProxyURL:                    getEnv("PROXY_URL", "", false),
+		GeminiJSONOutput:            getEnvBool("GEMINI_JSON_OUTPUT", true),
+		GeminiMaxValidationAttempts: getEnvInt("GEMINI_MAX_VALIDATION_ATTEMPTS", 3),
+		SubtitleMaxChars:            getEnvInt("SUBTITLE_MAX_CHARS", 84),
+		FFmpegPath:                  getEnv("FFMPEG_PATH", "ffmpeg", false),
+		FFprobePath:                 getEnv("FFPROBE_PATH", "ffprobe", false),
+		AudioOverrunPolicy:          getEnv("AUDIO_OVERRUN_POLICY", "warn", false),
+		TelegramDownloadLimitMB:     getEnvInt("TELEGRAM_DOWNLOAD_LIMIT_MB", 20),
+		TelegramUploadLimitMB:       getEnvInt("TELEGRAM_UPLOAD_LIMIT_MB", 50),
+		VoicesFile:                  getEnv("VOICES_FILE", "voices.json", false),
+		LocalTTSEngine:              getEnv("LOCAL_TTS_ENGINE", "", false),
+		LocalTTSBinary:              getEnv("LOCAL_TTS_BINARY", "", false),
+		LocalTTSModelDir:            getEnv("LOCAL_TTS_MODEL_DIR", "./piper-voices", false),
+		ScriptProvider:              scriptProvider,
+		OpenAIBaseURL:               getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1", false),
+		OpenAIModel:                 getEnv("OPENAI_MODEL", "gpt-4o-mini", false),
+		OpenAIAPIKeys:               strings.Split(getEnv("OPENAI_API_KEYS", "", false), ","),
+		OpenAIVideoInput:            getEnvBool("OPENAI_VIDEO_INPUT", false),
+		OpenAIMaxUploadMB:           getEnvInt("OPENAI_MAX_UPLOAD_MB", 20),
+		GeminiGeneration:            loadGeminiGeneration(),
+		GeminiStyleOverrides:        loadGeminiStyleOverrides(),
+		GeminiInlineLimitMB:         getEnvInt("GEMINI_INLINE_LIMIT_MB", 15),
+		GeminiAPIEndpoint:           getEnv("GEMINI_API_ENDPOINT", "", false),
+		AdminUserIDs:                getEnvInt64List("ADMIN_USER_IDS"),
+		ReloadFile:                  getEnv("RELOAD_FILE", ".env", false),
+		ReloadIntervalSeconds:       getEnvInt("RELOAD_INTERVAL_SECONDS", 30),
+		ProxyURLs:                   getEnvList("PROXY_URLS"),
+		ProxyHealthURL:              getEnv("PROXY_HEALTH_URL", "https://www.gstatic.com/generate_204", false),
+		ProxyHealthIntervalSeconds:  getEnvInt("PROXY_HEALTH_INTERVAL_SECONDS", 60),
+		ProxyHealthTimeoutSeconds:   getEnvInt("PROXY_HEALTH_TIMEOUT_SECONDS", 10),
+		ProxyRoutes:                 loadProxyRoutes(),
+		BreakerFailureThreshold:     getEnvInt("BREAKER_FAILURE_THRESHOLD", 5),
+		BreakerOpenSeconds:          getEnvInt("BREAKER_OPEN_SECONDS", 60),
+		JobWorkers:                  getEnvInt("JOB_WORKERS", 4),
+		JobMaxAttempts:              getEnvInt("JOB_MAX_ATTEMPTS", 3),
+		UpdateWorkers:               getEnvInt("UPDATE_WORKERS", 16),
+		UpdateQueue:                 getEnvInt("UPDATE_QUEUE", 100),
+		AIWorkers:                   getEnvInt("AI_WORKERS", 2),
+		AIQueue:                     getEnvInt("AI_QUEUE", 20),
+		TTSWorkers:                  getEnvInt("TTS_WORKERS", 4),
+		TTSQueue:                    getEnvInt("TTS_QUEUE", 50),
+		ShutdownTimeoutSeconds:      getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30),
+		TelegramWebhookURL:          getEnv("TELEGRAM_WEBHOOK_URL", "", false),
+		TelegramWebhookListen:       getEnv("TELEGRAM_WEBHOOK_LISTEN", ":8080", false),
 	}
 }
 
@@ -70,3 +168,77 @@ func getEnv(key, fallback string, required bool) string {
 
 	return value
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	value := getEnv(key, "", false)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid boolean for %s (%q), using default %t.", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := getEnv(key, "", false)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid integer for %s (%q), using default %d.", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvInt64List(key string) []int64 {
+	var values []int64
+	for _, field := range strings.Split(getEnv(key, "", false), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid integer %q in %s.", field, key)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, field := range strings.Split(getEnv(key, "", false), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+// loadProxyRoutes parses PROXY_ROUTES ("gemini=direct,elevenlabs=pool,...").
+// Without an explicit route ElevenLabs uses the proxy pool and Gemini uses
+// PROXY_URL when it is set, as before per-provider routing existed. Routes to
+// the pool connect directly when no proxies are configured.
+func loadProxyRoutes() map[string]string {
+	routes := map[string]string{"elevenlabs": "pool"}
+	if proxyURL := getEnv("PROXY_URL", "", false); proxyURL != "" {
+		routes["gemini"] = proxyURL
+	}
+
+	for _, field := range getEnvList("PROXY_ROUTES") {
+		provider, route, ok := strings.Cut(field, "=")
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		if !ok || provider == "" {
+			log.Fatalf("FATAL: Invalid PROXY_ROUTES entry '%s'. Use provider=direct, provider=pool or provider=<proxy URL>.", field)
+		}
+		routes[provider] = strings.TrimSpace(route)
+	}
+	return routes
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"video-script-bot/internal/ai"
+)
+
+const defaultGeminiModel = "gemini-1.5-flash"
+
+func loadGeminiGeneration() ai.GeminiGeneration {
+	generation := ai.GeminiGeneration{Models: splitModels(getEnv("GEMINI_MODELS", defaultGeminiModel, false), ",")}
+	if len(generation.Models) == 0 {
+		generation.Models = []string{defaultGeminiModel}
+	}
+	setGenerationValue(&generation, "temperature", getEnv("GEMINI_TEMPERATURE", "", false), "GEMINI_TEMPERATURE")
+	setGenerationValue(&generation, "top_p", getEnv("GEMINI_TOP_P", "", false), "GEMINI_TOP_P")
+	setGenerationValue(&generation, "max_output_tokens", getEnv("GEMINI_MAX_OUTPUT_TOKENS", "", false), "GEMINI_MAX_OUTPUT_TOKENS")
+	setGenerationValue(&generation, "safety", getEnv("GEMINI_SAFETY_THRESHOLD", "", false), "GEMINI_SAFETY_THRESHOLD")
+	return generation
+}
+
+// loadGeminiStyleOverrides parses GEMINI_STYLE_OVERRIDES, which looks like
+// "narrative:temperature=1.2,top_p=0.95;professional:models=gemini-1.5-pro|gemini-1.5-flash".
+// Styles are matched case-insensitively against the style the user picked.
+func loadGeminiStyleOverrides() map[string]ai.GeminiGeneration {
+	overrides := make(map[string]ai.GeminiGeneration)
+	raw := getEnv("GEMINI_STYLE_OVERRIDES", "", false)
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		style, settings, found := strings.Cut(entry, ":")
+		style = strings.ToLower(strings.TrimSpace(style))
+		if !found || style == "" {
+			log.Printf("Warning: ignoring malformed GEMINI_STYLE_OVERRIDES entry %q.", entry)
+			continue
+		}
+
+		var generation ai.GeminiGeneration
+		for _, pair := range strings.Split(settings, ",") {
+			key, value, found := strings.Cut(pair, "=")
+			if !found {
+				log.Printf("Warning: ignoring malformed setting %q for style %q in GEMINI_STYLE_OVERRIDES.", pair, style)
+				continue
+			}
+			setGenerationValue(&generation, strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value), "GEMINI_STYLE_OVERRIDES["+style+"]")
+		}
+		overrides[style] = generation
+	}
+	return overrides
+}
+
+// setGenerationValue parses one setting into generation, logging and skipping
+// values that do not parse. source names the variable for the warning.
+func setGenerationValue(generation *ai.GeminiGeneration, key, value, source string) {
+	if value == "" {
+		return
+	}
+	switch key {
+	case "models":
+		generation.Models = splitModels(value, "|")
+	case "temperature", "top_p":
+		parsed, err := strconv.ParseFloat(value, 32)
+		if err != nil || parsed < 0 {
+			log.Printf("Warning: invalid %s in %s (%q), using the model default.", key, source, value)
+			return
+		}
+		f := float32(parsed)
+		if key == "temperature" {
+			generation.Temperature = &f
+		} else {
+			generation.TopP = &f
+		}
+	case "max_output_tokens":
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed <= 0 {
+			log.Printf("Warning: invalid max_output_tokens in %s (%q), using the model default.", source, value)
+			return
+		}
+		tokens := int32(parsed)
+		generation.MaxOutputTokens = &tokens
+	case "safety":
+		threshold := strings.ToLower(value)
+		switch threshold {
+		case ai.SafetyBlockNone, ai.SafetyBlockOnlyHigh, ai.SafetyBlockMediumAndAbove, ai.SafetyBlockLowAndAbove:
+			generation.SafetyThreshold = threshold
+		default:
+			log.Printf("Warning: unknown safety threshold in %s (%q), using the model default.", source, value)
+		}
+	default:
+		log.Printf("Warning: unknown setting %q in %s.", key, source)
+	}
+}
+
+func splitModels(value, separator string) []string {
+	var models []string
+	for _, model := range strings.Split(value, separator) {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
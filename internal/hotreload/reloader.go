@@ -0,0 +1,146 @@
+// Package hotreload applies new API keys and proxies from the .env file while
+// the bot is running, so adding a key does not require a restart.
+package hotreload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Updater is anything whose list of values can be replaced at runtime, such
+// as apikeys.KeyManager or proxy.Manager.
+type Updater interface {
+	Update(values []string) (added, removed int, err error)
+}
+
+// Change reports what one reload did to one variable.
+type Change struct {
+	Variable string
+	Added    int
+	Removed  int
+	Err      error
+}
+
+type target struct {
+	variable string
+	updater  Updater
+}
+
+// Reloader re-reads a dotenv file and pushes the comma-separated values of the
+// watched variables to their updaters. Variables missing from the file are
+// left alone.
+type Reloader struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	targets []target
+	values  map[string]string
+	modTime time.Time
+}
+
+// New creates a Reloader for path. The file is checked for changes every
+// interval; an interval of zero disables polling, leaving SIGHUP and explicit
+// Reload calls.
+func New(path string, interval time.Duration) *Reloader {
+	r := &Reloader{path: path, interval: interval, values: make(map[string]string)}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// Watch registers updater for the variable. Its current value is taken from
+// the environment, so an unchanged file does not trigger an update.
+func (r *Reloader) Watch(variable string, updater Updater) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets = append(r.targets, target{variable: variable, updater: updater})
+	r.values[variable] = os.Getenv(variable)
+}
+
+// Reload reads the file and applies every watched variable whose value
+// changed.
+func (r *Reloader) Reload() ([]Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	env, err := godotenv.Read(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.path, err)
+	}
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	var changes []Change
+	for _, t := range r.targets {
+		value, ok := env[t.variable]
+		if !ok || value == r.values[t.variable] {
+			continue
+		}
+		added, removed, err := t.updater.Update(strings.Split(value, ","))
+		changes = append(changes, Change{Variable: t.variable, Added: added, Removed: removed, Err: err})
+		if err != nil {
+			log.Printf("Warning: could not apply new %s: %v", t.variable, err)
+			continue
+		}
+		r.values[t.variable] = value
+	}
+	return changes, nil
+}
+
+// Run reloads on SIGHUP and whenever the file's modification time changes,
+// until ctx is cancelled.
+func (r *Reloader) Run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Printf("Received SIGHUP, reloading %s.", r.path)
+			r.reloadAndLog()
+		case <-tick:
+			if r.fileChanged() {
+				log.Printf("%s changed, reloading.", r.path)
+				r.reloadAndLog()
+			}
+		}
+	}
+}
+
+func (r *Reloader) fileChanged() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+func (r *Reloader) reloadAndLog() {
+	if _, err := r.Reload(); err != nil {
+		log.Printf("Warning: reload failed: %v", err)
+	}
+}
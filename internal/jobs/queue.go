@@ -0,0 +1,385 @@
+// Package jobs runs long script and audio work from a persistent queue, so a
+// restart resumes interrupted work instead of losing it.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status is where a job is in its life cycle.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Job is one unit of background work for a user. Payload holds whatever the
+// handler for Type needs, usually JSON.
+type Job struct {
+	ID        int64
+	Type      string
+	UserID    int64
+	ChatID    int64
+	Payload   []byte
+	Status    Status
+	Attempts  int
+	Result    string
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Store persists jobs. The storage backends implement it.
+type Store interface {
+	// CreateJob saves a new pending job and sets its ID.
+	CreateJob(job *Job) error
+	// ClaimJob marks the oldest pending job as running, counts the attempt
+	// and returns it, or returns nil when nothing can be claimed. A job is
+	// only claimed while no other job of its user is running, in any process.
+	ClaimJob() (*Job, error)
+	// FinishJob records the outcome of a job. Setting StatusPending puts it
+	// back in the queue, unless it was asked to stop meanwhile.
+	FinishJob(id int64, status Status, result, errMessage string) error
+	// CancelUserJobs cancels every pending job of a user and asks the process
+	// running the user's running job to stop it. It returns how many pending
+	// jobs it cancelled.
+	CancelUserJobs(userID int64) (int, error)
+	// RequeueRunningJobs puts jobs left running by a previous process back in
+	// the queue. Jobs that were asked to stop are cancelled instead.
+	RequeueRunningJobs() (int, error)
+	// RenewJobs records that the listed running jobs are still being worked
+	// on and returns the ones that were asked to stop.
+	RenewJobs(ids []int64) (cancelled []int64, err error)
+	// RequeueStaleJobs puts running jobs that were last renewed before the
+	// given time back in the queue, such as the jobs of a process that died
+	// and never came back. Jobs that were asked to stop are cancelled
+	// instead.
+	RequeueStaleJobs(before time.Time) (int, error)
+}
+
+// Handler runs one job and returns a short result to store with it. The
+// context is cancelled when the user cancels the job or the queue stops.
+type Handler func(ctx context.Context, job *Job) (string, error)
+
+// pollInterval is how often idle workers look for jobs they were not woken
+// for, such as jobs requeued by another call.
+const pollInterval = 5 * time.Second
+
+// A running job is renewed every leaseRenewal while its process is alive. Jobs
+// that go unrenewed for jobLease are taken over by whichever process notices.
+const (
+	leaseRenewal = 30 * time.Second
+	jobLease     = 2 * time.Minute
+)
+
+// Queue runs jobs from a Store on a fixed number of workers. Each user has at
+// most one active job: submitting a new one cancels the previous one, as
+// starting a new task always has.
+type Queue struct {
+	store       Store
+	workers     int
+	maxAttempts int
+	handlers    map[string]Handler
+	wake        chan struct{}
+
+	// jobsCtx is the parent of every job's context. Interrupt cancels it.
+	jobsCtx   context.Context
+	interrupt context.CancelFunc
+
+	mu          sync.Mutex
+	running     map[int64]*runningJob
+	interrupted []*Job
+	// cancels counts Cancel calls, so claim can tell one ran meanwhile.
+	cancels uint64
+}
+
+type runningJob struct {
+	job       *Job
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// NewQueue creates a queue with the given number of workers. A job that was
+// interrupted maxAttempts times, for example by crashes, is marked failed
+// instead of being retried again.
+func NewQueue(store Store, workers, maxAttempts int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	jobsCtx, interrupt := context.WithCancel(context.Background())
+	return &Queue{
+		store:       store,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		handlers:    make(map[string]Handler),
+		wake:        make(chan struct{}, workers),
+		jobsCtx:     jobsCtx,
+		interrupt:   interrupt,
+		running:     make(map[int64]*runningJob),
+	}
+}
+
+// Handle registers the handler for a job type. It must be called before Run.
+func (q *Queue) Handle(jobType string, handler Handler) {
+	q.handlers[jobType] = handler
+}
+
+// Submit cancels the user's current job and queues job in its place.
+func (q *Queue) Submit(job *Job) error {
+	if _, ok := q.handlers[job.Type]; !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+	if err := q.Cancel(job.UserID); err != nil {
+		return err
+	}
+	if err := q.store.CreateJob(job); err != nil {
+		return fmt.Errorf("failed to queue %s job: %w", job.Type, err)
+	}
+	log.Printf("Queued %s job %d for user %d.", job.Type, job.ID, job.UserID)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Cancel cancels the user's pending jobs and stops the one running, if any.
+// A job running in another process sharing the store is stopped by that
+// process when it next renews the job's lease.
+func (q *Queue) Cancel(userID int64) error {
+	if _, err := q.store.CancelUserJobs(userID); err != nil {
+		return fmt.Errorf("failed to cancel jobs of user %d: %w", userID, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cancels++
+	for _, running := range q.running {
+		if running.job.UserID == userID {
+			q.stop(running)
+		}
+	}
+	return nil
+}
+
+// stop cancels a running job. q.mu must be held.
+func (q *Queue) stop(running *runningJob) {
+	if running.cancelled {
+		return
+	}
+	running.cancelled = true
+	running.cancel()
+	log.Printf("Cancelled %s job %d for user %d.", running.job.Type, running.job.ID, running.job.UserID)
+}
+
+// Run requeues jobs interrupted by a previous run and works through the queue
+// until ctx is cancelled. It then stops taking new jobs and returns once the
+// running ones have finished, or have been stopped by Interrupt. The jobs
+// Interrupt stopped are returned; they are back in the queue for the next run.
+func (q *Queue) Run(ctx context.Context) []*Job {
+	if requeued, err := q.store.RequeueRunningJobs(); err != nil {
+		log.Printf("Warning: could not requeue interrupted jobs: %v", err)
+	} else if requeued > 0 {
+		log.Printf("Resuming %d job(s) interrupted by the last shutdown.", requeued)
+	}
+
+	// Leases are kept up until the last job has finished, after ctx is done.
+	stopLeases := make(chan struct{})
+	leasesDone := make(chan struct{})
+	go func() {
+		defer close(leasesDone)
+		q.keepLeases(stopLeases)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+	close(stopLeases)
+	<-leasesDone
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.interrupted
+}
+
+// keepLeases renews the running jobs, stops the ones cancelled by another
+// process and requeues stale ones every leaseRenewal until stop is closed.
+func (q *Queue) keepLeases(stop <-chan struct{}) {
+	ticker := time.NewTicker(leaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		ids := make([]int64, 0, len(q.running))
+		for id := range q.running {
+			ids = append(ids, id)
+		}
+		q.mu.Unlock()
+		if len(ids) > 0 {
+			cancelled, err := q.store.RenewJobs(ids)
+			if err != nil {
+				log.Printf("Warning: could not renew running jobs: %v", err)
+			}
+			q.mu.Lock()
+			for _, id := range cancelled {
+				if running, ok := q.running[id]; ok {
+					q.stop(running)
+				}
+			}
+			q.mu.Unlock()
+		}
+
+		requeued, err := q.store.RequeueStaleJobs(time.Now().Add(-jobLease))
+		if err != nil {
+			log.Printf("Warning: could not requeue stale jobs: %v", err)
+			continue
+		}
+		if requeued > 0 {
+			log.Printf("Requeued %d job(s) abandoned by a stopped process.", requeued)
+			for i := 0; i < requeued && i < q.workers; i++ {
+				select {
+				case q.wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Interrupt stops the jobs that are still running, so Run can return without
+// waiting for them to finish.
+func (q *Queue) Interrupt() {
+	q.interrupt()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		if job, running := q.claim(); job != nil {
+			q.run(job, running)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes the next job and registers it as running. The store is asked
+// without holding q.mu, so a slow database does not hold up Cancel and the
+// other workers. A Cancel that runs meanwhile can miss the job both as
+// pending and as running here, but the store keeps its request, so the job is
+// checked once more after it is registered.
+func (q *Queue) claim() (*Job, *runningJob) {
+	q.mu.Lock()
+	cancels := q.cancels
+	q.mu.Unlock()
+
+	job, err := q.store.ClaimJob()
+	if err != nil {
+		log.Printf("Warning: could not claim a job: %v", err)
+		return nil, nil
+	}
+	if job == nil {
+		return nil, nil
+	}
+	jobCtx, cancel := context.WithCancel(q.jobsCtx)
+	running := &runningJob{job: job, ctx: jobCtx, cancel: cancel}
+
+	q.mu.Lock()
+	q.running[job.ID] = running
+	raced := q.cancels != cancels
+	q.mu.Unlock()
+
+	if raced {
+		cancelled, err := q.store.RenewJobs([]int64{job.ID})
+		if err != nil {
+			log.Printf("Warning: could not check job %d for cancellation: %v", job.ID, err)
+		}
+		if len(cancelled) > 0 {
+			q.mu.Lock()
+			q.stop(running)
+			q.mu.Unlock()
+		}
+	}
+	return job, running
+}
+
+func (q *Queue) run(job *Job, running *runningJob) {
+	defer running.cancel()
+
+	var result string
+	var err error
+	handler, ok := q.handlers[job.Type]
+	switch {
+	case !ok:
+		err = fmt.Errorf("no handler for job type %q", job.Type)
+	case job.Attempts > q.maxAttempts:
+		err = fmt.Errorf("gave up after %d interrupted attempts", job.Attempts-1)
+	default:
+		result, err = handler(running.ctx, job)
+	}
+
+	q.mu.Lock()
+	delete(q.running, job.ID)
+	cancelled := running.cancelled
+	interrupted := !cancelled && q.jobsCtx.Err() != nil
+	if interrupted {
+		q.interrupted = append(q.interrupted, job)
+	}
+	q.mu.Unlock()
+
+	switch {
+	case cancelled:
+		q.finish(job, StatusCancelled, result, err)
+	case interrupted:
+		// The queue is stopping; run the job again on the next start.
+		q.finish(job, StatusPending, "", nil)
+	case err != nil:
+		q.finish(job, StatusFailed, result, err)
+	default:
+		q.finish(job, StatusDone, result, nil)
+	}
+}
+
+func (q *Queue) finish(job *Job, status Status, result string, err error) {
+	message := ""
+	if err != nil && !errors.Is(err, context.Canceled) {
+		message = err.Error()
+	}
+	if storeErr := q.store.FinishJob(job.ID, status, result, message); storeErr != nil {
+		log.Printf("Warning: could not record %s job %d as %s: %v", job.Type, job.ID, status, storeErr)
+		return
+	}
+	log.Printf("%s job %d for user %d is %s.", job.Type, job.ID, job.UserID, status)
+}
@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store in memory. onClaim, when set, runs after a job has
+// been marked running and before ClaimJob returns it.
+type memoryStore struct {
+	mu       sync.Mutex
+	jobs     []*Job
+	cancel   map[int64]bool
+	nextID   int64
+	finished chan *Job
+	onClaim  func(job *Job)
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{cancel: make(map[int64]bool), finished: make(chan *Job, 10)}
+}
+
+func (s *memoryStore) CreateJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	job.ID = s.nextID
+	job.Status = StatusPending
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+func (s *memoryStore) ClaimJob() (*Job, error) {
+	s.mu.Lock()
+	var claimed *Job
+	for _, job := range s.jobs {
+		if job.Status == StatusPending {
+			job.Status = StatusRunning
+			job.Attempts++
+			claimed = job
+			break
+		}
+	}
+	s.mu.Unlock()
+	if claimed != nil && s.onClaim != nil {
+		s.onClaim(claimed)
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) FinishJob(id int64, status Status, result, errMessage string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.ID == id {
+			job.Status = status
+			s.finished <- job
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CancelUserJobs(userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, job := range s.jobs {
+		switch {
+		case job.UserID != userID:
+		case job.Status == StatusPending:
+			job.Status = StatusCancelled
+			count++
+		case job.Status == StatusRunning:
+			s.cancel[job.ID] = true
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) RequeueRunningJobs() (int, error) { return 0, nil }
+
+func (s *memoryStore) RenewJobs(ids []int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cancelled []int64
+	for _, id := range ids {
+		if s.cancel[id] {
+			cancelled = append(cancelled, id)
+		}
+	}
+	return cancelled, nil
+}
+
+func (s *memoryStore) RequeueStaleJobs(before time.Time) (int, error) { return 0, nil }
+
+// startQueue runs q until the test ends.
+func startQueue(t *testing.T, q *Queue) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitFinished(t *testing.T, store *memoryStore) *Job {
+	t.Helper()
+	select {
+	case job := <-store.finished:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish")
+		return nil
+	}
+}
+
+// waitForCancel is a handler that runs until its job is cancelled.
+func waitForCancel(ctx context.Context, job *Job) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestCancelStopsRunningJob(t *testing.T) {
+	store := newMemoryStore()
+	q := NewQueue(store, 1, 3)
+	started := make(chan struct{})
+	q.Handle("test", func(ctx context.Context, job *Job) (string, error) {
+		close(started)
+		return waitForCancel(ctx, job)
+	})
+	startQueue(t, q)
+
+	if err := q.Submit(&Job{Type: "test", UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := q.Cancel(1); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFinished(t, store); job.Status != StatusCancelled {
+		t.Fatalf("job finished as %s, want cancelled", job.Status)
+	}
+}
+
+// A Cancel that arrives while a worker is claiming the user's job neither
+// waits for the store nor misses the job.
+func TestCancelDuringClaim(t *testing.T) {
+	store := newMemoryStore()
+	claiming := make(chan struct{})
+	release := make(chan struct{})
+	store.onClaim = func(*Job) {
+		close(claiming)
+		<-release
+	}
+	q := NewQueue(store, 1, 3)
+	q.Handle("test", waitForCancel)
+	startQueue(t, q)
+
+	if err := q.Submit(&Job{Type: "test", UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-claiming
+
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- q.Cancel(1) }()
+	select {
+	case err := <-cancelled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancel waited for the job being claimed")
+	}
+	close(release)
+
+	if job := waitFinished(t, store); job.Status != StatusCancelled {
+		t.Fatalf("job finished as %s, want cancelled", job.Status)
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 const (
 	DefaultStability = 0.75
 	DefaultClarity   = 0.75
@@ -18,17 +20,28 @@ const (
 	StateWaitingForStability    UserState = "waiting_for_stability"
 	StateWaitingForClarity      UserState = "waiting_for_clarity"
 	StateWaitingForSpeed UserState = "waiting_for_speed"
+	StateWaitingForProjectName UserState = "waiting_for_project_name"
 )
 
 type UserData struct {
 	State           UserState
 	VideoFileID     string
 	VideoMimeType   string
+	VideoDuration   int
+	VideoFileSize   int
 	ScriptStyle     string
 	GeneratedScript string
 	Stability		float32
 	Clarity			float32
 	Speed float32
+	MergeAudio      bool
+	NarrationFileID string
+	TTSProvider     string
+	// ProjectID and ProjectName identify the active project. The video,
+	// style, script, voice and narration fields belong to it.
+	ProjectID   int64
+	ProjectName string
+	VoiceID     string
 }
 
 // NewDefaultUserData creates a user with initial idle state.
@@ -42,9 +55,15 @@ func NewDefaultUserData() *UserData {
 	}
 }
 
+// VideoLength returns the duration of the uploaded video, or zero when unknown.
+func (u *UserData) VideoLength() time.Duration {
+	return time.Duration(u.VideoDuration) * time.Second
+}
+
 type Voice struct {
-	VoiceID string `json:"voice_id"`
-	Name    string `json:"name"`
+	VoiceID  string `json:"voice_id"`
+	Name     string `json:"name"`
+	Provider string `json:"provider,omitempty"`
 }
 
 type VoicesFile struct {
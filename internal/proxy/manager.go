@@ -1,60 +1,179 @@
 package proxy
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"time"
 )
 
 var ErrNoProxiesAvailable = errors.New("no proxy URLs available")
 var ErrAllProxiesExhausted = errors.New("all available proxies have been exhausted")
 
+// ejectionCooldown is how long a failed proxy stays out of rotation when no
+// health checker runs to re-admit it.
+const ejectionCooldown = time.Minute
+
+type proxyEntry struct {
+	url       *url.URL
+	transport *http.Transport
+	healthy   bool
+	lastError string
+	ejectedAt time.Time
+}
+
+// Manager rotates through a list of proxies. Proxies that fail a request or a
+// health probe are ejected from rotation until a later probe succeeds, or for
+// ejectionCooldown when no HealthChecker runs. Each
+// proxy keeps its own transport so connections are reused without callers
+// ever modifying a shared client. A Manager without proxies, such as the zero
+// Manager, connects directly until Update gives it some.
 type Manager struct {
-	proxies      []*url.URL
+	proxies      []*proxyEntry
 	currentIndex int
-	mutex        sync.Mutex
+	// checked is set once a HealthChecker runs for the manager.
+	checked bool
+	mutex   sync.Mutex
 }
 
 func NewManager(proxyStrings []string) (*Manager, error) {
+	proxies, err := parseProxies(proxyStrings)
+	if err != nil {
+		return nil, err
+	}
+
+	pm := &Manager{currentIndex: 0}
+	for _, proxyURL := range proxies {
+		pm.proxies = append(pm.proxies, newEntry(proxyURL))
+	}
+	return pm, nil
+}
+
+func newEntry(proxyURL *url.URL) *proxyEntry {
+	transport, err := NewTransport(proxyURL)
+	if err != nil {
+		// parseProxies rejects URLs NewTransport cannot handle, so this is
+		// not expected; fall back to a direct transport rather than panic.
+		log.Printf("Warning: %v", err)
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	return &proxyEntry{url: proxyURL, transport: transport, healthy: true}
+}
+
+func parseProxies(proxyStrings []string) ([]*url.URL, error) {
 	if len(proxyStrings) == 0 || (len(proxyStrings) == 1 && proxyStrings[0] == "") {
 		return nil, ErrNoProxiesAvailable
 	}
 
 	var proxies []*url.URL
 	for _, p := range proxyStrings {
+		p = strings.TrimSpace(p)
 		proxyURL, err := url.Parse(p)
-		if err != nil {
+		if err != nil || proxyURL.Host == "" {
 			log.Printf("Warning: could not parse proxy URL '%s', skipping. Error: %v", p, err)
 			continue
 		}
+		if _, err := NewTransport(proxyURL); err != nil {
+			log.Printf("Warning: %v, skipping.", err)
+			continue
+		}
 		proxies = append(proxies, proxyURL)
 	}
 
 	if len(proxies) == 0 {
 		return nil, errors.New("no valid proxy URLs could be parsed")
 	}
+	return proxies, nil
+}
+
+// Update replaces the proxy list at runtime and returns how many proxies were
+// added and removed. Proxies that stay keep their health and connections, and
+// requests already using a removed proxy finish with it. An empty list
+// removes every proxy.
+func (pm *Manager) Update(proxyStrings []string) (added, removed int, err error) {
+	proxies, err := parseProxies(proxyStrings)
+	if err != nil && !errors.Is(err, ErrNoProxiesAvailable) {
+		return 0, 0, err
+	}
 
-	return &Manager{
-		proxies:      proxies,
-		currentIndex: 0,
-	}, nil
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	existing := make(map[string]*proxyEntry, len(pm.proxies))
+	for _, entry := range pm.proxies {
+		existing[entry.url.String()] = entry
+	}
+
+	var entries []*proxyEntry
+	for _, proxyURL := range proxies {
+		if entry, ok := existing[proxyURL.String()]; ok {
+			entries = append(entries, entry)
+			delete(existing, proxyURL.String())
+			continue
+		}
+		entries = append(entries, newEntry(proxyURL))
+		added++
+	}
+	removed = len(existing)
+	for _, entry := range existing {
+		entry.transport.CloseIdleConnections()
+	}
+
+	pm.proxies = entries
+	if pm.currentIndex >= len(pm.proxies) {
+		pm.currentIndex = 0
+	}
+	if added > 0 || removed > 0 {
+		log.Printf("Proxies updated: %d added, %d removed, %d total.", added, removed, len(entries))
+	}
+	return added, removed, nil
 }
 
+// GetCurrentProxy returns the current healthy proxy, or nil when every proxy
+// has been ejected.
 func (pm *Manager) GetCurrentProxy() *url.URL {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
-	if len(pm.proxies) == 0 {
+
+	entry := pm.currentHealthy()
+	if entry == nil {
 		return nil
 	}
-	return pm.proxies[pm.currentIndex]
+	return entry.url
+}
+
+// currentHealthy moves the cursor to the next healthy proxy, starting at the
+// current one. Without a health checker, proxies whose ejection cooled down
+// are re-admitted on the way. The caller must hold the mutex.
+func (pm *Manager) currentHealthy() *proxyEntry {
+	for i := 0; i < len(pm.proxies); i++ {
+		index := (pm.currentIndex + i) % len(pm.proxies)
+		entry := pm.proxies[index]
+		if !entry.healthy && !pm.checked && time.Since(entry.ejectedAt) >= ejectionCooldown {
+			entry.healthy = true
+			log.Printf("Proxy %s re-admitted to rotation after a %s cooldown.", redact(entry.url), ejectionCooldown)
+		}
+		if entry.healthy {
+			pm.currentIndex = index
+			return pm.proxies[index]
+		}
+	}
+	return nil
 }
 
 func (pm *Manager) RotateProxy() error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
-	log.Printf("Proxy %s failed or is exhausted. Rotating to the next proxy.", pm.proxies[pm.currentIndex].String())
+	if len(pm.proxies) == 0 {
+		return ErrNoProxiesAvailable
+	}
+	log.Printf("Proxy %s failed or is exhausted. Rotating to the next proxy.", redact(pm.proxies[pm.currentIndex].url))
 	pm.currentIndex++
 
 	if pm.currentIndex >= len(pm.proxies) {
@@ -63,10 +182,132 @@ func (pm *Manager) RotateProxy() error {
 		return ErrAllProxiesExhausted
 	}
 
-	log.Printf("Switched to proxy %s.", pm.proxies[pm.currentIndex].String())
+	log.Printf("Switched to proxy %s.", redact(pm.proxies[pm.currentIndex].url))
 	return nil
 }
 
 func (pm *Manager) GetTotalProxies() int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
 	return len(pm.proxies)
-}
\ No newline at end of file
+}
+
+// Client returns a new HTTP client that sends requests through proxyURL. The
+// client is built per attempt, so callers never share or mutate one.
+func (pm *Manager) Client(proxyURL *url.URL, timeout time.Duration) *http.Client {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for _, entry := range pm.proxies {
+		if entry.url.String() == proxyURL.String() {
+			return &http.Client{Transport: entry.transport, Timeout: timeout}
+		}
+	}
+	// The proxy was removed by a reload; give the attempt its own transport.
+	return &http.Client{Transport: newEntry(proxyURL).transport, Timeout: timeout}
+}
+
+// MarkFailed ejects proxyURL from rotation until a health probe succeeds, or
+// for ejectionCooldown when no health checker runs.
+func (pm *Manager) MarkFailed(proxyURL *url.URL, reason error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for _, entry := range pm.proxies {
+		if entry.url.String() == proxyURL.String() && entry.healthy {
+			entry.healthy = false
+			entry.lastError = reason.Error()
+			entry.ejectedAt = time.Now()
+			log.Printf("WARNING: Proxy %s ejected from rotation: %v", redact(entry.url), reason)
+		}
+	}
+}
+
+// HealthChecker probes every proxy by fetching a URL through it.
+type HealthChecker struct {
+	ProbeURL string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Run probes all proxies every Interval until ctx is cancelled. Proxies that
+// fail are ejected and proxies that pass are re-admitted.
+func (hc HealthChecker) Run(ctx context.Context, pm *Manager) {
+	pm.mutex.Lock()
+	pm.checked = true
+	pm.mutex.Unlock()
+
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.checkAll(ctx, pm)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc HealthChecker) checkAll(ctx context.Context, pm *Manager) {
+	pm.mutex.Lock()
+	entries := append([]*proxyEntry(nil), pm.proxies...)
+	pm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		wg.Add(1)
+		go func(entry *proxyEntry) {
+			defer wg.Done()
+			err := hc.probe(ctx, entry)
+			if ctx.Err() != nil {
+				return
+			}
+			pm.setHealth(entry, err)
+		}(entry)
+	}
+	wg.Wait()
+}
+
+func (hc HealthChecker) probe(ctx context.Context, entry *proxyEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", hc.ProbeURL, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: entry.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusProxyAuthRequired {
+		return fmt.Errorf("probe returned status %s", resp.Status)
+	}
+	return nil
+}
+
+func (pm *Manager) setHealth(entry *proxyEntry, err error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	switch {
+	case err != nil && entry.healthy:
+		entry.healthy = false
+		entry.lastError = err.Error()
+		entry.ejectedAt = time.Now()
+		log.Printf("WARNING: Proxy %s failed its health check and was ejected: %v", redact(entry.url), err)
+	case err == nil && !entry.healthy:
+		entry.healthy = true
+		entry.lastError = ""
+		log.Printf("Proxy %s passed its health check and was re-admitted.", redact(entry.url))
+	}
+}
+
+// redact hides proxy credentials in logs.
+func redact(proxyURL *url.URL) string {
+	return proxyURL.Redacted()
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func proxyList(ports ...int) []string {
+	var list []string
+	for _, port := range ports {
+		list = append(list, fmt.Sprintf("http://127.0.0.1:%d", port))
+	}
+	return list
+}
+
+// Run with -race: reloads replace the proxy list while requests pick, use and
+// fail proxies.
+func TestManagerUpdateRacesWithClientAndMarkFailed(t *testing.T) {
+	pm, err := NewManager(proxyList(1, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := [][]string{proxyList(1, 2, 3), proxyList(2, 3, 4), proxyList(5), nil}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if _, _, err := pm.Update(lists[i%len(lists)]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				proxyURL := pm.GetCurrentProxy()
+				if proxyURL == nil {
+					proxyURL, _ = url.Parse(proxyList(worker)[0])
+				}
+				if client := pm.Client(proxyURL, time.Second); client.Transport == nil {
+					t.Error("client without a transport")
+				}
+				if i%3 == 0 {
+					pm.MarkFailed(proxyURL, errors.New("connection refused"))
+				}
+				pm.RotateProxy()
+				pm.GetTotalProxies()
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
+
+func TestMarkFailedReadmitsAfterCooldownWithoutChecker(t *testing.T) {
+	pm, err := NewManager(proxyList(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL := pm.GetCurrentProxy()
+	pm.MarkFailed(proxyURL, errors.New("connection refused"))
+	if pm.GetCurrentProxy() != nil {
+		t.Fatal("a failed proxy stayed in rotation")
+	}
+
+	pm.mutex.Lock()
+	pm.proxies[0].ejectedAt = time.Now().Add(-ejectionCooldown)
+	pm.mutex.Unlock()
+	if pm.GetCurrentProxy() == nil {
+		t.Fatal("a failed proxy was not re-admitted after the cooldown")
+	}
+
+	// With a health checker, only a passing probe re-admits a proxy.
+	pm.MarkFailed(proxyURL, errors.New("connection refused"))
+	pm.mutex.Lock()
+	pm.checked = true
+	pm.proxies[0].ejectedAt = time.Now().Add(-ejectionCooldown)
+	pm.mutex.Unlock()
+	if pm.GetCurrentProxy() != nil {
+		t.Fatal("a failed proxy was re-admitted without passing a health check")
+	}
+}
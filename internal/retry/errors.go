@@ -0,0 +1,124 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Class says whether and how a failed request may be retried.
+type Class int
+
+const (
+	// Permanent errors will fail again and are returned at once.
+	Permanent Class = iota
+	// Transient errors, such as dropped connections and 5xx responses, are
+	// retried with backoff.
+	Transient
+	// Quota errors mean the API key is rate limited or out of quota.
+	Quota
+	// Auth errors mean the API key was rejected.
+	Auth
+)
+
+func (c Class) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Quota:
+		return "quota"
+	case Auth:
+		return "auth"
+	}
+	return "permanent"
+}
+
+// Error attaches a class, and optionally a server-requested delay, to err.
+type Error struct {
+	Class      Class
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Mark classifies err. It returns nil for a nil err.
+func Mark(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// After classifies err and asks Do to wait at least retryAfter before the
+// next attempt.
+func After(class Class, retryAfter time.Duration, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, RetryAfter: retryAfter, Err: err}
+}
+
+// ClassOf returns the class given by Mark, Transient for network errors that
+// happened while talking to the server, and Permanent for anything else.
+func ClassOf(err error) Class {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	if IsNetworkError(err) {
+		return Transient
+	}
+	return Permanent
+}
+
+// RetryAfterOf returns the delay attached by After, or 0.
+func RetryAfterOf(err error) time.Duration {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.RetryAfter
+	}
+	return 0
+}
+
+// ClassForStatus classifies an HTTP response status.
+func ClassForStatus(code int) Class {
+	switch code {
+	case http.StatusTooManyRequests:
+		return Quota
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return Auth
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return Transient
+	}
+	return Permanent
+}
+
+// IsNetworkError reports whether err is a failure to reach the server or a
+// connection that broke mid-request. An EOF only counts when the HTTP client
+// reports it for a request, not when it comes from anywhere else.
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF)
+}
@@ -0,0 +1,131 @@
+// Package retry runs outbound requests again when they fail for a reason that
+// may go away: exponential backoff with jitter for transient errors, and an
+// immediate retry for quota and auth errors, where the caller is expected to
+// switch to another API key.
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Clock is the time source used by a Policy. Tests replace it with a fake so
+// backoff can be checked without sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the real clock.
+var SystemClock Clock = systemClock{}
+
+// Policy says how often and how long to retry.
+type Policy struct {
+	// InitialInterval is the backoff after the first transient failure. It
+	// grows by Multiplier after each further one, up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter randomises each backoff by up to this fraction in either
+	// direction, so clients that failed together do not retry together.
+	Jitter float64
+	// MaxRetries caps the number of transient retries; 0 means no cap. Quota
+	// and auth retries are bounded by the caller's key pool instead.
+	MaxRetries int
+	// MaxElapsed stops retrying once the next attempt would start this long
+	// after the first; 0 means no limit.
+	MaxElapsed time.Duration
+
+	// Clock and Rand default to the system clock and math/rand.
+	Clock Clock
+	Rand  func() float64
+}
+
+// Default is a reasonable policy for calls to external APIs.
+var Default = Policy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.5,
+	MaxRetries:      4,
+	MaxElapsed:      5 * time.Minute,
+}
+
+// Do calls fn until it succeeds, returns a Permanent error, the retry budget
+// runs out or ctx is done, and returns fn's last error. fn is called with the
+// number of the attempt, starting at 1.
+func (p Policy) Do(ctx context.Context, fn func(attempt int) error) error {
+	clock := p.clock()
+	start := clock.Now()
+	retries := 0
+
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		var delay time.Duration
+		switch ClassOf(err) {
+		case Permanent:
+			return err
+		case Quota, Auth:
+			delay = RetryAfterOf(err)
+		case Transient:
+			if p.MaxRetries > 0 && retries >= p.MaxRetries {
+				return err
+			}
+			delay = max(p.Backoff(retries), RetryAfterOf(err))
+			retries++
+		}
+
+		if p.MaxElapsed > 0 && clock.Now().Add(delay).Sub(start) > p.MaxElapsed {
+			return err
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-clock.After(delay):
+			}
+		}
+	}
+}
+
+// Backoff returns the wait before the transient retry with the given index,
+// starting at 0.
+func (p Policy) Backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialInterval) * math.Pow(multiplier, float64(retry))
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		random := p.Rand
+		if random == nil {
+			random = rand.Float64
+		}
+		delay *= 1 + p.Jitter*(2*random()-1)
+	}
+	return time.Duration(delay)
+}
+
+func (p Policy) clock() Clock {
+	if p.Clock == nil {
+		return SystemClock
+	}
+	return p.Clock
+}
@@ -0,0 +1,174 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock records every sleep and lets it pass at once, advancing its time.
+// With onSleep set, sleeps never end and onSleep is called instead.
+type fakeClock struct {
+	now     time.Time
+	sleeps  []time.Duration
+	onSleep func()
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.sleeps = append(c.sleeps, d)
+	ch := make(chan time.Time, 1)
+	if c.onSleep != nil {
+		c.onSleep()
+		return ch
+	}
+	c.now = c.now.Add(d)
+	ch <- c.now
+	return ch
+}
+
+var errTransient = Mark(Transient, errors.New("503 Service Unavailable"))
+
+func TestBackoffGrowsUpToTheCap(t *testing.T) {
+	policy := Policy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for retry, delay := range want {
+		if got := policy.Backoff(retry); got != delay {
+			t.Errorf("Backoff(%d) = %s, want %s", retry, got, delay)
+		}
+	}
+}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	for _, tc := range []struct {
+		random float64
+		want   time.Duration
+	}{
+		{0, 2 * time.Second},
+		{0.5, 4 * time.Second},
+		{1, 6 * time.Second},
+	} {
+		policy := Policy{InitialInterval: 4 * time.Second, Multiplier: 2, Jitter: 0.5, Rand: func() float64 { return tc.random }}
+		if got := policy.Backoff(0); got != tc.want {
+			t.Errorf("with Rand() = %v: Backoff(0) = %s, want %s", tc.random, got, tc.want)
+		}
+	}
+}
+
+func TestDoStopsAfterMaxRetries(t *testing.T) {
+	clock := &fakeClock{}
+	policy := Policy{InitialInterval: time.Second, Multiplier: 2, MaxRetries: 3, Clock: clock}
+
+	attempts := 0
+	err := policy.Do(context.Background(), func(int) error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient {
+		t.Fatalf("got error %v, want the last attempt's error", err)
+	}
+	if attempts != 4 {
+		t.Errorf("made %d attempts, want 4", attempts)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !reflect.DeepEqual(clock.sleeps, want) {
+		t.Errorf("slept %v, want %v", clock.sleeps, want)
+	}
+}
+
+func TestDoStopsBeforeMaxElapsed(t *testing.T) {
+	clock := &fakeClock{}
+	policy := Policy{InitialInterval: time.Second, Multiplier: 2, MaxElapsed: 5 * time.Second, Clock: clock}
+
+	attempts := 0
+	policy.Do(context.Background(), func(int) error {
+		attempts++
+		return errTransient
+	})
+	// After sleeping 1s and 2s, the next 4s wait would end 7s in.
+	if attempts != 3 {
+		t.Errorf("made %d attempts, want 3", attempts)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(clock.sleeps, want) {
+		t.Errorf("slept %v, want %v", clock.sleeps, want)
+	}
+}
+
+func TestDoReturnsWhenCancelledWhileSleeping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{onSleep: cancel}
+	policy := Policy{InitialInterval: time.Minute, Clock: clock}
+
+	attempts := 0
+	err := policy.Do(ctx, func(int) error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient {
+		t.Fatalf("got error %v, want the last attempt's error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("made %d attempts, want 1", attempts)
+	}
+}
+
+func TestDoRetriesQuotaAndAuthWithoutDelay(t *testing.T) {
+	clock := &fakeClock{}
+	policy := Policy{InitialInterval: time.Minute, MaxRetries: 1, Clock: clock}
+
+	results := []error{
+		Mark(Quota, errors.New("429 Too Many Requests")),
+		Mark(Auth, errors.New("401 Unauthorized")),
+		Mark(Quota, errors.New("429 Too Many Requests")),
+		nil,
+	}
+	attempts := 0
+	err := policy.Do(context.Background(), func(int) error {
+		attempts++
+		return results[attempts-1]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != len(results) {
+		t.Errorf("made %d attempts, want %d", attempts, len(results))
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("slept %v between key switches", clock.sleeps)
+	}
+}
+
+func TestDoWaitsForRetryAfter(t *testing.T) {
+	clock := &fakeClock{}
+	policy := Policy{Clock: clock}
+
+	attempts := 0
+	policy.Do(context.Background(), func(int) error {
+		attempts++
+		if attempts == 1 {
+			return After(Quota, 3*time.Second, errors.New("429 Too Many Requests"))
+		}
+		return nil
+	})
+	if want := []time.Duration{3 * time.Second}; !reflect.DeepEqual(clock.sleeps, want) {
+		t.Errorf("slept %v, want %v", clock.sleeps, want)
+	}
+}
+
+func TestIsNetworkErrorOnlyCountsEOFFromRequests(t *testing.T) {
+	if IsNetworkError(io.EOF) {
+		t.Error("a bare io.EOF counted as a network error")
+	}
+	requestEOF := &url.Error{Op: "Post", URL: "https://api.example.com", Err: io.EOF}
+	if !IsNetworkError(requestEOF) {
+		t.Error("an EOF reported for a request did not count as a network error")
+	}
+	if ClassOf(requestEOF) != Transient {
+		t.Errorf("ClassOf(%v) = %s, want transient", requestEOF, ClassOf(requestEOF))
+	}
+}
@@ -0,0 +1,69 @@
+package script
+
+import "strings"
+
+// DiffOp says how a line changed between two scripts.
+type DiffOp int
+
+const (
+	DiffSame DiffOp = iota
+	DiffRemoved
+	DiffAdded
+)
+
+// DiffLine is one line of a diff.
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// Diff compares two scripts line by line and returns the lines of both, with
+// the lines only in old marked removed and the lines only in new marked added.
+// Blank lines are ignored.
+func Diff(old, new string) []DiffLine {
+	a, b := diffLines(old), diffLines(new)
+
+	// common[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]. Scripts are short, so the quadratic table is fine.
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, DiffLine{Op: DiffSame, Text: a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, DiffLine{Op: DiffRemoved, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: DiffAdded, Text: b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+func diffLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,92 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonSegment is the wire format used when a model returns the script as JSON.
+type jsonSegment struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Text    string `json:"text"`
+	Speaker string `json:"speaker,omitempty"`
+	Notes   string `json:"notes,omitempty"`
+}
+
+// ParseJSON reads a script from a JSON array of {start, end, text, speaker, notes}
+// objects. Unknown fields, missing required fields and malformed timestamps are
+// reported per item. The returned script is not validated, see Validate.
+func ParseJSON(data []byte) (*Script, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var items []jsonSegment
+	if err := decoder.Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid script JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid script JSON: unexpected data after the segment array")
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyScript
+	}
+
+	var segments []Segment
+	var problems Errors
+	for i, item := range items {
+		segment, err := item.toSegment()
+		if err != nil {
+			problems = append(problems, &LineError{Line: i + 1, Reason: err.Error()})
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return &Script{Segments: segments}, nil
+}
+
+func (item jsonSegment) toSegment() (Segment, error) {
+	if item.Start == "" || item.End == "" {
+		return Segment{}, fmt.Errorf("missing start or end timestamp")
+	}
+	start, err := ParseTimestamp(item.Start)
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid start time: %w", err)
+	}
+	end, err := ParseTimestamp(item.End)
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid end time: %w", err)
+	}
+	text := strings.TrimSpace(item.Text)
+	if text == "" {
+		return Segment{}, fmt.Errorf("text is empty")
+	}
+	return Segment{
+		Start:   start,
+		End:     end,
+		Text:    text,
+		Speaker: strings.TrimSpace(item.Speaker),
+		Notes:   strings.TrimSpace(item.Notes),
+	}, nil
+}
+
+// JSON serializes the script into the same format accepted by ParseJSON.
+func (s *Script) JSON() ([]byte, error) {
+	items := make([]jsonSegment, len(s.Segments))
+	for i, segment := range s.Segments {
+		items[i] = jsonSegment{
+			Start:   FormatTimestamp(segment.Start),
+			End:     FormatTimestamp(segment.End),
+			Text:    segment.Text,
+			Speaker: segment.Speaker,
+			Notes:   segment.Notes,
+		}
+	}
+	return json.Marshal(items)
+}
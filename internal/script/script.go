@@ -0,0 +1,237 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Segment is a single timed line of a video script.
+type Segment struct {
+	Start   time.Duration
+	End     time.Duration
+	Text    string
+	Speaker string
+	Notes   string
+}
+
+// Script is an ordered list of timed segments.
+type Script struct {
+	Segments []Segment
+}
+
+// LineError describes a problem with a single line of a script. Line is
+// 1-based: parse errors refer to the source text, validation errors to the
+// segment position.
+type LineError struct {
+	Line   int
+	Reason string
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// Errors collects every problem found while parsing or validating a script.
+type Errors []*LineError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, lineErr := range e {
+		messages[i] = lineErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+var ErrEmptyScript = errors.New("script contains no segments")
+
+var lineRegex = regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})\s*-\s*(\d{2}):(\d{2}):(\d{2}):\s*(.*)$`)
+var speakerRegex = regexp.MustCompile(`^\[([^\]]*)\]\s*(.*)$`)
+
+// notesSeparator divides the narration of a line from its production notes,
+// which are never read out. Narration that contains the separator itself is
+// written with the pipe escaped, so it is not mistaken for notes.
+const (
+	notesSeparator   = " | "
+	escapedSeparator = ` \| `
+)
+
+// Parse reads a script in the 'HH:MM:SS-HH:MM:SS: description' format.
+// Blank lines and markdown code fences are ignored, every other line must match
+// the format. An optional '[Speaker]' prefix on the description is kept as the
+// segment speaker and an optional ' | notes' suffix as the segment notes; a
+// ' \| ' in the narration stands for a literal ' | '. The returned script is
+// not validated, see Validate.
+func Parse(text string) (*Script, error) {
+	var segments []Segment
+	var problems Errors
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "```") {
+			continue
+		}
+
+		segment, err := parseLine(trimmedLine)
+		if err != nil {
+			problems = append(problems, &LineError{Line: i + 1, Reason: err.Error()})
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	if len(segments) == 0 {
+		return nil, ErrEmptyScript
+	}
+	return &Script{Segments: segments}, nil
+}
+
+// ParseAndValidate parses the script and validates it against the video duration.
+func ParseAndValidate(text string, videoDuration time.Duration) (*Script, error) {
+	s, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Validate(videoDuration); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseLine(line string) (Segment, error) {
+	match := lineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return Segment{}, fmt.Errorf("expected 'HH:MM:SS-HH:MM:SS: description', got %q", line)
+	}
+
+	start, err := timestampFromParts(match[1], match[2], match[3])
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid start time: %w", err)
+	}
+	end, err := timestampFromParts(match[4], match[5], match[6])
+	if err != nil {
+		return Segment{}, fmt.Errorf("invalid end time: %w", err)
+	}
+
+	text, notes, _ := strings.Cut(match[7], notesSeparator)
+	text = strings.ReplaceAll(strings.TrimSpace(text), escapedSeparator, notesSeparator)
+	notes = strings.TrimSpace(notes)
+	var speaker string
+	if speakerMatch := speakerRegex.FindStringSubmatch(text); speakerMatch != nil {
+		speaker = strings.TrimSpace(speakerMatch[1])
+		if speaker == "" {
+			return Segment{}, errors.New("speaker is empty")
+		}
+		text = strings.TrimSpace(speakerMatch[2])
+	}
+	if text == "" {
+		return Segment{}, errors.New("description is empty")
+	}
+
+	return Segment{Start: start, End: end, Text: text, Speaker: speaker, Notes: notes}, nil
+}
+
+func timestampFromParts(hours, minutes, seconds string) (time.Duration, error) {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	s, _ := strconv.Atoi(seconds)
+	if m > 59 || s > 59 {
+		return 0, fmt.Errorf("%s:%s:%s is not a valid timestamp", hours, minutes, seconds)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second, nil
+}
+
+// ParseTimestamp parses a single 'HH:MM:SS' timestamp.
+func ParseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("%q is not in HH:MM:SS format", value)
+	}
+	for _, part := range parts {
+		if len(part) != 2 {
+			return 0, fmt.Errorf("%q is not in HH:MM:SS format", value)
+		}
+		if _, err := strconv.Atoi(part); err != nil {
+			return 0, fmt.Errorf("%q is not in HH:MM:SS format", value)
+		}
+	}
+	return timestampFromParts(parts[0], parts[1], parts[2])
+}
+
+// FormatTimestamp renders a duration as 'HH:MM:SS', truncating to whole seconds.
+func FormatTimestamp(d time.Duration) string {
+	total := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, (total%3600)/60, total%60)
+}
+
+// Validate checks that every segment has a positive length, that segments are
+// in order and do not overlap, and that none of them runs past the video.
+// A zero videoDuration skips the duration check.
+func (s *Script) Validate(videoDuration time.Duration) error {
+	if len(s.Segments) == 0 {
+		return ErrEmptyScript
+	}
+
+	var problems Errors
+	for i, segment := range s.Segments {
+		line := i + 1
+		if segment.End <= segment.Start {
+			problems = append(problems, &LineError{Line: line, Reason: fmt.Sprintf("end %s is not after start %s", FormatTimestamp(segment.End), FormatTimestamp(segment.Start))})
+		}
+		if videoDuration > 0 && segment.End > videoDuration {
+			problems = append(problems, &LineError{Line: line, Reason: fmt.Sprintf("end %s is past the video duration %s", FormatTimestamp(segment.End), FormatTimestamp(videoDuration))})
+		}
+		if i == 0 {
+			continue
+		}
+		previous := s.Segments[i-1]
+		if segment.Start < previous.Start {
+			problems = append(problems, &LineError{Line: line, Reason: fmt.Sprintf("start %s is before the previous segment start %s", FormatTimestamp(segment.Start), FormatTimestamp(previous.Start))})
+		} else if segment.Start < previous.End {
+			problems = append(problems, &LineError{Line: line, Reason: fmt.Sprintf("range overlaps the previous segment ending at %s", FormatTimestamp(previous.End))})
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// String serializes the script back into the 'HH:MM:SS-HH:MM:SS: description' format.
+func (s *Script) String() string {
+	lines := make([]string, len(s.Segments))
+	for i, segment := range s.Segments {
+		lines[i] = segment.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (seg Segment) String() string {
+	text := strings.ReplaceAll(seg.Text, notesSeparator, escapedSeparator)
+	if seg.Speaker != "" {
+		text = fmt.Sprintf("[%s] %s", seg.Speaker, text)
+	}
+	if seg.Notes != "" {
+		text += notesSeparator + seg.Notes
+	}
+	return fmt.Sprintf("%s-%s: %s", FormatTimestamp(seg.Start), FormatTimestamp(seg.End), text)
+}
+
+// Duration returns the end of the last segment.
+func (s *Script) Duration() time.Duration {
+	var end time.Duration
+	for _, segment := range s.Segments {
+		if segment.End > end {
+			end = segment.End
+		}
+	}
+	return end
+}
@@ -0,0 +1,192 @@
+package script
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []Segment
+		wantErr string
+	}{
+		{
+			name: "speaker and notes",
+			text: "```\r\n00:00:00-00:00:04: [Host] Welcome back | smile at the camera\r\n\r\n00:00:04-00:01:10: Today we cook\r\n```",
+			want: []Segment{
+				{Start: 0, End: 4 * time.Second, Text: "Welcome back", Speaker: "Host", Notes: "smile at the camera"},
+				{Start: 4 * time.Second, End: 70 * time.Second, Text: "Today we cook"},
+			},
+		},
+		{
+			name: "escaped separator",
+			text: `01:00:00-01:00:05: Pick A \| B | point at both`,
+			want: []Segment{
+				{Start: time.Hour, End: time.Hour + 5*time.Second, Text: "Pick A | B", Notes: "point at both"},
+			},
+		},
+		{
+			name:    "empty",
+			text:    "\n```\n```\n",
+			wantErr: ErrEmptyScript.Error(),
+		},
+		{
+			name:    "bad format",
+			text:    "00:00:00-00:00:04: fine\n0:00-0:04 no colon",
+			wantErr: "line 2: expected 'HH:MM:SS-HH:MM:SS: description'",
+		},
+		{
+			name:    "bad start timestamp",
+			text:    "00:00:60-00:01:04: too many seconds",
+			wantErr: "line 1: invalid start time: 00:00:60 is not a valid timestamp",
+		},
+		{
+			name:    "bad end timestamp",
+			text:    "00:00:00-00:61:00: too many minutes",
+			wantErr: "line 1: invalid end time: 00:61:00 is not a valid timestamp",
+		},
+		{
+			name:    "empty description",
+			text:    "00:00:00-00:00:04: [Host]   | notes only",
+			wantErr: "line 1: description is empty",
+		},
+		{
+			name:    "missing speaker",
+			text:    "00:00:00-00:00:04: [ ] Who says this?",
+			wantErr: "line 1: speaker is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.Segments, tt.want) {
+				t.Fatalf("Parse = %+v, want %+v", parsed.Segments, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReportsEveryLine(t *testing.T) {
+	_, err := Parse("nonsense\n00:00:00-00:00:02: fine\n00:99:00-00:99:01: bad")
+	var problems Errors
+	if !errors.As(err, &problems) {
+		t.Fatalf("Parse error = %v, want Errors", err)
+	}
+	if len(problems) != 2 || problems[0].Line != 1 || problems[1].Line != 3 {
+		t.Fatalf("Parse reported %v, want lines 1 and 3", problems)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	original := &Script{Segments: []Segment{
+		{Start: 0, End: 3 * time.Second, Text: "Cats | dogs, which wins?", Speaker: "Host", Notes: "split screen | both pets"},
+		{Start: 3 * time.Second, End: 6 * time.Second, Text: "Cats."},
+	}}
+	parsed, err := Parse(original.String())
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", original.String(), err)
+	}
+	if !reflect.DeepEqual(parsed, original) {
+		t.Fatalf("round trip = %+v, want %+v", parsed.Segments, original.Segments)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	segment := func(start, end int) Segment {
+		return Segment{Start: time.Duration(start) * time.Second, End: time.Duration(end) * time.Second, Text: "text"}
+	}
+	tests := []struct {
+		name     string
+		segments []Segment
+		duration time.Duration
+		want     []string
+	}{
+		{
+			name:     "valid",
+			segments: []Segment{segment(0, 3), segment(3, 5), segment(8, 10)},
+			duration: 10 * time.Second,
+		},
+		{
+			name:     "no duration check",
+			segments: []Segment{segment(0, 3), segment(3, 500)},
+		},
+		{
+			name:     "end equals start",
+			segments: []Segment{segment(2, 2)},
+			want:     []string{"line 1: end 00:00:02 is not after start 00:00:02"},
+		},
+		{
+			name:     "end before start",
+			segments: []Segment{segment(0, 2), segment(5, 4)},
+			want:     []string{"line 2: end 00:00:04 is not after start 00:00:05"},
+		},
+		{
+			name:     "overlap",
+			segments: []Segment{segment(0, 4), segment(3, 6)},
+			want:     []string{"line 2: range overlaps the previous segment ending at 00:00:04"},
+		},
+		{
+			name:     "out of order",
+			segments: []Segment{segment(5, 8), segment(1, 3)},
+			want:     []string{"line 2: start 00:00:01 is before the previous segment start 00:00:05"},
+		},
+		{
+			name:     "past the video",
+			segments: []Segment{segment(0, 3), segment(3, 12)},
+			duration: 10 * time.Second,
+			want:     []string{"line 2: end 00:00:12 is past the video duration 00:00:10"},
+		},
+		{
+			name:     "every problem",
+			segments: []Segment{segment(4, 4), segment(2, 20)},
+			duration: 10 * time.Second,
+			want: []string{
+				"line 1: end 00:00:04 is not after start 00:00:04",
+				"line 2: end 00:00:20 is past the video duration 00:00:10",
+				"line 2: start 00:00:02 is before the previous segment start 00:00:04",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Script{Segments: tt.segments}).Validate(tt.duration)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			var problems Errors
+			if !errors.As(err, &problems) {
+				t.Fatalf("Validate error = %v, want Errors", err)
+			}
+			var got []string
+			for _, problem := range problems {
+				got = append(got, problem.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Validate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := (&Script{}).Validate(0); !errors.Is(err, ErrEmptyScript) {
+		t.Fatalf("Validate of an empty script = %v, want ErrEmptyScript", err)
+	}
+}
@@ -1,47 +1,226 @@
+// Package state caches user data in front of the database.
 package state
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 	"video-script-bot/internal/models"
+	"video-script-bot/internal/storage"
 )
 
+// Manager caches the data of active users in front of a storage.Repository
+// and passes everything else through to it. Cached data is read again from
+// the repository once it is older than the TTL. Changes update the cache at
+// once and are saved in batches every flush interval, writing only the fields
+// that changed, or right away when the interval is zero.
 type Manager struct {
-	userStates map[int64]models.UserData
-	mu         sync.RWMutex
+	storage.Repository
+	ttl           time.Duration
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	entries map[int64]*entry
+	// flushMu keeps writes of the same user in order.
+	flushMu sync.Mutex
+
+	now func() time.Time
+}
+
+type entry struct {
+	data     models.UserData
+	dirty    storage.UserField
+	loadedAt time.Time
 }
 
-func NewManager() *Manager {
+func NewManager(repository storage.Repository, ttl, flushInterval time.Duration) *Manager {
 	return &Manager{
-		userStates: make(map[int64]models.UserData),
+		Repository:    repository,
+		ttl:           ttl,
+		flushInterval: flushInterval,
+		entries:       make(map[int64]*entry),
+		now:           time.Now,
 	}
 }
 
-func (m *Manager) GetUserData(userID int64) models.UserData {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// GetUserData returns the cached data of the user, loading it when it is
+// missing or expired. Data with unsaved changes never expires.
+func (m *Manager) GetUserData(userID int64) (*models.UserData, error) {
+	m.mu.Lock()
+	if cached, ok := m.entries[userID]; ok && (cached.dirty != 0 || m.now().Sub(cached.loadedAt) < m.ttl) {
+		data := cached.data
+		m.mu.Unlock()
+		return &data, nil
+	}
+	m.mu.Unlock()
 
-	data, ok := m.userStates[userID]
-	if !ok {
-		// Return a default state if user is not found
-		return models.UserData{State: models.StateIdle}
+	data, err := m.Repository.GetUserData(userID)
+	if err != nil {
+		return nil, err
 	}
-	return data
-}
 
-func (m *Manager) SetState(userID int64, state models.UserState) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	// A concurrent update may have changed the user meanwhile.
+	if cached, ok := m.entries[userID]; ok && cached.dirty != 0 {
+		fresh := cached.data
+		return &fresh, nil
+	}
+	m.entries[userID] = &entry{data: *data, loadedAt: m.now()}
+	return data, nil
+}
+
+// SetUserData caches the user's data and marks the fields that changed for
+// the next flush. Data for a user that is not cached, or for another project
+// than the cached one, is saved in full right away.
+func (m *Manager) SetUserData(userID int64, data *models.UserData) error {
+	m.mu.Lock()
+	cached, ok := m.entries[userID]
+	if !ok || cached.data.ProjectID != data.ProjectID {
+		m.mu.Unlock()
+		if err := m.forget(userID); err != nil {
+			return err
+		}
+		return m.Repository.SetUserData(userID, data)
+	}
+	cached.dirty |= storage.ChangedUserFields(&cached.data, data)
+	cached.data = *data
+	flushNow := m.flushInterval <= 0 && cached.dirty != 0
+	m.mu.Unlock()
+
+	if flushNow {
+		return m.Flush()
+	}
+	return nil
+}
+
+// Flush saves every pending change in one batch.
+func (m *Manager) Flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	var updates []storage.UserUpdate
+	for userID, cached := range m.entries {
+		if cached.dirty != 0 {
+			updates = append(updates, storage.UserUpdate{UserID: userID, Data: cached.data, Fields: cached.dirty})
+			cached.dirty = 0
+		}
+	}
+	m.mu.Unlock()
+
+	return m.write(updates)
+}
+
+// forget drops the user from the cache, saving their pending changes first.
+func (m *Manager) forget(userID int64) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	cached, ok := m.entries[userID]
+	delete(m.entries, userID)
+	m.mu.Unlock()
+
+	if !ok || cached.dirty == 0 {
+		return nil
+	}
+	return m.write([]storage.UserUpdate{{UserID: userID, Data: cached.data, Fields: cached.dirty}})
+}
 
-	data := m.userStates[userID]
-	data.State = state
-	m.userStates[userID] = data
-	log.Printf("State for user %d set to %s", userID, state)
+// write saves updates. When that fails they are marked pending again, unless
+// the user has moved on to another project since.
+func (m *Manager) write(updates []storage.UserUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	err := m.Repository.UpdateUsers(updates)
+	if err == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, update := range updates {
+		cached, ok := m.entries[update.UserID]
+		switch {
+		case !ok:
+			m.entries[update.UserID] = &entry{data: update.Data, dirty: update.Fields, loadedAt: m.now()}
+		case cached.data.ProjectID == update.Data.ProjectID:
+			cached.dirty |= update.Fields
+		default:
+			log.Printf("Warning: dropping unsaved changes of user %d to project %d.", update.UserID, update.Data.ProjectID)
+		}
+	}
+	return fmt.Errorf("failed to save changes of %d user(s): %w", len(updates), err)
 }
 
-func (m *Manager) SetUserData(userID int64, data models.UserData) {
+// evictExpired drops the users whose data expired and has no pending changes.
+func (m *Manager) evictExpired() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.userStates[userID] = data
-	log.Printf("Data for user %d updated. New state: %s, FileID: %s", userID, data.State, data.VideoFileID)
+	for userID, cached := range m.entries {
+		if cached.dirty == 0 && m.now().Sub(cached.loadedAt) >= m.ttl {
+			delete(m.entries, userID)
+		}
+	}
+}
+
+// Run flushes pending changes and evicts expired users until ctx is done.
+// Close saves whatever is left.
+func (m *Manager) Run(ctx context.Context) {
+	interval := m.flushInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			m.evictExpired()
+		}
+	}
+}
+
+// The project changes below can move the user to another project, so the
+// user is dropped from the cache and read again on their next update.
+
+func (m *Manager) SetActiveProject(userID, projectID int64) error {
+	return m.changeProjects(userID, m.Repository.SetActiveProject(userID, projectID))
+}
+
+func (m *Manager) RenameProject(userID, projectID int64, name string) error {
+	return m.changeProjects(userID, m.Repository.RenameProject(userID, projectID, name))
+}
+
+func (m *Manager) SetProjectArchived(userID, projectID int64, archived bool) error {
+	return m.changeProjects(userID, m.Repository.SetProjectArchived(userID, projectID, archived))
+}
+
+func (m *Manager) DeleteProject(userID, projectID int64) error {
+	return m.changeProjects(userID, m.Repository.DeleteProject(userID, projectID))
+}
+
+func (m *Manager) changeProjects(userID int64, err error) error {
+	if forgetErr := m.forget(userID); forgetErr != nil {
+		log.Printf("Warning: %v", forgetErr)
+	}
+	return err
+}
+
+// Close saves pending changes and closes the repository.
+func (m *Manager) Close() error {
+	if err := m.Flush(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return m.Repository.Close()
 }
@@ -0,0 +1,292 @@
+package state
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"video-script-bot/internal/models"
+	"video-script-bot/internal/storage"
+)
+
+// fakeClock is a time source that only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeRepository keeps users in memory and records what the manager writes.
+// Everything else panics through the nil storage.Repository.
+type fakeRepository struct {
+	storage.Repository
+
+	mu      sync.Mutex
+	users   map[int64]models.UserData
+	loads   int
+	sets    int
+	batches [][]storage.UserUpdate
+	failing error
+	closed  bool
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{users: map[int64]models.UserData{
+		1: {State: models.StateIdle, Speed: models.DefaultSpeed, ProjectID: 10},
+	}}
+}
+
+func (r *fakeRepository) GetUserData(userID int64) (*models.UserData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loads++
+	data := r.users[userID]
+	return &data, nil
+}
+
+func (r *fakeRepository) SetUserData(userID int64, data *models.UserData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sets++
+	r.users[userID] = *data
+	return nil
+}
+
+func (r *fakeRepository) UpdateUsers(updates []storage.UserUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing != nil {
+		return r.failing
+	}
+	r.batches = append(r.batches, updates)
+	for _, update := range updates {
+		r.users[update.UserID] = update.Data
+	}
+	return nil
+}
+
+func (r *fakeRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *fakeRepository) counts() (loads, sets, batches int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loads, r.sets, len(r.batches)
+}
+
+func newTestManager(flushInterval time.Duration) (*Manager, *fakeRepository, *fakeClock) {
+	repository := newFakeRepository()
+	m := NewManager(repository, time.Minute, flushInterval)
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	m.now = clock.Now
+	return m, repository, clock
+}
+
+func mustGet(t *testing.T, m *Manager, userID int64) *models.UserData {
+	t.Helper()
+	data, err := m.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData(%d): %v", userID, err)
+	}
+	return data
+}
+
+func TestGetUserDataExpiresAfterTTL(t *testing.T) {
+	m, repository, clock := newTestManager(time.Minute)
+
+	mustGet(t, m, 1)
+	clock.Advance(59 * time.Second)
+	mustGet(t, m, 1)
+	if loads, _, _ := repository.counts(); loads != 1 {
+		t.Fatalf("loaded %d times within the TTL, want 1", loads)
+	}
+
+	clock.Advance(time.Second)
+	mustGet(t, m, 1)
+	if loads, _, _ := repository.counts(); loads != 2 {
+		t.Fatalf("loaded %d times after the TTL, want 2", loads)
+	}
+
+	// Expired users are evicted, but not while they have unsaved changes.
+	data := mustGet(t, m, 1)
+	data.State = models.StateWaitingForVideo
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	m.evictExpired()
+	if got := mustGet(t, m, 1); got.State != models.StateWaitingForVideo {
+		t.Fatalf("State = %s after the TTL, want the unsaved %s", got.State, models.StateWaitingForVideo)
+	}
+	if loads, _, _ := repository.counts(); loads != 2 {
+		t.Fatalf("loaded %d times with unsaved changes, want 2", loads)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m.evictExpired()
+	m.mu.Lock()
+	cached := len(m.entries)
+	m.mu.Unlock()
+	if cached != 0 {
+		t.Fatalf("%d users cached after evicting expired data, want 0", cached)
+	}
+}
+
+func TestFlushWritesOnlyChangedFields(t *testing.T) {
+	m, repository, _ := newTestManager(time.Minute)
+
+	data := mustGet(t, m, 1)
+	data.State = models.StateWaitingForSpeed
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	data.Speed = 1.2
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, batches := repository.counts(); batches != 0 {
+		t.Fatalf("wrote %d batches before the flush, want 0", batches)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repository.batches) != 1 || len(repository.batches[0]) != 1 {
+		t.Fatalf("Flush wrote %v, want one update", repository.batches)
+	}
+	if update := repository.batches[0][0]; update.UserID != 1 || update.Fields != storage.FieldState|storage.FieldSpeed {
+		t.Fatalf("Flush wrote fields %b of user %d, want state and speed of user 1", update.Fields, update.UserID)
+	}
+
+	// Setting unchanged data and flushing again writes nothing.
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, sets, batches := repository.counts(); sets != 0 || batches != 1 {
+		t.Fatalf("wrote %d full users and %d batches, want 0 and 1", sets, batches)
+	}
+}
+
+func TestSetUserDataSavesAtOnceWithoutInterval(t *testing.T) {
+	m, repository, _ := newTestManager(0)
+
+	// Users that are not cached are saved in full.
+	if err := m.SetUserData(2, &models.UserData{State: models.StateIdle, ProjectID: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if _, sets, _ := repository.counts(); sets != 1 {
+		t.Fatalf("saved %d users in full, want 1", sets)
+	}
+
+	data := mustGet(t, m, 1)
+	data.ScriptStyle = "funny"
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, batches := repository.counts(); batches != 1 || repository.batches[0][0].Fields != storage.FieldScriptStyle {
+		t.Fatalf("wrote %v, want the script style at once", repository.batches)
+	}
+}
+
+func TestFailedFlushKeepsChanges(t *testing.T) {
+	m, repository, _ := newTestManager(time.Minute)
+
+	data := mustGet(t, m, 1)
+	data.GeneratedScript = "00:00:00-00:00:02: Hi"
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	repository.failing = errors.New("database is locked")
+	if err := m.Flush(); err == nil {
+		t.Fatal("Flush succeeded while the repository failed")
+	}
+	repository.failing = nil
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repository.batches) != 1 || repository.batches[0][0].Fields != storage.FieldGeneratedScript {
+		t.Fatalf("retried flush wrote %v, want the script", repository.batches)
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	m, repository, _ := newTestManager(time.Hour)
+
+	data := mustGet(t, m, 1)
+	data.VoiceID = "voice-1"
+	if err := m.SetUserData(1, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !repository.closed {
+		t.Fatal("Close did not close the repository")
+	}
+	if got := repository.users[1].VoiceID; got != "voice-1" {
+		t.Fatalf("saved voice = %q after Close, want voice-1", got)
+	}
+}
+
+func TestConcurrentGetSet(t *testing.T) {
+	m, repository, clock := newTestManager(time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				data, err := m.GetUserData(1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				data.VideoDuration = i*100 + j
+				if err := m.SetUserData(1, data); err != nil {
+					t.Error(err)
+					return
+				}
+				switch j % 10 {
+				case 0:
+					if err := m.Flush(); err != nil {
+						t.Error(err)
+					}
+				case 5:
+					clock.Advance(time.Minute)
+					m.evictExpired()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cached := mustGet(t, m, 1)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if saved := repository.users[1]; saved != *cached {
+		t.Fatalf("saved %+v, want the cached %+v", saved, *cached)
+	}
+}
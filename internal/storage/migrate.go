@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are numbered SQL files in a migrations directory, NNNN_name.up.sql
+// with an optional NNNN_name.down.sql, applied in order. Never edit a
+// migration that has been released; add a new one instead.
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one step of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied to the database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Dialect is what SQLMigrator and SQLStore need to know about a database.
+type Dialect struct {
+	// Bind returns the placeholder of the nth query argument, counting from 1.
+	// Without it placeholders are written as ?.
+	Bind func(n int) string
+	// Lock, when set, runs first in every migration transaction. It lets
+	// several processes sharing a database start at the same time.
+	Lock string
+	// RowLocks tells that the database supports SELECT ... FOR UPDATE, which
+	// replicas use to take turns on the same rows.
+	RowLocks bool
+}
+
+// Rebind replaces the ? placeholders of query with the dialect's own.
+func (d Dialect) Rebind(query string) string {
+	if d.Bind == nil {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.Bind(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLMigrator applies the migrations in a migrations directory to a database
+// and records them in its schema_migrations table.
+type SQLMigrator struct {
+	db      *sql.DB
+	files   fs.FS
+	dialect Dialect
+}
+
+func NewSQLMigrator(db *sql.DB, files fs.FS, dialect Dialect) *SQLMigrator {
+	return &SQLMigrator{db: db, files: files, dialect: dialect}
+}
+
+// Init creates the schema_migrations table if it does not exist yet.
+func (m *SQLMigrator) Init() error {
+	return m.inLockedTx(func(tx *sql.Tx) error {
+		query := `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at BIGINT NOT NULL
+    );`
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+		return nil
+	})
+}
+
+func (m *SQLMigrator) loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.files, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(m.files, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the schema version this binary expects.
+func (m *SQLMigrator) LatestSchemaVersion() int {
+	migrations, err := m.loadMigrations()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the highest migration applied to the database, or 0.
+func (m *SQLMigrator) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+	if err := m.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists every known migration and whether it is applied.
+func (m *SQLMigrator) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := m.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied.
+func (m *SQLMigrator) MigrateUp() ([]Migration, error) {
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		ran := false
+		err := m.inLockedTx(func(tx *sql.Tx) error {
+			// Another process may have applied it while we waited for the
+			// lock.
+			if done, err := m.isApplied(tx, status.Version); err != nil || done {
+				return err
+			}
+			log.Printf("Database migration: applying %04d_%s.", status.Version, status.Name)
+			if _, err := tx.Exec(status.Up); err != nil {
+				return err
+			}
+			query := m.dialect.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`)
+			if _, err := tx.Exec(query, status.Version, status.Name, time.Now().Unix()); err != nil {
+				return err
+			}
+			ran = true
+			return nil
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", status.Version, status.Name, err)
+		}
+		if ran {
+			applied = append(applied, status.Migration)
+		}
+	}
+	return applied, nil
+}
+
+// MigrateDown rolls back the latest applied migration and returns it, or nil
+// when nothing is applied.
+func (m *SQLMigrator) MigrateDown() (*Migration, error) {
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if !status.Applied {
+			continue
+		}
+		if status.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s cannot be rolled back", status.Version, status.Name)
+		}
+		log.Printf("Database migration: rolling back %04d_%s.", status.Version, status.Name)
+		err := m.inLockedTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(status.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(m.dialect.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), status.Version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rollback of %04d_%s failed: %w", status.Version, status.Name, err)
+		}
+		return &status.Migration, nil
+	}
+	return nil, nil
+}
+
+// CheckSchema makes sure the database is at the version this binary expects,
+// applying pending migrations when autoMigrate is set.
+func (m *SQLMigrator) CheckSchema(autoMigrate bool) error {
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	version, err := m.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := m.LatestSchemaVersion()
+	switch {
+	case version > latest:
+		return fmt.Errorf("database schema is at version %d but this build only knows up to %d; run a newer build or roll back with 'migrate down'", version, latest)
+	case pending > 0 && !autoMigrate:
+		return fmt.Errorf("database schema is at version %d with %d pending migration(s); run 'migrate up' first", version, pending)
+	case pending > 0:
+		if _, err := m.MigrateUp(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SQLMigrator) isApplied(tx *sql.Tx, version int) (bool, error) {
+	var count int
+	if err := tx.QueryRow(m.dialect.Rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up migration %d: %w", version, err)
+	}
+	return count > 0, nil
+}
+
+func (m *SQLMigrator) inLockedTx(fn func(tx *sql.Tx) error) error {
+	return InTx(m.db, func(tx *sql.Tx) error {
+		if m.dialect.Lock != "" {
+			if _, err := tx.Exec(m.dialect.Lock); err != nil {
+				return fmt.Errorf("failed to lock the schema: %w", err)
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// InTx runs fn in a transaction, committing it when fn succeeds.
+func InTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"video-script-bot/internal/jobs"
+	"video-script-bot/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CreateJob implements jobs.Store.
+func (s *Storage) CreateJob(job *jobs.Job) error {
+	now := time.Now()
+	err := s.db.QueryRow(
+		`INSERT INTO jobs (job_type, user_id, chat_id, payload, status, attempts, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, 0, $6, $7) RETURNING id`,
+		job.Type, job.UserID, job.ChatID, job.Payload, string(jobs.StatusPending), now.Unix(), now.Unix(),
+	).Scan(&job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to insert job: %w", err)
+	}
+	job.Status = jobs.StatusPending
+	job.CreatedAt = time.Unix(now.Unix(), 0)
+	job.UpdatedAt = job.CreatedAt
+	return nil
+}
+
+// ClaimJob implements jobs.Store. SKIP LOCKED lets the workers of every
+// replica claim different jobs without waiting for each other. Two replicas
+// claiming jobs of the same user at once are stopped by the unique index on
+// running jobs; the one that loses claims nothing this time.
+func (s *Storage) ClaimJob() (*jobs.Job, error) {
+	var job jobs.Job
+	var createdAt int64
+	now := time.Now()
+	err := s.db.QueryRow(`
+    UPDATE jobs SET status = $1, attempts = attempts + 1, cancel_requested = FALSE, claimed_by = $2, updated_at = $3
+    WHERE id = (
+        SELECT id FROM jobs WHERE status = $4 AND user_id NOT IN (SELECT user_id FROM jobs WHERE status = $1)
+        ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+    RETURNING id, job_type, user_id, chat_id, payload, attempts, created_at`,
+		string(jobs.StatusRunning), s.instance, now.Unix(), string(jobs.StatusPending),
+	).Scan(&job.ID, &job.Type, &job.UserID, &job.ChatID, &job.Payload, &job.Attempts, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	job.Status = jobs.StatusRunning
+	job.CreatedAt = time.Unix(createdAt, 0)
+	job.UpdatedAt = time.Unix(now.Unix(), 0)
+	return &job, nil
+}
+
+// FinishJob implements jobs.Store.
+func (s *Storage) FinishJob(id int64, status jobs.Status, result, errMessage string) error {
+	query := `UPDATE jobs SET status = $1, result = $2, error_message = $3, updated_at = $4 WHERE id = $5`
+	args := []any{string(status), result, errMessage, time.Now().Unix(), id}
+	if status == jobs.StatusPending {
+		query = `UPDATE jobs SET status = ` + requeuedStatus + `, result = $3, error_message = $4, updated_at = $5 WHERE id = $6`
+		args = []any{string(status), string(jobs.StatusCancelled), result, errMessage, time.Now().Unix(), id}
+	}
+	_, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update job %d: %w", id, err)
+	}
+	return nil
+}
+
+// CancelUserJobs implements jobs.Store. The replica running the user's job
+// stops it when it next renews the job's lease.
+func (s *Storage) CancelUserJobs(userID int64) (int, error) {
+	var count int64
+	err := storage.InTx(s.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE jobs SET status = $1, updated_at = $2 WHERE user_id = $3 AND status = $4`,
+			string(jobs.StatusCancelled), time.Now().Unix(), userID, string(jobs.StatusPending),
+		)
+		if err != nil {
+			return err
+		}
+		if count, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE jobs SET cancel_requested = TRUE WHERE user_id = $1 AND status = $2`, userID, string(jobs.StatusRunning))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel jobs of user %d: %w", userID, err)
+	}
+	return int(count), nil
+}
+
+// uniqueViolation is the SQLSTATE of a unique index conflict.
+const uniqueViolation = "23505"
+
+// requeuedStatus sets a running job back to pending ($1), or to cancelled
+// ($2) when it was asked to stop.
+const requeuedStatus = `CASE WHEN cancel_requested THEN $2 ELSE $1 END`
+
+// RequeueRunningJobs implements jobs.Store. Only the jobs this host was
+// running are requeued, since the other replicas are still running theirs.
+// Jobs of replicas that are gone are left to RequeueStaleJobs.
+func (s *Storage) RequeueRunningJobs() (int, error) {
+	res, err := s.db.Exec(
+		`UPDATE jobs SET status = `+requeuedStatus+`, updated_at = $3 WHERE status = $4 AND claimed_by = $5`,
+		string(jobs.StatusPending), string(jobs.StatusCancelled), time.Now().Unix(), string(jobs.StatusRunning), s.instance,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue running jobs: %w", err)
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// RenewJobs implements jobs.Store.
+func (s *Storage) RenewJobs(ids []int64) ([]int64, error) {
+	now := time.Now().Unix()
+	var cancelled []int64
+	err := storage.InTx(s.db, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			var cancel bool
+			err := tx.QueryRow(`UPDATE jobs SET updated_at = $1 WHERE id = $2 AND status = $3 RETURNING cancel_requested`, now, id, string(jobs.StatusRunning)).Scan(&cancel)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to renew job %d: %w", id, err)
+			}
+			if cancel {
+				cancelled = append(cancelled, id)
+			}
+		}
+		return nil
+	})
+	return cancelled, err
+}
+
+// RequeueStaleJobs implements jobs.Store. It catches the jobs of replicas that
+// crashed and came back under another name, or never came back, which
+// RequeueRunningJobs leaves alone.
+func (s *Storage) RequeueStaleJobs(before time.Time) (int, error) {
+	res, err := s.db.Exec(
+		`UPDATE jobs SET status = `+requeuedStatus+`, updated_at = $3 WHERE status = $4 AND updated_at < $5`,
+		string(jobs.StatusPending), string(jobs.StatusCancelled), time.Now().Unix(), string(jobs.StatusRunning), before.Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue stale jobs: %w", err)
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS api_key_usage;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS scripts;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,76 @@
+CREATE TABLE users (
+    user_id BIGINT PRIMARY KEY,
+    state TEXT NOT NULL,
+    stability REAL DEFAULT 0.75,
+    clarity REAL DEFAULT 0.75,
+    speed REAL DEFAULT 1.0,
+    merge_audio BOOLEAN DEFAULT FALSE,
+    tts_provider TEXT,
+    active_project_id BIGINT
+);
+
+CREATE TABLE projects (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    video_file_id TEXT,
+    video_mime_type TEXT,
+    video_duration INTEGER DEFAULT 0,
+    video_file_size BIGINT DEFAULT 0,
+    script_style TEXT,
+    generated_script TEXT,
+    voice_id TEXT,
+    narration_file_id TEXT,
+    created_at BIGINT NOT NULL,
+    updated_at BIGINT NOT NULL
+);
+CREATE INDEX idx_projects_user ON projects (user_id, archived);
+
+CREATE TABLE scripts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    project_id BIGINT NOT NULL,
+    version INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    content TEXT NOT NULL,
+    style TEXT,
+    instructions TEXT,
+    provider TEXT,
+    model TEXT,
+    restored_from INTEGER,
+    created_at BIGINT NOT NULL,
+    UNIQUE (project_id, version)
+);
+
+-- claimed_by is the host running a job, so that a replica starting up only
+-- requeues the jobs it was running itself.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    job_type TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    chat_id BIGINT NOT NULL,
+    payload BYTEA,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    result TEXT,
+    error_message TEXT,
+    claimed_by TEXT,
+    created_at BIGINT NOT NULL,
+    updated_at BIGINT NOT NULL
+);
+CREATE INDEX idx_jobs_status ON jobs (status, id);
+CREATE INDEX idx_jobs_user_status ON jobs (user_id, status);
+
+CREATE TABLE api_key_usage (
+    id BIGSERIAL PRIMARY KEY,
+    provider TEXT NOT NULL,
+    key_fingerprint TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    error_class TEXT,
+    error_message TEXT,
+    latency_ms BIGINT NOT NULL,
+    units INTEGER NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL
+);
+CREATE INDEX idx_api_key_usage_key_time ON api_key_usage (provider, key_fingerprint, created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_jobs_running_user;
+ALTER TABLE jobs DROP COLUMN IF EXISTS cancel_requested;
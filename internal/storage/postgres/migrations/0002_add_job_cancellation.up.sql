@@ -0,0 +1,7 @@
+-- A cancel request for a running job is stored, so the replica running it
+-- sees it when it renews the job's lease.
+ALTER TABLE jobs ADD COLUMN cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+-- Each user has at most one running job, whichever replica runs it.
+UPDATE jobs SET status = 'pending'
+WHERE status = 'running' AND id NOT IN (SELECT MIN(id) FROM jobs WHERE status = 'running' GROUP BY user_id);
+CREATE UNIQUE INDEX idx_jobs_running_user ON jobs (user_id) WHERE status = 'running';
@@ -0,0 +1,90 @@
+// Package postgres stores the bot's data in PostgreSQL, so that several bot
+// replicas can share it.
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"strconv"
+	"video-script-bot/internal/storage"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Migrations are numbered SQL files, NNNN_name.up.sql with an optional
+// NNNN_name.down.sql, applied in order. Never edit a migration that has been
+// released; add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key held while migrating, so replicas
+// starting together apply each migration once.
+const migrationLock = 7206121
+
+type Storage struct {
+	*storage.SQLMigrator
+	*storage.SQLStore
+	db *sql.DB
+	// instance names this process in the jobs it claims.
+	instance string
+}
+
+// New connects to the database and makes sure its schema is up to date.
+// Pending migrations are applied when autoMigrate is set; otherwise New fails
+// until they are applied with the migrate command.
+func New(databaseURL string, autoMigrate bool) (*Storage, error) {
+	store, err := Open(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CheckSchema(autoMigrate); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return store, nil
+}
+
+// Open connects to the database without applying migrations.
+func Open(databaseURL string) (*Storage, error) {
+	if databaseURL == "" {
+		return nil, fmt.Errorf("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = strconv.Itoa(os.Getpid())
+	}
+	dialect := storage.Dialect{
+		Bind:     func(n int) string { return "$" + strconv.Itoa(n) },
+		Lock:     fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", migrationLock),
+		RowLocks: true,
+	}
+	store := &Storage{
+		SQLMigrator: storage.NewSQLMigrator(db, migrationFiles, dialect),
+		SQLStore:    storage.NewSQLStore(db, dialect),
+		db:          db,
+		instance:    instance,
+	}
+	if err := store.Init(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	return store, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
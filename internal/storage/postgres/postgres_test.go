@@ -0,0 +1,76 @@
+package postgres_test
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"video-script-bot/internal/storage"
+	"video-script-bot/internal/storage/postgres"
+	"video-script-bot/internal/storage/storagetest"
+)
+
+// TestRepository runs the repository suite against the database in
+// TEST_DATABASE_URL, which `make test-postgres` starts with docker compose.
+// Each test works in a schema of its own that is dropped afterwards, so the
+// database's own tables are never touched.
+func TestRepository(t *testing.T) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set; run make test-postgres")
+	}
+	admin, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer admin.Close()
+	if err := admin.Ping(); err != nil {
+		t.Fatalf("PostgreSQL is not available: %v", err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Repository {
+		schema := newSchemaName(t)
+		if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatalf("failed to create schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+				t.Errorf("failed to drop schema %s: %v", schema, err)
+			}
+		})
+
+		store, err := postgres.Open(withSearchPath(t, databaseURL, schema))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func newSchemaName(t *testing.T) string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate schema name: %v", err)
+	}
+	return "storagetest_" + hex.EncodeToString(b)
+}
+
+// withSearchPath makes every connection of databaseURL use schema, for both
+// URLs and key=value connection strings.
+func withSearchPath(t *testing.T, databaseURL, schema string) string {
+	if !strings.HasPrefix(databaseURL, "postgres://") && !strings.HasPrefix(databaseURL, "postgresql://") {
+		return databaseURL + " search_path=" + schema
+	}
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,201 @@
+// Package storage describes what the bot keeps in a database. The sqlite and
+// postgres packages implement it.
+package storage
+
+import (
+	"time"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/jobs"
+	"video-script-bot/internal/models"
+)
+
+// Repository is the database of the bot: user settings, projects with their
+// script history, the job queue and API key usage.
+type Repository interface {
+	UserRepository
+	ProjectRepository
+	ScriptRepository
+	UsageRepository
+	jobs.Store
+	Migrator
+	Close() error
+}
+
+// UserRepository stores each user's state and settings together with their
+// active project.
+type UserRepository interface {
+	// GetUserData returns the user's data, creating the user and a first
+	// project when they are new.
+	GetUserData(userID int64) (*models.UserData, error)
+	// SetUserData saves the user's state and settings, and the project fields
+	// to the project in data.ProjectID. It does not change which project is
+	// active.
+	SetUserData(userID int64, data *models.UserData) error
+	// UpdateUsers writes only the listed fields of users that already exist,
+	// all in one transaction.
+	UpdateUsers(updates []UserUpdate) error
+}
+
+type ProjectRepository interface {
+	// CreateProject creates an empty project. Without a name it is called
+	// "Project N", numbered after the user's existing projects.
+	CreateProject(userID int64, name string) (*Project, error)
+	// Projects returns the user's active or archived projects, most recently
+	// used first.
+	Projects(userID int64, archived bool) ([]Project, error)
+	// Project returns one of the user's projects, or nil if they have no
+	// project with that id.
+	Project(userID, projectID int64) (*Project, error)
+	// SetActiveProject makes the project the one the user's handlers work on.
+	SetActiveProject(userID, projectID int64) error
+	RenameProject(userID, projectID int64, name string) error
+	SetProjectArchived(userID, projectID int64, archived bool) error
+	// DeleteProject deletes a project together with its script history.
+	DeleteProject(userID, projectID int64) error
+}
+
+type ScriptRepository interface {
+	// AddScriptVersion stores script as the next version of its project and
+	// sets its Version.
+	AddScriptVersion(script *ScriptVersion) error
+	// ScriptVersions returns the latest script versions of one of the user's
+	// projects, newest first.
+	ScriptVersions(userID, projectID int64, limit int) ([]ScriptVersion, error)
+	// ScriptVersion returns a script version of one of the user's projects, or
+	// nil if there is no such version.
+	ScriptVersion(userID, projectID int64, version int) (*ScriptVersion, error)
+}
+
+type UsageRepository interface {
+	apikeys.UsageRecorder
+	// KeyUsageSince returns per-key totals for calls made at or after since.
+	KeyUsageSince(since time.Time) ([]KeyUsageStats, error)
+	// LastKeyErrors returns the most recent failure of every key that has
+	// failed.
+	LastKeyErrors() ([]KeyError, error)
+}
+
+// Migrator manages the versioned schema of a database.
+type Migrator interface {
+	// LatestSchemaVersion is the schema version this binary expects.
+	LatestSchemaVersion() int
+	// SchemaVersion returns the highest migration applied to the database,
+	// or 0.
+	SchemaVersion() (int, error)
+	// MigrationStatus lists every known migration and whether it is applied.
+	MigrationStatus() ([]MigrationStatus, error)
+	// MigrateUp applies every pending migration in order and returns the
+	// ones it applied.
+	MigrateUp() ([]Migration, error)
+	// MigrateDown rolls back the latest applied migration and returns it, or
+	// nil when nothing is applied.
+	MigrateDown() (*Migration, error)
+}
+
+// Where a script version came from.
+const (
+	ScriptGenerated = "generated"
+	ScriptRevised   = "revised"
+	ScriptRestored  = "restored"
+)
+
+// ScriptVersion is one script a project has had, numbered from 1 per project.
+// Instructions is the revision request of revised scripts and RestoredFrom the
+// version a restored script was copied from.
+type ScriptVersion struct {
+	UserID       int64
+	ProjectID    int64
+	Version      int
+	Source       string
+	Content      string
+	Style        string
+	Instructions string
+	Provider     string
+	Model        string
+	RestoredFrom int
+	CreatedAt    time.Time
+}
+
+// Project is one video a user is working on, with its script, voice and
+// narration. Each user has one active project that the handlers work on.
+type Project struct {
+	ID              int64
+	UserID          int64
+	Name            string
+	Archived        bool
+	VideoFileID     string
+	VideoMimeType   string
+	VideoDuration   int
+	VideoFileSize   int
+	ScriptStyle     string
+	GeneratedScript string
+	VoiceID         string
+	NarrationFileID string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// ApplyTo makes p the project of userData.
+func (p *Project) ApplyTo(userData *models.UserData) {
+	userData.ProjectID = p.ID
+	userData.ProjectName = p.Name
+	userData.VideoFileID = p.VideoFileID
+	userData.VideoMimeType = p.VideoMimeType
+	userData.VideoDuration = p.VideoDuration
+	userData.VideoFileSize = p.VideoFileSize
+	userData.ScriptStyle = p.ScriptStyle
+	userData.GeneratedScript = p.GeneratedScript
+	userData.VoiceID = p.VoiceID
+	userData.NarrationFileID = p.NarrationFileID
+}
+
+// LoadActiveProject fills userData from the user's active project. When that
+// project is gone or archived, the most recently used other project becomes
+// active, and a user without projects gets a new one.
+func LoadActiveProject(projects ProjectRepository, userID int64, userData *models.UserData, activeProjectID int64) error {
+	var project *Project
+	var err error
+	if activeProjectID != 0 {
+		if project, err = projects.Project(userID, activeProjectID); err != nil {
+			return err
+		}
+		if project != nil && project.Archived {
+			project = nil
+		}
+	}
+	if project == nil {
+		existing, err := projects.Projects(userID, false)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			project = &existing[0]
+		} else if project, err = projects.CreateProject(userID, ""); err != nil {
+			return err
+		}
+		if err := projects.SetActiveProject(userID, project.ID); err != nil {
+			return err
+		}
+	}
+	project.ApplyTo(userData)
+	return nil
+}
+
+// KeyUsageStats sums up the calls made with one key over a period.
+type KeyUsageStats struct {
+	Provider    string
+	Fingerprint string
+	Calls       int
+	Failures    int
+	Units       int
+	AvgLatency  time.Duration
+}
+
+// KeyError is the most recent failure recorded for a key.
+type KeyError struct {
+	Provider    string
+	Fingerprint string
+	ErrorClass  string
+	Message     string
+	At          time.Time
+}
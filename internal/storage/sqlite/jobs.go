@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"video-script-bot/internal/jobs"
+	"video-script-bot/internal/storage"
+)
+
+// CreateJob implements jobs.Store.
+func (s *Storage) CreateJob(job *jobs.Job) error {
+	now := time.Now()
+	res, err := s.db.Exec(
+		`INSERT INTO jobs (job_type, user_id, chat_id, payload, status, attempts, created_at, updated_at) VALUES (?, ?, ?, ?, ?, 0, ?, ?)`,
+		job.Type, job.UserID, job.ChatID, job.Payload, jobs.StatusPending, now.Unix(), now.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert job: %w", err)
+	}
+	if job.ID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to read job ID: %w", err)
+	}
+	job.Status = jobs.StatusPending
+	job.CreatedAt = time.Unix(now.Unix(), 0)
+	job.UpdatedAt = job.CreatedAt
+	return nil
+}
+
+// ClaimJob implements jobs.Store. The single UPDATE keeps two workers from
+// claiming the same job.
+func (s *Storage) ClaimJob() (*jobs.Job, error) {
+	var job jobs.Job
+	var createdAt int64
+	now := time.Now()
+	err := s.db.QueryRow(`
+    UPDATE jobs SET status = ?, attempts = attempts + 1, cancel_requested = 0, updated_at = ?
+    WHERE id = (
+        SELECT id FROM jobs WHERE status = ? AND user_id NOT IN (SELECT user_id FROM jobs WHERE status = ?)
+        ORDER BY id LIMIT 1)
+    RETURNING id, job_type, user_id, chat_id, payload, attempts, created_at`,
+		jobs.StatusRunning, now.Unix(), jobs.StatusPending, jobs.StatusRunning,
+	).Scan(&job.ID, &job.Type, &job.UserID, &job.ChatID, &job.Payload, &job.Attempts, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	job.Status = jobs.StatusRunning
+	job.CreatedAt = time.Unix(createdAt, 0)
+	job.UpdatedAt = time.Unix(now.Unix(), 0)
+	return &job, nil
+}
+
+// FinishJob implements jobs.Store.
+func (s *Storage) FinishJob(id int64, status jobs.Status, result, errMessage string) error {
+	query := `UPDATE jobs SET status = ?, result = ?, error_message = ?, updated_at = ? WHERE id = ?`
+	args := []any{status, result, errMessage, time.Now().Unix(), id}
+	if status == jobs.StatusPending {
+		query = `UPDATE jobs SET status = ` + requeuedStatus + `, result = ?, error_message = ?, updated_at = ? WHERE id = ?`
+		args = []any{jobs.StatusCancelled, status, result, errMessage, time.Now().Unix(), id}
+	}
+	_, err := s.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update job %d: %w", id, err)
+	}
+	return nil
+}
+
+// CancelUserJobs implements jobs.Store.
+func (s *Storage) CancelUserJobs(userID int64) (int, error) {
+	var count int64
+	err := storage.InTx(s.db, func(tx *sql.Tx) error {
+		now := time.Now().Unix()
+		res, err := tx.Exec(
+			`UPDATE jobs SET status = ?, updated_at = ? WHERE user_id = ? AND status = ?`,
+			jobs.StatusCancelled, now, userID, jobs.StatusPending,
+		)
+		if err != nil {
+			return err
+		}
+		if count, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE jobs SET cancel_requested = 1 WHERE user_id = ? AND status = ?`, userID, jobs.StatusRunning)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel jobs of user %d: %w", userID, err)
+	}
+	return int(count), nil
+}
+
+// requeuedStatus sets a running job back to pending, or to cancelled when it
+// was asked to stop. It takes the cancelled and pending statuses as
+// arguments.
+const requeuedStatus = `CASE WHEN cancel_requested = 1 THEN ? ELSE ? END`
+
+// RequeueRunningJobs implements jobs.Store.
+func (s *Storage) RequeueRunningJobs() (int, error) {
+	res, err := s.db.Exec(
+		`UPDATE jobs SET status = `+requeuedStatus+`, updated_at = ? WHERE status = ?`,
+		jobs.StatusCancelled, jobs.StatusPending, time.Now().Unix(), jobs.StatusRunning,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue running jobs: %w", err)
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// RenewJobs implements jobs.Store.
+func (s *Storage) RenewJobs(ids []int64) ([]int64, error) {
+	now := time.Now().Unix()
+	var cancelled []int64
+	err := storage.InTx(s.db, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			var cancel bool
+			err := tx.QueryRow(`UPDATE jobs SET updated_at = ? WHERE id = ? AND status = ? RETURNING cancel_requested`, now, id, jobs.StatusRunning).Scan(&cancel)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to renew job %d: %w", id, err)
+			}
+			if cancel {
+				cancelled = append(cancelled, id)
+			}
+		}
+		return nil
+	})
+	return cancelled, err
+}
+
+// RequeueStaleJobs implements jobs.Store.
+func (s *Storage) RequeueStaleJobs(before time.Time) (int, error) {
+	res, err := s.db.Exec(
+		`UPDATE jobs SET status = `+requeuedStatus+`, updated_at = ? WHERE status = ? AND updated_at < ?`,
+		jobs.StatusCancelled, jobs.StatusPending, time.Now().Unix(), jobs.StatusRunning, before.Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue stale jobs: %w", err)
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migrations are numbered SQL files, NNNN_name.up.sql with an optional
+// NNNN_name.down.sql, applied in order. Never edit a migration that has been
+// released; add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// legacyUserColumns are the users columns that were added with ALTER TABLE
+// before migrations were versioned. A database from that time may miss some.
+var legacyUserColumns = []struct{ name, definition string }{
+	{"stability", "REAL DEFAULT 0.75"},
+	{"clarity", "REAL DEFAULT 0.75"},
+	{"speed", "REAL DEFAULT 1.0"},
+	{"video_duration", "INTEGER DEFAULT 0"},
+	{"merge_audio", "INTEGER DEFAULT 0"},
+	{"video_file_size", "INTEGER DEFAULT 0"},
+	{"narration_file_id", "TEXT"},
+	{"tts_provider", "TEXT"},
+}
+
+// initMigrations creates the schema_migrations table. A database created
+// before migrations were versioned is brought up to the first migration and
+// recorded as being at it.
+func (s *Storage) initMigrations() error {
+	tracked, err := s.tableExists("schema_migrations")
+	if err != nil {
+		return err
+	}
+	if tracked {
+		return nil
+	}
+	legacy, err := s.tableExists("users")
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	if legacy {
+		for _, column := range legacyUserColumns {
+			if !s.columnExists("users", column.name) {
+				missing = append(missing, fmt.Sprintf("ALTER TABLE users ADD COLUMN %s %s", column.name, column.definition))
+			}
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+    CREATE TABLE schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at INTEGER NOT NULL
+    );`
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	if legacy {
+		log.Printf("Database migration: adopting a database created before versioned migrations (%d column(s) missing).", len(missing))
+		for _, statement := range missing {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to upgrade legacy users table: %w", err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (1, 'create_users', ?)`, time.Now().Unix()); err != nil {
+			return fmt.Errorf("failed to record legacy schema: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) tableExists(name string) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", name, err)
+	}
+	return count > 0, nil
+}
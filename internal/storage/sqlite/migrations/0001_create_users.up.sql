@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users (
+    user_id INTEGER PRIMARY KEY,
+    state TEXT NOT NULL,
+    video_file_id TEXT,
+    video_mime_type TEXT,
+    script_style TEXT,
+    generated_script TEXT,
+    stability REAL DEFAULT 0.75,
+    clarity REAL DEFAULT 0.75,
+    speed REAL DEFAULT 1.0,
+    video_duration INTEGER DEFAULT 0,
+    merge_audio INTEGER DEFAULT 0,
+    video_file_size INTEGER DEFAULT 0,
+    narration_file_id TEXT,
+    tts_provider TEXT
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    provider TEXT NOT NULL,
+    key_fingerprint TEXT NOT NULL,
+    success INTEGER NOT NULL,
+    error_class TEXT,
+    error_message TEXT,
+    latency_ms INTEGER NOT NULL,
+    units INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_api_key_usage_key_time ON api_key_usage (provider, key_fingerprint, created_at);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS scripts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    content TEXT NOT NULL,
+    style TEXT,
+    instructions TEXT,
+    provider TEXT,
+    model TEXT,
+    restored_from INTEGER,
+    created_at INTEGER NOT NULL,
+    UNIQUE (user_id, version)
+);
@@ -0,0 +1,42 @@
+ALTER TABLE users ADD COLUMN video_file_id TEXT;
+ALTER TABLE users ADD COLUMN video_mime_type TEXT;
+ALTER TABLE users ADD COLUMN video_duration INTEGER DEFAULT 0;
+ALTER TABLE users ADD COLUMN video_file_size INTEGER DEFAULT 0;
+ALTER TABLE users ADD COLUMN script_style TEXT;
+ALTER TABLE users ADD COLUMN generated_script TEXT;
+ALTER TABLE users ADD COLUMN narration_file_id TEXT;
+
+-- Only the active project of each user survives.
+UPDATE users SET
+    video_file_id = projects.video_file_id,
+    video_mime_type = projects.video_mime_type,
+    video_duration = projects.video_duration,
+    video_file_size = projects.video_file_size,
+    script_style = projects.script_style,
+    generated_script = projects.generated_script,
+    narration_file_id = projects.narration_file_id
+FROM projects WHERE projects.id = users.active_project_id;
+
+ALTER TABLE users DROP COLUMN active_project_id;
+
+CREATE TABLE scripts_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    content TEXT NOT NULL,
+    style TEXT,
+    instructions TEXT,
+    provider TEXT,
+    model TEXT,
+    restored_from INTEGER,
+    created_at INTEGER NOT NULL,
+    UNIQUE (user_id, version)
+);
+INSERT INTO scripts_old (id, user_id, version, source, content, style, instructions, provider, model, restored_from, created_at)
+SELECT id, user_id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY id), source, content, style, instructions, provider, model, NULL, created_at
+FROM scripts;
+DROP TABLE scripts;
+ALTER TABLE scripts_old RENAME TO scripts;
+
+DROP TABLE projects;
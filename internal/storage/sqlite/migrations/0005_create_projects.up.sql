@@ -0,0 +1,56 @@
+CREATE TABLE projects (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    archived INTEGER NOT NULL DEFAULT 0,
+    video_file_id TEXT,
+    video_mime_type TEXT,
+    video_duration INTEGER DEFAULT 0,
+    video_file_size INTEGER DEFAULT 0,
+    script_style TEXT,
+    generated_script TEXT,
+    voice_id TEXT,
+    narration_file_id TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+CREATE INDEX idx_projects_user ON projects (user_id, archived);
+
+-- Each user's current work becomes their first project.
+INSERT INTO projects (user_id, name, video_file_id, video_mime_type, video_duration, video_file_size, script_style, generated_script, narration_file_id, created_at, updated_at)
+SELECT user_id, 'Project 1', video_file_id, video_mime_type, video_duration, video_file_size, script_style, generated_script, narration_file_id, CAST(strftime('%s', 'now') AS INTEGER), CAST(strftime('%s', 'now') AS INTEGER)
+FROM users;
+
+ALTER TABLE users ADD COLUMN active_project_id INTEGER;
+UPDATE users SET active_project_id = (SELECT MIN(id) FROM projects WHERE projects.user_id = users.user_id);
+
+ALTER TABLE users DROP COLUMN video_file_id;
+ALTER TABLE users DROP COLUMN video_mime_type;
+ALTER TABLE users DROP COLUMN video_duration;
+ALTER TABLE users DROP COLUMN video_file_size;
+ALTER TABLE users DROP COLUMN script_style;
+ALTER TABLE users DROP COLUMN generated_script;
+ALTER TABLE users DROP COLUMN narration_file_id;
+
+-- Script history moves from users to projects and is numbered per project.
+CREATE TABLE scripts_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    project_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    content TEXT NOT NULL,
+    style TEXT,
+    instructions TEXT,
+    provider TEXT,
+    model TEXT,
+    restored_from INTEGER,
+    created_at INTEGER NOT NULL,
+    UNIQUE (project_id, version)
+);
+INSERT INTO scripts_new (id, user_id, project_id, version, source, content, style, instructions, provider, model, restored_from, created_at)
+SELECT scripts.id, scripts.user_id, users.active_project_id, scripts.version, scripts.source, scripts.content, scripts.style, scripts.instructions, scripts.provider, scripts.model, scripts.restored_from, scripts.created_at
+FROM scripts JOIN users ON users.user_id = scripts.user_id
+WHERE users.active_project_id IS NOT NULL;
+DROP TABLE scripts;
+ALTER TABLE scripts_new RENAME TO scripts;
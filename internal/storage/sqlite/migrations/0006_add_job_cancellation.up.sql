@@ -0,0 +1,7 @@
+-- A cancel request for a running job is stored, so the process running it
+-- sees it when it renews the job's lease.
+ALTER TABLE jobs ADD COLUMN cancel_requested INTEGER NOT NULL DEFAULT 0;
+-- Each user has at most one running job, whichever process runs it.
+UPDATE jobs SET status = 'pending'
+WHERE status = 'running' AND id NOT IN (SELECT MIN(id) FROM jobs WHERE status = 'running' GROUP BY user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_running_user ON jobs (user_id) WHERE status = 'running';
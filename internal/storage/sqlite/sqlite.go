@@ -0,0 +1,89 @@
+// Package sqlite stores the bot's data in a SQLite file. It is the default
+// storage.Repository.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"video-script-bot/internal/storage"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Storage struct {
+	*storage.SQLMigrator
+	*storage.SQLStore
+	db *sql.DB
+}
+
+// New opens the database and makes sure its schema is up to date. Pending
+// migrations are applied when autoMigrate is set; otherwise New fails until
+// they are applied with the migrate command.
+func New(databasePath string, autoMigrate bool) (*Storage, error) {
+	store, err := Open(databasePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.CheckSchema(autoMigrate); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return store, nil
+}
+
+// Open opens the database without applying migrations.
+func Open(databasePath string) (*Storage, error) {
+	db, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	store := &Storage{
+		SQLMigrator: storage.NewSQLMigrator(db, migrationFiles, storage.Dialect{}),
+		SQLStore:    storage.NewSQLStore(db, storage.Dialect{}),
+		db:          db,
+	}
+	if err := store.initMigrations(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	return store, nil
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func (s *Storage) columnExists(tableName, columnName string) bool {
+	// Sanitize table name to prevent SQL injection, although it's internally controlled here.
+	cleanTableName := strings.ReplaceAll(tableName, "'", "''")
+	query := fmt.Sprintf("PRAGMA table_info('%s')", cleanTableName)
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		log.Printf("Could not query table info for %s: %v", tableName, err)
+		return false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name string
+		var type_ string
+		var notnull int
+		var dflt_value interface{}
+		var pk int
+		if err := rows.Scan(&cid, &name, &type_, &notnull, &dflt_value, &pk); err != nil {
+			log.Printf("Could not scan table info row: %v", err)
+			continue
+		}
+		if name == columnName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+	"video-script-bot/internal/storage"
+	"video-script-bot/internal/storage/sqlite"
+	"video-script-bot/internal/storage/storagetest"
+)
+
+func TestRepository(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Repository {
+		store, err := sqlite.Open(filepath.Join(t.TempDir(), "bot.db"))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const projectColumns = `id, user_id, name, archived, video_file_id, video_mime_type, video_duration, video_file_size, script_style, generated_script, voice_id, narration_file_id, created_at, updated_at`
+
+// CreateProject creates an empty project. Without a name it is called
+// "Project N", numbered after the user's existing projects.
+func (s *SQLStore) CreateProject(userID int64, name string) (*Project, error) {
+	if name == "" {
+		var count int
+		if err := s.queryRow(`SELECT COUNT(*) FROM projects WHERE user_id = ?`, userID).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count projects of user %d: %w", userID, err)
+		}
+		name = fmt.Sprintf("Project %d", count+1)
+	}
+
+	now := time.Now()
+	var id int64
+	err := s.queryRow(`INSERT INTO projects (user_id, name, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id`, userID, name, now.Unix(), now.Unix()).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project for user %d: %w", userID, err)
+	}
+	return &Project{ID: id, UserID: userID, Name: name, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// Projects returns the user's active or archived projects, most recently
+// used first.
+func (s *SQLStore) Projects(userID int64, archived bool) ([]Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE user_id = ? AND archived = ? ORDER BY updated_at DESC, id DESC`
+	rows, err := s.query(query, userID, archived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query projects of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, *project)
+	}
+	return projects, rows.Err()
+}
+
+// Project returns one of the user's projects, or nil if they have no project
+// with that id.
+func (s *SQLStore) Project(userID, projectID int64) (*Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE id = ? AND user_id = ?`
+	project, err := scanProject(s.queryRow(query, projectID, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return project, err
+}
+
+// SetActiveProject makes the project the one the user's handlers work on.
+func (s *SQLStore) SetActiveProject(userID, projectID int64) error {
+	if _, err := s.exec(`UPDATE users SET active_project_id = ? WHERE user_id = ?`, projectID, userID); err != nil {
+		return fmt.Errorf("failed to switch project of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) RenameProject(userID, projectID int64, name string) error {
+	if _, err := s.exec(`UPDATE projects SET name = ? WHERE id = ? AND user_id = ?`, name, projectID, userID); err != nil {
+		return fmt.Errorf("failed to rename project %d: %w", projectID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) SetProjectArchived(userID, projectID int64, archived bool) error {
+	if _, err := s.exec(`UPDATE projects SET archived = ? WHERE id = ? AND user_id = ?`, archived, projectID, userID); err != nil {
+		return fmt.Errorf("failed to archive project %d: %w", projectID, err)
+	}
+	return nil
+}
+
+// DeleteProject deletes a project together with its script history.
+func (s *SQLStore) DeleteProject(userID, projectID int64) error {
+	return InTx(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(s.dialect.Rebind(`DELETE FROM scripts WHERE project_id = ? AND user_id = ?`), projectID, userID); err != nil {
+			return fmt.Errorf("failed to delete scripts of project %d: %w", projectID, err)
+		}
+		if _, err := tx.Exec(s.dialect.Rebind(`DELETE FROM projects WHERE id = ? AND user_id = ?`), projectID, userID); err != nil {
+			return fmt.Errorf("failed to delete project %d: %w", projectID, err)
+		}
+		return nil
+	})
+}
+
+func scanProject(row interface{ Scan(...any) error }) (*Project, error) {
+	var project Project
+	var videoFileID, videoMimeType, scriptStyle, generatedScript, voiceID, narrationFileID sql.NullString
+	var videoDuration, videoFileSize sql.NullInt64
+	var createdAt, updatedAt int64
+	err := row.Scan(&project.ID, &project.UserID, &project.Name, &project.Archived, &videoFileID, &videoMimeType, &videoDuration, &videoFileSize, &scriptStyle, &generatedScript, &voiceID, &narrationFileID, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan project: %w", err)
+	}
+	project.VideoFileID = videoFileID.String
+	project.VideoMimeType = videoMimeType.String
+	project.VideoDuration = int(videoDuration.Int64)
+	project.VideoFileSize = int(videoFileSize.Int64)
+	project.ScriptStyle = scriptStyle.String
+	project.GeneratedScript = generatedScript.String
+	project.VoiceID = voiceID.String
+	project.NarrationFileID = narrationFileID.String
+	project.CreatedAt = time.Unix(createdAt, 0)
+	project.UpdatedAt = time.Unix(updatedAt, 0)
+	return &project, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AddScriptVersion stores script as the next version of its project and sets
+// its Version. Where the database supports it, the project row is locked
+// meanwhile, so replicas adding to the same project take turns.
+func (s *SQLStore) AddScriptVersion(script *ScriptVersion) error {
+	if script.CreatedAt.IsZero() {
+		script.CreatedAt = time.Now()
+	}
+	return InTx(s.db, func(tx *sql.Tx) error {
+		if s.dialect.RowLocks {
+			if _, err := tx.Exec(s.dialect.Rebind(`SELECT id FROM projects WHERE id = ? FOR UPDATE`), script.ProjectID); err != nil {
+				return fmt.Errorf("failed to lock project %d: %w", script.ProjectID, err)
+			}
+		}
+		var latest sql.NullInt64
+		if err := tx.QueryRow(s.dialect.Rebind(`SELECT MAX(version) FROM scripts WHERE project_id = ?`), script.ProjectID).Scan(&latest); err != nil {
+			return fmt.Errorf("failed to read latest script version of project %d: %w", script.ProjectID, err)
+		}
+		script.Version = int(latest.Int64) + 1
+
+		query := `
+    INSERT INTO scripts (user_id, project_id, version, source, content, style, instructions, provider, model, restored_from, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+		_, err := tx.Exec(s.dialect.Rebind(query), script.UserID, script.ProjectID, script.Version, script.Source, script.Content, script.Style, script.Instructions, script.Provider, script.Model, script.RestoredFrom, script.CreatedAt.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to store script version of user %d: %w", script.UserID, err)
+		}
+		return nil
+	})
+}
+
+// ScriptVersions returns the latest script versions of one of the user's
+// projects, newest first.
+func (s *SQLStore) ScriptVersions(userID, projectID int64, limit int) ([]ScriptVersion, error) {
+	query := `
+    SELECT user_id, project_id, version, source, content, style, instructions, provider, model, restored_from, created_at
+    FROM scripts WHERE user_id = ? AND project_id = ? ORDER BY version DESC LIMIT ?;`
+	rows, err := s.query(query, userID, projectID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query script versions of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var versions []ScriptVersion
+	for rows.Next() {
+		version, err := scanScriptVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	return versions, rows.Err()
+}
+
+// ScriptVersion returns a script version of one of the user's projects, or nil
+// if there is no such version.
+func (s *SQLStore) ScriptVersion(userID, projectID int64, version int) (*ScriptVersion, error) {
+	query := `
+    SELECT user_id, project_id, version, source, content, style, instructions, provider, model, restored_from, created_at
+    FROM scripts WHERE user_id = ? AND project_id = ? AND version = ?;`
+	script, err := scanScriptVersion(s.queryRow(query, userID, projectID, version))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return script, err
+}
+
+func scanScriptVersion(row interface{ Scan(...any) error }) (*ScriptVersion, error) {
+	var script ScriptVersion
+	var style, instructions, provider, model sql.NullString
+	var restoredFrom sql.NullInt64
+	var createdAt int64
+	err := row.Scan(&script.UserID, &script.ProjectID, &script.Version, &script.Source, &script.Content, &style, &instructions, &provider, &model, &restoredFrom, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan script version: %w", err)
+	}
+	script.Style = style.String
+	script.Instructions = instructions.String
+	script.Provider = provider.String
+	script.Model = model.String
+	script.RestoredFrom = int(restoredFrom.Int64)
+	script.CreatedAt = time.Unix(createdAt, 0)
+	return &script, nil
+}
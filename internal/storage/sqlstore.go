@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"video-script-bot/internal/models"
+)
+
+// SQLStore implements the user, project, script and usage repositories on a
+// SQL database. The queries are written with ? placeholders and rebound for
+// the dialect, so the sqlite and postgres packages share them and only keep
+// what differs, such as the job queue.
+type SQLStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect}
+}
+
+func (s *SQLStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) GetUserData(userID int64) (*models.UserData, error) {
+	var userData models.UserData
+	query := `SELECT state, stability, clarity, speed, merge_audio, tts_provider, active_project_id FROM users WHERE user_id = ?`
+
+	var ttsProvider sql.NullString
+	var stability, clarity, speed sql.NullFloat64
+	var mergeAudio sql.NullBool
+	var activeProjectID sql.NullInt64
+
+	err := s.queryRow(query, userID).Scan(
+		&userData.State,
+		&stability,
+		&clarity,
+		&speed,
+		&mergeAudio,
+		&ttsProvider,
+		&activeProjectID,
+	)
+
+	if err == sql.ErrNoRows {
+		log.Printf("User %d not found in DB, creating new entry.", userID)
+		defaultUserData := models.NewDefaultUserData()
+		if err := s.SetUserData(userID, defaultUserData); err != nil {
+			return nil, err
+		}
+		if err := LoadActiveProject(s, userID, defaultUserData, 0); err != nil {
+			return nil, err
+		}
+		return defaultUserData, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query user data for user %d: %w", userID, err)
+	}
+
+	userData.MergeAudio = mergeAudio.Bool
+	userData.TTSProvider = ttsProvider.String
+	if stability.Valid {
+		userData.Stability = float32(stability.Float64)
+	} else {
+		userData.Stability = models.DefaultStability
+	}
+	if clarity.Valid {
+		userData.Clarity = float32(clarity.Float64)
+	} else {
+		userData.Clarity = models.DefaultClarity
+	}
+	if speed.Valid {
+		userData.Speed = float32(speed.Float64)
+	} else {
+		userData.Speed = models.DefaultSpeed
+	}
+
+	if err := LoadActiveProject(s, userID, &userData, activeProjectID.Int64); err != nil {
+		return nil, err
+	}
+	return &userData, nil
+}
+
+// SetUserData saves the user's state and settings, and the project fields to
+// the project in data.ProjectID. It does not change which project is active.
+func (s *SQLStore) SetUserData(userID int64, data *models.UserData) error {
+	err := InTx(s.db, func(tx *sql.Tx) error {
+		query := `
+    INSERT INTO users (user_id, state, stability, clarity, speed, merge_audio, tts_provider)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (user_id) DO UPDATE SET
+        state = excluded.state,
+        stability = excluded.stability,
+        clarity = excluded.clarity,
+        speed = excluded.speed,
+        merge_audio = excluded.merge_audio,
+        tts_provider = excluded.tts_provider;`
+		_, err := tx.Exec(s.dialect.Rebind(query),
+			userID,
+			string(data.State),
+			data.Stability,
+			data.Clarity,
+			data.Speed,
+			data.MergeAudio,
+			data.TTSProvider,
+		)
+		if err != nil || data.ProjectID == 0 {
+			return err
+		}
+
+		query = `
+    UPDATE projects SET video_file_id = ?, video_mime_type = ?, video_duration = ?, video_file_size = ?, script_style = ?, generated_script = ?, voice_id = ?, narration_file_id = ?, updated_at = ?
+    WHERE id = ? AND user_id = ?;`
+		_, err = tx.Exec(s.dialect.Rebind(query),
+			data.VideoFileID,
+			data.VideoMimeType,
+			data.VideoDuration,
+			data.VideoFileSize,
+			data.ScriptStyle,
+			data.GeneratedScript,
+			data.VoiceID,
+			data.NarrationFileID,
+			time.Now().Unix(),
+			data.ProjectID,
+			userID,
+		)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to set user data for user %d: %w", userID, err)
+	}
+	log.Printf("Data for user %d saved to DB. State: %s, Project: %d, Stability: %.2f, Clarity: %.2f, Speed: %.2f", userID, data.State, data.ProjectID, data.Stability, data.Clarity, data.Speed)
+	return nil
+}
+
+// UpdateUsers writes only the listed fields of each user and their project,
+// all in one transaction.
+func (s *SQLStore) UpdateUsers(updates []UserUpdate) error {
+	return InTx(s.db, func(tx *sql.Tx) error {
+		for _, update := range updates {
+			userColumns, projectColumns := update.Columns()
+			if len(userColumns) > 0 {
+				query, args := s.updateQuery("users", userColumns, Column{Name: "user_id", Value: update.UserID})
+				if _, err := tx.Exec(query, args...); err != nil {
+					return fmt.Errorf("failed to update user %d: %w", update.UserID, err)
+				}
+			}
+			if len(projectColumns) > 0 && update.Data.ProjectID != 0 {
+				projectColumns = append(projectColumns, Column{Name: "updated_at", Value: time.Now().Unix()})
+				query, args := s.updateQuery("projects", projectColumns,
+					Column{Name: "id", Value: update.Data.ProjectID},
+					Column{Name: "user_id", Value: update.UserID},
+				)
+				if _, err := tx.Exec(query, args...); err != nil {
+					return fmt.Errorf("failed to update project %d of user %d: %w", update.Data.ProjectID, update.UserID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// updateQuery builds an UPDATE of columns in the rows matching every key.
+func (s *SQLStore) updateQuery(table string, columns []Column, keys ...Column) (string, []any) {
+	var sets, conditions []string
+	var args []any
+	for _, column := range columns {
+		args = append(args, column.Value)
+		sets = append(sets, column.Name+" = ?")
+	}
+	for _, key := range keys {
+		args = append(args, key.Value)
+		conditions = append(conditions, key.Name+" = ?")
+	}
+	return s.dialect.Rebind("UPDATE " + table + " SET " + strings.Join(sets, ", ") + " WHERE " + strings.Join(conditions, " AND ")), args
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"video-script-bot/internal/apikeys"
+)
+
+// RecordKeyUsage stores one API call. It implements apikeys.UsageRecorder and
+// only logs failures, so a database problem never breaks a request.
+func (s *SQLStore) RecordKeyUsage(usage apikeys.Usage) {
+	_, err := s.exec(
+		`INSERT INTO api_key_usage (provider, key_fingerprint, success, error_class, error_message, latency_ms, units, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		usage.Provider,
+		usage.Fingerprint,
+		usage.Success,
+		usage.ErrorClass,
+		usage.Error,
+		usage.Latency.Milliseconds(),
+		usage.Units,
+		usage.At.Unix(),
+	)
+	if err != nil {
+		log.Printf("Warning: failed to record API key usage for %s key %s: %v", usage.Provider, usage.Fingerprint, err)
+	}
+}
+
+// KeyUsageSince returns per-key totals for calls made at or after since.
+func (s *SQLStore) KeyUsageSince(since time.Time) ([]KeyUsageStats, error) {
+	rows, err := s.query(`
+    SELECT provider, key_fingerprint, COUNT(*), SUM(CASE WHEN success THEN 0 ELSE 1 END), SUM(units), CAST(AVG(latency_ms) AS DOUBLE PRECISION)
+    FROM api_key_usage
+    WHERE created_at >= ?
+    GROUP BY provider, key_fingerprint`, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API key usage: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []KeyUsageStats
+	for rows.Next() {
+		var stat KeyUsageStats
+		var avgLatency float64
+		if err := rows.Scan(&stat.Provider, &stat.Fingerprint, &stat.Calls, &stat.Failures, &stat.Units, &avgLatency); err != nil {
+			return nil, fmt.Errorf("failed to read API key usage: %w", err)
+		}
+		stat.AvgLatency = time.Duration(avgLatency) * time.Millisecond
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// LastKeyErrors returns the most recent failure of every key that has failed.
+func (s *SQLStore) LastKeyErrors() ([]KeyError, error) {
+	rows, err := s.query(`
+    SELECT u.provider, u.key_fingerprint, u.error_class, u.error_message, u.created_at
+    FROM api_key_usage u
+    JOIN (
+        SELECT provider, key_fingerprint, MAX(id) AS id
+        FROM api_key_usage
+        WHERE NOT success
+        GROUP BY provider, key_fingerprint
+    ) latest ON latest.id = u.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API key errors: %w", err)
+	}
+	defer rows.Close()
+
+	var keyErrors []KeyError
+	for rows.Next() {
+		var keyError KeyError
+		var errorClass, message sql.NullString
+		var createdAt int64
+		if err := rows.Scan(&keyError.Provider, &keyError.Fingerprint, &errorClass, &message, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to read API key errors: %w", err)
+		}
+		keyError.ErrorClass = errorClass.String
+		keyError.Message = message.String
+		keyError.At = time.Unix(createdAt, 0)
+		keyErrors = append(keyErrors, keyError)
+	}
+	return keyErrors, rows.Err()
+}
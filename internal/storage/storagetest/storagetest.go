@@ -0,0 +1,530 @@
+// Package storagetest checks that a storage.Repository behaves the way the
+// bot expects. Every backend runs the same suite from its own tests.
+package storagetest
+
+import (
+	"testing"
+	"time"
+	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/jobs"
+	"video-script-bot/internal/models"
+	"video-script-bot/internal/storage"
+)
+
+// Opener returns an empty repository whose schema has not been migrated yet.
+// It closes the repository when the test ends.
+type Opener func(t *testing.T) storage.Repository
+
+// Run runs the repository suite, each test on a new repository from open.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo storage.Repository)
+	}{
+		{"Users", testUsers},
+		{"UpdateUsers", testUpdateUsers},
+		{"Projects", testProjects},
+		{"Scripts", testScripts},
+		{"Jobs", testJobs},
+		{"JobLeases", testJobLeases},
+		{"JobCancellation", testJobCancellation},
+		{"Usage", testUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := open(t)
+			if _, err := repo.MigrateUp(); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			tt.test(t, repo)
+		})
+	}
+	t.Run("Migrations", func(t *testing.T) {
+		testMigrations(t, open(t))
+	})
+}
+
+func testUsers(t *testing.T, repo storage.Repository) {
+	const userID = 101
+	data, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData of a new user: %v", err)
+	}
+	if data.State != models.StateIdle || data.Stability != models.DefaultStability || data.Speed != models.DefaultSpeed {
+		t.Fatalf("new user = %+v, want the defaults", data)
+	}
+	if data.ProjectID == 0 || data.ProjectName != "Project 1" {
+		t.Fatalf("new user has project %d %q, want a first project", data.ProjectID, data.ProjectName)
+	}
+
+	data.State = models.StateWaitingForStyle
+	data.Stability = 0.5
+	data.MergeAudio = true
+	data.TTSProvider = "gemini"
+	data.VideoFileID = "video"
+	data.VideoDuration = 42
+	data.GeneratedScript = "script"
+	data.VoiceID = "voice"
+	if err := repo.SetUserData(userID, data); err != nil {
+		t.Fatalf("SetUserData: %v", err)
+	}
+	saved, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if *saved != *data {
+		t.Fatalf("GetUserData = %+v, want %+v", saved, data)
+	}
+}
+
+func testUpdateUsers(t *testing.T, repo storage.Repository) {
+	const userID = 102
+	data, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	data.VoiceID = "saved voice"
+	if err := repo.SetUserData(userID, data); err != nil {
+		t.Fatalf("SetUserData: %v", err)
+	}
+
+	changed := *data
+	changed.State = models.StateWaitingForRevision
+	changed.GeneratedScript = "new script"
+	changed.VoiceID = "unsaved voice"
+	changed.Speed = 1.5
+	err = repo.UpdateUsers([]storage.UserUpdate{
+		{UserID: userID, Data: changed, Fields: storage.FieldState | storage.FieldGeneratedScript},
+		// Users that do not exist are left alone.
+		{UserID: 999, Data: changed, Fields: storage.FieldState},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUsers: %v", err)
+	}
+
+	saved, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	want := *data
+	want.State = changed.State
+	want.GeneratedScript = changed.GeneratedScript
+	if *saved != want {
+		t.Fatalf("after UpdateUsers GetUserData = %+v, want %+v", saved, want)
+	}
+}
+
+func testProjects(t *testing.T, repo storage.Repository) {
+	const userID = 103
+	data, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	first := data.ProjectID
+
+	second, err := repo.CreateProject(userID, "")
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	if second.Name != "Project 2" {
+		t.Errorf("second project is called %q, want Project 2", second.Name)
+	}
+	named, err := repo.CreateProject(userID, "Trailer")
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	if named.Name != "Trailer" {
+		t.Errorf("named project is called %q, want Trailer", named.Name)
+	}
+	if projects, err := repo.Projects(userID, false); err != nil || len(projects) != 3 {
+		t.Fatalf("Projects = %d projects, %v; want 3", len(projects), err)
+	}
+	if project, err := repo.Project(userID+1, second.ID); err != nil || project != nil {
+		t.Fatalf("Project of another user = %+v, %v; want nil", project, err)
+	}
+
+	if err := repo.SetActiveProject(userID, second.ID); err != nil {
+		t.Fatalf("SetActiveProject: %v", err)
+	}
+	if err := repo.RenameProject(userID, second.ID, "Renamed"); err != nil {
+		t.Fatalf("RenameProject: %v", err)
+	}
+	data, err = repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if data.ProjectID != second.ID || data.ProjectName != "Renamed" {
+		t.Fatalf("active project = %d %q, want %d Renamed", data.ProjectID, data.ProjectName, second.ID)
+	}
+	data.GeneratedScript = "second script"
+	if err := repo.SetUserData(userID, data); err != nil {
+		t.Fatalf("SetUserData: %v", err)
+	}
+	if project, err := repo.Project(userID, first); err != nil || project.GeneratedScript != "" {
+		t.Fatalf("SetUserData changed an inactive project: %+v, %v", project, err)
+	}
+
+	// Archiving the active project switches the user to another one.
+	if err := repo.SetProjectArchived(userID, second.ID, true); err != nil {
+		t.Fatalf("SetProjectArchived: %v", err)
+	}
+	archived, err := repo.Projects(userID, true)
+	if err != nil || len(archived) != 1 || archived[0].ID != second.ID || archived[0].GeneratedScript != "second script" {
+		t.Fatalf("archived projects = %+v, %v; want the second project", archived, err)
+	}
+	data, err = repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if data.ProjectID == second.ID || data.ProjectID == 0 {
+		t.Fatalf("active project is %d after archiving it", data.ProjectID)
+	}
+
+	if err := repo.AddScriptVersion(&storage.ScriptVersion{UserID: userID, ProjectID: second.ID, Source: storage.ScriptGenerated, Content: "script"}); err != nil {
+		t.Fatalf("AddScriptVersion: %v", err)
+	}
+	if err := repo.DeleteProject(userID, second.ID); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if project, err := repo.Project(userID, second.ID); err != nil || project != nil {
+		t.Fatalf("Project after DeleteProject = %+v, %v; want nil", project, err)
+	}
+	if versions, err := repo.ScriptVersions(userID, second.ID, 10); err != nil || len(versions) != 0 {
+		t.Fatalf("ScriptVersions after DeleteProject = %d versions, %v; want none", len(versions), err)
+	}
+}
+
+func testScripts(t *testing.T, repo storage.Repository) {
+	const userID = 104
+	data, err := repo.GetUserData(userID)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	other, err := repo.CreateProject(userID, "")
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+
+	scripts := []*storage.ScriptVersion{
+		{UserID: userID, ProjectID: data.ProjectID, Source: storage.ScriptGenerated, Content: "first", Style: "funny", Provider: "gemini", Model: "flash"},
+		{UserID: userID, ProjectID: data.ProjectID, Source: storage.ScriptRevised, Content: "second", Instructions: "shorter"},
+		{UserID: userID, ProjectID: other.ID, Source: storage.ScriptGenerated, Content: "other"},
+		{UserID: userID, ProjectID: data.ProjectID, Source: storage.ScriptRestored, Content: "first", RestoredFrom: 1},
+	}
+	for _, script := range scripts {
+		if err := repo.AddScriptVersion(script); err != nil {
+			t.Fatalf("AddScriptVersion: %v", err)
+		}
+	}
+	// Versions are numbered per project.
+	for i, want := range []int{1, 2, 1, 3} {
+		if scripts[i].Version != want {
+			t.Errorf("script %d got version %d, want %d", i, scripts[i].Version, want)
+		}
+	}
+
+	versions, err := repo.ScriptVersions(userID, data.ProjectID, 2)
+	if err != nil {
+		t.Fatalf("ScriptVersions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != 3 || versions[1].Version != 2 {
+		t.Fatalf("ScriptVersions = %+v, want versions 3 and 2", versions)
+	}
+	if versions[0].RestoredFrom != 1 || versions[1].Instructions != "shorter" {
+		t.Errorf("ScriptVersions lost fields: %+v", versions)
+	}
+
+	script, err := repo.ScriptVersion(userID, data.ProjectID, 1)
+	if err != nil || script == nil {
+		t.Fatalf("ScriptVersion = %v, %v", script, err)
+	}
+	if script.Content != "first" || script.Style != "funny" || script.Provider != "gemini" || script.Model != "flash" {
+		t.Errorf("ScriptVersion = %+v", script)
+	}
+	if script, err := repo.ScriptVersion(userID, data.ProjectID, 4); err != nil || script != nil {
+		t.Errorf("missing ScriptVersion = %+v, %v; want nil", script, err)
+	}
+	if script, err := repo.ScriptVersion(userID+1, data.ProjectID, 1); err != nil || script != nil {
+		t.Errorf("ScriptVersion of another user = %+v, %v; want nil", script, err)
+	}
+}
+
+func testJobs(t *testing.T, repo storage.Repository) {
+	if job, err := repo.ClaimJob(); err != nil || job != nil {
+		t.Fatalf("ClaimJob on an empty queue = %+v, %v; want nil", job, err)
+	}
+
+	first := &jobs.Job{Type: "generate", UserID: 105, ChatID: 205, Payload: []byte(`{"project_id":1}`)}
+	second := &jobs.Job{Type: "revise", UserID: 106, ChatID: 206}
+	for _, job := range []*jobs.Job{first, second} {
+		if err := repo.CreateJob(job); err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+		if job.ID == 0 || job.Status != jobs.StatusPending {
+			t.Fatalf("CreateJob left job %+v", job)
+		}
+	}
+
+	claimed, err := repo.ClaimJob()
+	if err != nil || claimed == nil {
+		t.Fatalf("ClaimJob = %v, %v", claimed, err)
+	}
+	if claimed.ID != first.ID || claimed.Type != "generate" || claimed.UserID != 105 || claimed.ChatID != 205 ||
+		string(claimed.Payload) != string(first.Payload) || claimed.Status != jobs.StatusRunning || claimed.Attempts != 1 {
+		t.Fatalf("ClaimJob = %+v, want the oldest job on its first attempt", claimed)
+	}
+
+	if count, err := repo.CancelUserJobs(106); err != nil || count != 1 {
+		t.Fatalf("CancelUserJobs = %d, %v; want 1", count, err)
+	}
+	if job, err := repo.ClaimJob(); err != nil || job != nil {
+		t.Fatalf("ClaimJob claimed cancelled job %+v, %v", job, err)
+	}
+
+	// A restart puts the running job back, and claiming it counts another
+	// attempt.
+	if count, err := repo.RequeueRunningJobs(); err != nil || count != 1 {
+		t.Fatalf("RequeueRunningJobs = %d, %v; want 1", count, err)
+	}
+	claimed, err = repo.ClaimJob()
+	if err != nil || claimed == nil || claimed.ID != first.ID || claimed.Attempts != 2 {
+		t.Fatalf("ClaimJob after RequeueRunningJobs = %+v, %v; want attempt 2 of job %d", claimed, err, first.ID)
+	}
+
+	// FinishJob with StatusPending retries the job; any other status ends it.
+	if err := repo.FinishJob(claimed.ID, jobs.StatusPending, "", "interrupted"); err != nil {
+		t.Fatalf("FinishJob: %v", err)
+	}
+	if claimed, err = repo.ClaimJob(); err != nil || claimed == nil || claimed.Attempts != 3 {
+		t.Fatalf("ClaimJob of a retried job = %+v, %v", claimed, err)
+	}
+	if err := repo.FinishJob(claimed.ID, jobs.StatusDone, "done", ""); err != nil {
+		t.Fatalf("FinishJob: %v", err)
+	}
+	if count, err := repo.RequeueRunningJobs(); err != nil || count != 0 {
+		t.Fatalf("RequeueRunningJobs requeued %d finished jobs, %v", count, err)
+	}
+	if job, err := repo.ClaimJob(); err != nil || job != nil {
+		t.Fatalf("ClaimJob claimed finished job %+v, %v", job, err)
+	}
+}
+
+func testJobLeases(t *testing.T, repo storage.Repository) {
+	job := &jobs.Job{Type: "narrate", UserID: 107, ChatID: 207}
+	if err := repo.CreateJob(job); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	if _, err := repo.ClaimJob(); err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+
+	if cancelled, err := repo.RenewJobs([]int64{job.ID}); err != nil || len(cancelled) != 0 {
+		t.Fatalf("RenewJobs = %v, %v", cancelled, err)
+	}
+	if count, err := repo.RequeueStaleJobs(time.Now().Add(-time.Minute)); err != nil || count != 0 {
+		t.Fatalf("RequeueStaleJobs requeued %d renewed jobs, %v", count, err)
+	}
+	if count, err := repo.RequeueStaleJobs(time.Now().Add(time.Minute)); err != nil || count != 1 {
+		t.Fatalf("RequeueStaleJobs = %d, %v; want 1", count, err)
+	}
+	claimed, err := repo.ClaimJob()
+	if err != nil || claimed == nil || claimed.ID != job.ID || claimed.Attempts != 2 {
+		t.Fatalf("ClaimJob of a stale job = %+v, %v", claimed, err)
+	}
+
+	// Renewing never brings a finished job back.
+	if err := repo.FinishJob(job.ID, jobs.StatusFailed, "", "failed"); err != nil {
+		t.Fatalf("FinishJob: %v", err)
+	}
+	if cancelled, err := repo.RenewJobs([]int64{job.ID}); err != nil || len(cancelled) != 0 {
+		t.Fatalf("RenewJobs = %v, %v", cancelled, err)
+	}
+	if count, err := repo.RequeueStaleJobs(time.Now().Add(time.Minute)); err != nil || count != 0 {
+		t.Fatalf("RequeueStaleJobs requeued %d finished jobs, %v", count, err)
+	}
+}
+
+func testJobCancellation(t *testing.T, repo storage.Repository) {
+	create := func(userID int64) *jobs.Job {
+		t.Helper()
+		job := &jobs.Job{Type: "generate", UserID: userID, ChatID: userID}
+		if err := repo.CreateJob(job); err != nil {
+			t.Fatalf("CreateJob: %v", err)
+		}
+		return job
+	}
+	claim := func(want *jobs.Job) {
+		t.Helper()
+		claimed, err := repo.ClaimJob()
+		if err != nil {
+			t.Fatalf("ClaimJob: %v", err)
+		}
+		switch {
+		case want == nil && claimed != nil:
+			t.Fatalf("ClaimJob = job %d of user %d, want nothing", claimed.ID, claimed.UserID)
+		case want != nil && (claimed == nil || claimed.ID != want.ID):
+			t.Fatalf("ClaimJob = %+v, want job %d", claimed, want.ID)
+		}
+	}
+
+	// A user's second job waits while their first one runs, but other users'
+	// jobs do not.
+	first := create(110)
+	claim(first)
+	create(110)
+	other := create(111)
+	claim(other)
+	claim(nil)
+
+	// Cancelling cancels the pending job and flags the running one, which
+	// the process running it finds when renewing.
+	if cancelled, err := repo.RenewJobs([]int64{first.ID, other.ID}); err != nil || len(cancelled) != 0 {
+		t.Fatalf("RenewJobs before cancelling = %v, %v; want none cancelled", cancelled, err)
+	}
+	if count, err := repo.CancelUserJobs(110); err != nil || count != 1 {
+		t.Fatalf("CancelUserJobs = %d, %v; want 1", count, err)
+	}
+	cancelled, err := repo.RenewJobs([]int64{first.ID, other.ID})
+	if err != nil || len(cancelled) != 1 || cancelled[0] != first.ID {
+		t.Fatalf("RenewJobs after cancelling = %v, %v; want job %d", cancelled, err, first.ID)
+	}
+
+	// A cancelled job put back in the queue ends instead, and the user's next
+	// job can run.
+	if err := repo.FinishJob(first.ID, jobs.StatusPending, "", ""); err != nil {
+		t.Fatalf("FinishJob: %v", err)
+	}
+	claim(nil)
+	third := create(110)
+	claim(third)
+	if cancelled, err := repo.RenewJobs([]int64{third.ID}); err != nil || len(cancelled) != 0 {
+		t.Fatalf("RenewJobs of a new job = %v, %v; want none cancelled", cancelled, err)
+	}
+
+	// Stale and interrupted jobs that were asked to stop are cancelled
+	// rather than requeued.
+	if count, err := repo.CancelUserJobs(111); err != nil || count != 0 {
+		t.Fatalf("CancelUserJobs of a running job = %d, %v; want 0", count, err)
+	}
+	if count, err := repo.RequeueStaleJobs(time.Now().Add(time.Minute)); err != nil || count != 2 {
+		t.Fatalf("RequeueStaleJobs = %d, %v; want 2", count, err)
+	}
+	claim(third)
+	claim(nil)
+	if count, err := repo.CancelUserJobs(110); err != nil || count != 0 {
+		t.Fatalf("CancelUserJobs of a running job = %d, %v; want 0", count, err)
+	}
+	if count, err := repo.RequeueRunningJobs(); err != nil || count != 1 {
+		t.Fatalf("RequeueRunningJobs = %d, %v; want 1", count, err)
+	}
+	claim(nil)
+}
+
+func testUsage(t *testing.T, repo storage.Repository) {
+	now := time.Now()
+	usages := []apikeys.Usage{
+		{Provider: "gemini", Fingerprint: "aaa", Success: true, Latency: 100 * time.Millisecond, Units: 10, At: now.Add(-48 * time.Hour)},
+		{Provider: "gemini", Fingerprint: "aaa", Success: true, Latency: 100 * time.Millisecond, Units: 20, At: now.Add(-time.Minute)},
+		{Provider: "gemini", Fingerprint: "aaa", ErrorClass: "quota", Error: "quota exceeded", Latency: 300 * time.Millisecond, At: now.Add(-30 * time.Second)},
+		{Provider: "elevenlabs", Fingerprint: "bbb", ErrorClass: "auth", Error: "old error", At: now.Add(-72 * time.Hour)},
+		{Provider: "elevenlabs", Fingerprint: "bbb", ErrorClass: "auth", Error: "invalid key", At: now.Add(-time.Hour)},
+	}
+	for _, usage := range usages {
+		repo.RecordKeyUsage(usage)
+	}
+
+	stats, err := repo.KeyUsageSince(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("KeyUsageSince: %v", err)
+	}
+	byKey := make(map[string]storage.KeyUsageStats)
+	for _, stat := range stats {
+		byKey[stat.Provider+"/"+stat.Fingerprint] = stat
+	}
+	if len(byKey) != 2 {
+		t.Fatalf("KeyUsageSince = %+v, want two keys", stats)
+	}
+	gemini := byKey["gemini/aaa"]
+	if gemini.Calls != 2 || gemini.Failures != 1 || gemini.Units != 20 {
+		t.Errorf("gemini stats = %+v, want 2 calls, 1 failure and 20 units", gemini)
+	}
+	if gemini.AvgLatency < 150*time.Millisecond || gemini.AvgLatency > 250*time.Millisecond {
+		t.Errorf("gemini average latency = %v, want 200ms", gemini.AvgLatency)
+	}
+	if elevenLabs := byKey["elevenlabs/bbb"]; elevenLabs.Calls != 1 || elevenLabs.Failures != 1 {
+		t.Errorf("elevenlabs stats = %+v, want 1 failed call", elevenLabs)
+	}
+
+	keyErrors, err := repo.LastKeyErrors()
+	if err != nil {
+		t.Fatalf("LastKeyErrors: %v", err)
+	}
+	lastErrors := make(map[string]storage.KeyError)
+	for _, keyError := range keyErrors {
+		lastErrors[keyError.Provider+"/"+keyError.Fingerprint] = keyError
+	}
+	if len(lastErrors) != 2 {
+		t.Fatalf("LastKeyErrors = %+v, want one error per key", keyErrors)
+	}
+	if last := lastErrors["elevenlabs/bbb"]; last.ErrorClass != "auth" || last.Message != "invalid key" {
+		t.Errorf("last elevenlabs error = %+v, want the invalid key error", last)
+	}
+	if last := lastErrors["gemini/aaa"]; last.ErrorClass != "quota" || last.Message != "quota exceeded" {
+		t.Errorf("last gemini error = %+v, want the quota error", last)
+	}
+}
+
+// testMigrations applies every migration, rolls them all back and applies
+// them again.
+func testMigrations(t *testing.T, repo storage.Repository) {
+	latest := repo.LatestSchemaVersion()
+	if version, err := repo.SchemaVersion(); err != nil || version != 0 {
+		t.Fatalf("SchemaVersion of an empty database = %d, %v; want 0", version, err)
+	}
+
+	applied, err := repo.MigrateUp()
+	if err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if len(applied) != latest {
+		t.Fatalf("MigrateUp applied %d migrations, want %d", len(applied), latest)
+	}
+	if applied, err := repo.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Fatalf("second MigrateUp applied %d migrations, %v; want none", len(applied), err)
+	}
+	if _, err := repo.GetUserData(108); err != nil {
+		t.Fatalf("GetUserData after MigrateUp: %v", err)
+	}
+
+	for version := latest; version > 0; version-- {
+		migration, err := repo.MigrateDown()
+		if err != nil {
+			t.Fatalf("MigrateDown from %d: %v", version, err)
+		}
+		if migration == nil || migration.Version != version {
+			t.Fatalf("MigrateDown rolled back %+v, want version %d", migration, version)
+		}
+	}
+	if migration, err := repo.MigrateDown(); err != nil || migration != nil {
+		t.Fatalf("MigrateDown with nothing applied = %+v, %v; want nil", migration, err)
+	}
+	statuses, err := repo.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("migration %d is still applied after rolling back", status.Version)
+		}
+	}
+
+	if applied, err := repo.MigrateUp(); err != nil || len(applied) != latest {
+		t.Fatalf("MigrateUp after rolling back applied %d migrations, %v; want %d", len(applied), err, latest)
+	}
+	if version, err := repo.SchemaVersion(); err != nil || version != latest {
+		t.Fatalf("SchemaVersion = %d, %v; want %d", version, err, latest)
+	}
+	if _, err := repo.GetUserData(108); err != nil {
+		t.Fatalf("GetUserData after migrating again: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import "video-script-bot/internal/models"
+
+// UserField is a saved field of models.UserData. Fields combine as a set.
+type UserField uint
+
+const (
+	FieldState UserField = 1 << iota
+	FieldStability
+	FieldClarity
+	FieldSpeed
+	FieldMergeAudio
+	FieldTTSProvider
+	FieldVideoFileID
+	FieldVideoMimeType
+	FieldVideoDuration
+	FieldVideoFileSize
+	FieldScriptStyle
+	FieldGeneratedScript
+	FieldVoiceID
+	FieldNarrationFileID
+)
+
+// Column is a database column with the value to write to it.
+type Column struct {
+	Name  string
+	Value any
+}
+
+// userColumns maps every UserField to its column. The project columns belong
+// to the user's active project.
+var userColumns = []struct {
+	field   UserField
+	project bool
+	name    string
+	value   func(data *models.UserData) any
+}{
+	{FieldState, false, "state", func(data *models.UserData) any { return string(data.State) }},
+	{FieldStability, false, "stability", func(data *models.UserData) any { return data.Stability }},
+	{FieldClarity, false, "clarity", func(data *models.UserData) any { return data.Clarity }},
+	{FieldSpeed, false, "speed", func(data *models.UserData) any { return data.Speed }},
+	{FieldMergeAudio, false, "merge_audio", func(data *models.UserData) any { return data.MergeAudio }},
+	{FieldTTSProvider, false, "tts_provider", func(data *models.UserData) any { return data.TTSProvider }},
+	{FieldVideoFileID, true, "video_file_id", func(data *models.UserData) any { return data.VideoFileID }},
+	{FieldVideoMimeType, true, "video_mime_type", func(data *models.UserData) any { return data.VideoMimeType }},
+	{FieldVideoDuration, true, "video_duration", func(data *models.UserData) any { return data.VideoDuration }},
+	{FieldVideoFileSize, true, "video_file_size", func(data *models.UserData) any { return data.VideoFileSize }},
+	{FieldScriptStyle, true, "script_style", func(data *models.UserData) any { return data.ScriptStyle }},
+	{FieldGeneratedScript, true, "generated_script", func(data *models.UserData) any { return data.GeneratedScript }},
+	{FieldVoiceID, true, "voice_id", func(data *models.UserData) any { return data.VoiceID }},
+	{FieldNarrationFileID, true, "narration_file_id", func(data *models.UserData) any { return data.NarrationFileID }},
+}
+
+// ChangedUserFields returns the saved fields that differ between old and new.
+func ChangedUserFields(old, new *models.UserData) UserField {
+	var changed UserField
+	for _, column := range userColumns {
+		if column.value(old) != column.value(new) {
+			changed |= column.field
+		}
+	}
+	return changed
+}
+
+// UserUpdate saves some fields of a user's data. The project fields go to the
+// project in Data.ProjectID.
+type UserUpdate struct {
+	UserID int64
+	Data   models.UserData
+	Fields UserField
+}
+
+// Columns returns the users and projects columns to write for the update.
+func (u UserUpdate) Columns() (user, project []Column) {
+	for _, column := range userColumns {
+		if u.Fields&column.field == 0 {
+			continue
+		}
+		if column.project {
+			project = append(project, Column{Name: column.name, Value: column.value(&u.Data)})
+		} else {
+			user = append(user, Column{Name: column.name, Value: column.value(&u.Data)})
+		}
+	}
+	return user, project
+}
@@ -0,0 +1,175 @@
+package subtitle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"video-script-bot/internal/script"
+)
+
+// DefaultMaxCharsPerCue is two lines of MaxCharsPerLine, the usual broadcast
+// limit.
+const DefaultMaxCharsPerCue = 84
+
+// MaxCharsPerLine is the longest line of cue text. Longer cues wrap onto
+// further lines.
+const MaxCharsPerLine = 42
+
+// Cue is a single subtitle entry. Text holds the cue's lines separated by
+// newlines, leaving room on the first line for the speaker label.
+type Cue struct {
+	Start   time.Duration
+	End     time.Duration
+	Text    string
+	Speaker string
+}
+
+// BuildCues turns script segments into subtitle cues of at most maxChars
+// characters, counting the "Speaker: " label. Text is wrapped at word
+// boundaries into lines of at most MaxCharsPerLine, and a segment that needs
+// more lines than fit in one cue is split across several, its time range
+// shared between them in proportion to their length. A cue that overlaps the
+// next one is shortened so it ends when the next cue starts.
+func BuildCues(s *script.Script, maxChars int) []Cue {
+	if maxChars <= 0 {
+		maxChars = DefaultMaxCharsPerCue
+	}
+
+	var cues []Cue
+	for _, segment := range s.Segments {
+		cues = append(cues, splitSegment(segment, maxChars)...)
+	}
+
+	for i := 0; i+1 < len(cues); i++ {
+		if cues[i].End > cues[i+1].Start && cues[i+1].Start > cues[i].Start {
+			cues[i].End = cues[i+1].Start
+		}
+	}
+	return cues
+}
+
+func splitSegment(segment script.Segment, maxChars int) []Cue {
+	lineWidth := min(maxChars, MaxCharsPerLine)
+	labelWidth := 0
+	if segment.Speaker != "" {
+		labelWidth = utf8.RuneCountInString(speakerLabel(segment.Speaker))
+	}
+	chunks := wrapText(segment.Text, lineWidth, maxChars/lineWidth, labelWidth)
+	if len(chunks) == 1 {
+		return []Cue{{Start: segment.Start, End: segment.End, Text: chunks[0], Speaker: segment.Speaker}}
+	}
+
+	totalChars := 0
+	for _, chunk := range chunks {
+		totalChars += utf8.RuneCountInString(chunk)
+	}
+
+	length := segment.End - segment.Start
+	cues := make([]Cue, 0, len(chunks))
+	start := segment.Start
+	consumed := 0
+	for i, chunk := range chunks {
+		consumed += utf8.RuneCountInString(chunk)
+		end := segment.Start + time.Duration(int64(length)*int64(consumed)/int64(totalChars))
+		if i == len(chunks)-1 {
+			end = segment.End
+		}
+		cues = append(cues, Cue{Start: start, End: end, Text: chunk, Speaker: segment.Speaker})
+		start = end
+	}
+	return cues
+}
+
+// wrapText breaks text into cues of up to linesPerCue lines of at most
+// lineWidth characters, joined by newlines. The first line of every cue is
+// shortened by labelWidth to leave room for the speaker label. Words are only
+// split when a single word does not fit on a line.
+func wrapText(text string, lineWidth, linesPerCue, labelWidth int) []string {
+	var cues, lines []string
+	var line []rune
+	width := func() int {
+		if len(lines) == 0 {
+			return max(lineWidth-labelWidth, 1)
+		}
+		return lineWidth
+	}
+	endLine := func() {
+		lines = append(lines, string(line))
+		line = line[:0]
+		if len(lines) == linesPerCue {
+			cues = append(cues, strings.Join(lines, "\n"))
+			lines = nil
+		}
+	}
+
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		if len(runes) > width() && len(line) > 0 {
+			endLine()
+		}
+		for len(runes) > width() {
+			line = append(line, runes[:width()]...)
+			runes = runes[len(line):]
+			endLine()
+		}
+		if len(line) > 0 && len(line)+1+len(runes) > width() {
+			endLine()
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, runes...)
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	if len(lines) > 0 || len(cues) == 0 {
+		cues = append(cues, strings.Join(lines, "\n"))
+	}
+	return cues
+}
+
+func speakerLabel(speaker string) string {
+	return speaker + ": "
+}
+
+// SRT renders the cues in SubRip format.
+func SRT(cues []Cue) []byte {
+	var builder strings.Builder
+	for i, cue := range cues {
+		text := cue.Text
+		if cue.Speaker != "" {
+			text = speakerLabel(cue.Speaker) + text
+		}
+		fmt.Fprintf(&builder, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), text)
+	}
+	return []byte(builder.String())
+}
+
+// VTT renders the cues in WebVTT format. Speakers become voice spans.
+func VTT(cues []Cue) []byte {
+	var builder strings.Builder
+	builder.WriteString("WEBVTT\n\n")
+	for i, cue := range cues {
+		text := escapeVTT(cue.Text)
+		if cue.Speaker != "" {
+			text = fmt.Sprintf("<v %s>%s", escapeVTT(cue.Speaker), text)
+		}
+		fmt.Fprintf(&builder, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), text)
+	}
+	return []byte(builder.String())
+}
+
+func escapeVTT(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+func formatTimestamp(d time.Duration, millisSeparator string) string {
+	totalMillis := int64(d / time.Millisecond)
+	hours := totalMillis / 3_600_000
+	minutes := (totalMillis % 3_600_000) / 60_000
+	seconds := (totalMillis % 60_000) / 1000
+	millis := totalMillis % 1000
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", hours, minutes, seconds, millisSeparator, millis)
+}
@@ -0,0 +1,144 @@
+package subtitle
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+	"video-script-bot/internal/script"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		maxChars int
+	}{
+		{
+			name: "basic",
+			script: "00:00:00-00:00:03: [Host] Welcome to the <show> & enjoy\n" +
+				"00:00:02-00:00:05: The cue before this one is cut short",
+		},
+		{
+			name: "long_lines",
+			script: "00:00:00-00:00:10: This narration is far too long to fit on a single subtitle cue, so it is split at word boundaries\n" +
+				"00:00:10-00:00:12: Supercalifragilisticexpialidocious",
+			maxChars: 20,
+		},
+		{
+			name:     "multibyte",
+			script:   "00:00:00-00:00:06: [Narator] Selamat datang di dapur kami 🍳 — こんにちは世界、今日は料理をします",
+			maxChars: 16,
+		},
+		{
+			name: "two_lines",
+			script: "00:00:00-00:00:12: [Host] Welcome back to the kitchen, where today we are making a slow-cooked beef rendang with coconut rice and a quick cucumber pickle on the side\n" +
+				"00:00:12-00:00:14: Short line without a speaker",
+		},
+		{
+			name: "hours",
+			script: "00:59:58-01:00:03: Crossing the hour\n" +
+				"10:00:00-10:00:01: Ten hours in",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := script.Parse(tt.script)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			cues := BuildCues(parsed, tt.maxChars)
+			checkGolden(t, tt.name+".srt", SRT(cues))
+			checkGolden(t, tt.name+".vtt", VTT(cues))
+		})
+	}
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestBuildCuesSplitsLongSegments(t *testing.T) {
+	parsed, err := script.Parse("00:00:00-00:00:08: aaaa bbbb cccc dddd eeee ffff gggg hhhh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cues := BuildCues(parsed, 9)
+	if len(cues) != 4 {
+		t.Fatalf("got %d cues, want 4: %+v", len(cues), cues)
+	}
+	for i, cue := range cues {
+		if n := utf8.RuneCountInString(cue.Text); n > 9 {
+			t.Errorf("cue %d has %d characters, more than 9", i, n)
+		}
+		if want := time.Duration(i*2) * time.Second; cue.Start != want {
+			t.Errorf("cue %d starts at %v, want %v", i, cue.Start, want)
+		}
+	}
+	if last := cues[len(cues)-1]; last.End != 8*time.Second {
+		t.Errorf("last cue ends at %v, want 8s", last.End)
+	}
+}
+
+// Cues stay within maxChars including the speaker label, and no line is
+// longer than MaxCharsPerLine.
+func TestBuildCuesLimits(t *testing.T) {
+	parsed, err := script.Parse("00:00:00-00:00:30: [Presenter] " + strings.Repeat("lorem ipsum dolor sit amet consectetur ", 8) + "Pneumonoultramicroscopicsilicovolcanoconiosis-and-more")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, maxChars := range []int{20, 42, 60, DefaultMaxCharsPerCue, 130} {
+		cues := BuildCues(parsed, maxChars)
+		for i, cue := range cues {
+			text := cue.Text
+			if cue.Speaker != "" {
+				text = speakerLabel(cue.Speaker) + text
+			}
+			lines := strings.Split(text, "\n")
+			if len(lines) > max(maxChars/MaxCharsPerLine, 1) {
+				t.Errorf("maxChars %d: cue %d has %d lines: %q", maxChars, i, len(lines), lines)
+			}
+			total := 0
+			for _, line := range lines {
+				n := utf8.RuneCountInString(line)
+				if n > MaxCharsPerLine || n == 0 {
+					t.Errorf("maxChars %d: cue %d has a line of %d characters: %q", maxChars, i, n, line)
+				}
+				total += n
+			}
+			if total > maxChars {
+				t.Errorf("maxChars %d: cue %d has %d characters: %q", maxChars, i, total, lines)
+			}
+		}
+	}
+}
+
+func TestVTTHeader(t *testing.T) {
+	if got := VTT(nil); string(got) != "WEBVTT\n\n" {
+		t.Errorf("VTT(nil) = %q, want just the header", got)
+	}
+	if got := SRT(nil); len(got) != 0 {
+		t.Errorf("SRT(nil) = %q, want nothing", got)
+	}
+}
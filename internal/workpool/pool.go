@@ -0,0 +1,149 @@
+// Package workpool limits how much work of one kind runs at once. Callers over
+// the limit wait in a bounded first-in, first-out queue and are told their
+// place in it; once the queue is full, new work is turned away.
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when every worker is busy and the queue is full.
+var ErrQueueFull = errors.New("work queue is full")
+
+// Pool hands out up to size concurrent slots and queues up to maxQueue
+// callers waiting for one.
+type Pool struct {
+	name     string
+	size     int
+	maxQueue int
+
+	mu      sync.Mutex
+	active  int
+	waiters []*waiter
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+// New creates a pool. A size below 1 is treated as 1 and a negative maxQueue
+// as 0, which turns away everything that cannot start at once.
+func New(name string, size, maxQueue int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &Pool{name: name, size: size, maxQueue: maxQueue}
+}
+
+// Name returns the name given to New.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Acquire waits for a free slot and returns the function that gives it back.
+// If the caller has to wait, onQueued (when not nil) is called once with its
+// 1-based position in the queue. It returns ErrQueueFull when the queue is
+// full, or ctx's error if ctx ends while waiting.
+func (p *Pool) Acquire(ctx context.Context, onQueued func(position int)) (func(), error) {
+	w, position, err := p.enqueue()
+	if err != nil {
+		return nil, err
+	}
+	return p.wait(ctx, w, position, onQueued)
+}
+
+// Go runs fn on a slot in a new goroutine. It does not wait for a slot, but
+// fails with ErrQueueFull at once when the queue is full.
+func (p *Pool) Go(ctx context.Context, onQueued func(position int), fn func()) error {
+	w, position, err := p.enqueue()
+	if err != nil {
+		return err
+	}
+	go func() {
+		release, err := p.wait(ctx, w, position, onQueued)
+		if err != nil {
+			return
+		}
+		defer release()
+		fn()
+	}()
+	return nil
+}
+
+// enqueue takes a free slot, returning a nil waiter, or joins the queue.
+func (p *Pool) enqueue() (*waiter, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active < p.size && len(p.waiters) == 0 {
+		p.active++
+		return nil, 0, nil
+	}
+	if len(p.waiters) >= p.maxQueue {
+		return nil, 0, ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	p.waiters = append(p.waiters, w)
+	return w, len(p.waiters), nil
+}
+
+func (p *Pool) wait(ctx context.Context, w *waiter, position int, onQueued func(position int)) (func(), error) {
+	if w == nil {
+		return p.releaseFunc(), nil
+	}
+	if onQueued != nil {
+		onQueued(position)
+	}
+
+	select {
+	case <-w.ready:
+		return p.releaseFunc(), nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, queued := range p.waiters {
+			if queued == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// The slot was handed over just as ctx ended; pass it on.
+		p.releaseLocked()
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns how many slots are in use and how many callers are waiting.
+func (p *Pool) Stats() (active, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active, len(p.waiters)
+}
+
+func (p *Pool) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.releaseLocked()
+		})
+	}
+}
+
+// releaseLocked hands the slot to the first waiter, or frees it. The caller
+// must hold the mutex.
+func (p *Pool) releaseLocked() {
+	if len(p.waiters) > 0 {
+		next := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		close(next.ready)
+		return
+	}
+	p.active--
+}
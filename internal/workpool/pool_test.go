@@ -0,0 +1,224 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// eventually polls until ok returns true, failing the test with message after
+// a few seconds.
+func eventually(t *testing.T, ok func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitFor waits until the pool has the given number of active slots and
+// waiters.
+func waitFor(t *testing.T, p *Pool, active, waiting int) {
+	t.Helper()
+	eventually(t, func() bool {
+		a, w := p.Stats()
+		return a == active && w == waiting
+	}, "pool did not reach the expected number of active and waiting callers")
+}
+
+func mustAcquire(t *testing.T, p *Pool) func() {
+	t.Helper()
+	release, err := p.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	return release
+}
+
+func TestNewClampsSizes(t *testing.T) {
+	p := New("test", 0, -1)
+	release := mustAcquire(t, p)
+	if _, err := p.Acquire(context.Background(), nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Acquire on a full pool without a queue = %v, want ErrQueueFull", err)
+	}
+	release()
+	release()
+	if active, waiting := p.Stats(); active != 0 || waiting != 0 {
+		t.Fatalf("releasing twice left %d active and %d waiting", active, waiting)
+	}
+}
+
+func TestGoBoundsWorkers(t *testing.T) {
+	p := New("test", 3, 100)
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	unblock := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		err := p.Go(context.Background(), nil, func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			<-unblock
+			running.Add(-1)
+		})
+		if err != nil {
+			t.Fatalf("Go: %v", err)
+		}
+	}
+
+	waitFor(t, p, 3, 17)
+	eventually(t, func() bool { return running.Load() == 3 }, "the first three functions did not start")
+	close(unblock)
+	wg.Wait()
+	if peak.Load() != 3 {
+		t.Fatalf("%d functions ran at once, want 3", peak.Load())
+	}
+	waitFor(t, p, 0, 0)
+}
+
+func TestQueuePositionsAndOrder(t *testing.T) {
+	p := New("test", 1, 3)
+	release := mustAcquire(t, p)
+
+	var mu sync.Mutex
+	var positions []int
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		err := p.Go(context.Background(), func(position int) {
+			mu.Lock()
+			defer mu.Unlock()
+			positions = append(positions, position)
+		}, func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, i)
+		})
+		if err != nil {
+			t.Fatalf("Go: %v", err)
+		}
+		// Queue them one at a time so their places are known.
+		waitFor(t, p, 1, i)
+	}
+
+	eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(positions) == 3
+	}, "not every queued caller was told its position")
+	// The callbacks run concurrently, so only the set of positions is known.
+	slices.Sort(positions)
+	if !slices.Equal(positions, []int{1, 2, 3}) {
+		t.Errorf("queue positions = %v, want 1, 2 and 3", positions)
+	}
+
+	release()
+	wg.Wait()
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Fatalf("queued work ran in order %v, want first in, first out", order)
+	}
+}
+
+func TestNoCallbackWithoutWaiting(t *testing.T) {
+	p := New("test", 1, 1)
+	release, err := p.Acquire(context.Background(), func(int) {
+		t.Error("onQueued called for a caller that got a slot at once")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestRejectsWhenQueueFull(t *testing.T) {
+	p := New("test", 1, 2)
+	release := mustAcquire(t, p)
+
+	for i := 0; i < 2; i++ {
+		if err := p.Go(context.Background(), nil, func() {}); err != nil {
+			t.Fatalf("Go %d: %v", i, err)
+		}
+	}
+	queued := false
+	if _, err := p.Acquire(context.Background(), func(int) { queued = true }); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Acquire on a full queue = %v, want ErrQueueFull", err)
+	}
+	if err := p.Go(context.Background(), nil, func() { t.Error("rejected work ran") }); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Go on a full queue = %v, want ErrQueueFull", err)
+	}
+	if queued {
+		t.Error("onQueued called for rejected work")
+	}
+
+	release()
+	waitFor(t, p, 0, 0)
+}
+
+func TestCancelWhileWaiting(t *testing.T) {
+	p := New("test", 1, 2)
+	release := mustAcquire(t, p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(ctx, func(int) { cancel() })
+		errs <- err
+	}()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire with a cancelled context = %v, want context.Canceled", err)
+	}
+	if active, waiting := p.Stats(); active != 1 || waiting != 0 {
+		t.Fatalf("cancelled waiter left %d active and %d waiting", active, waiting)
+	}
+
+	// Work given up on never runs, and its place goes to the next caller.
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := p.Go(ctx, nil, func() { t.Error("cancelled work ran") }); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	waitFor(t, p, 1, 0)
+	release()
+	mustAcquire(t, p)()
+	waitFor(t, p, 0, 0)
+}
+
+// A slot handed to a waiter whose context ends at the same moment is passed
+// on, not lost.
+func TestCancelRacingHandover(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		p := New("test", 1, 2)
+		release := mustAcquire(t, p)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if release, err := p.Acquire(ctx, nil); err == nil {
+				release()
+			}
+		}()
+		waitFor(t, p, 1, 1)
+		go cancel()
+		release()
+		<-done
+
+		waitFor(t, p, 0, 0)
+	}
+}
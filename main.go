@@ -1,48 +1,194 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 	"video-script-bot/internal/ai"
 	"video-script-bot/internal/apikeys"
+	"video-script-bot/internal/audio"
 	"video-script-bot/internal/bot"
+	"video-script-bot/internal/breaker"
 	"video-script-bot/internal/config"
+	"video-script-bot/internal/hotreload"
 	"video-script-bot/internal/i18n"
-	"video-script-bot/internal/storage"
+	"video-script-bot/internal/proxy"
+	"video-script-bot/internal/state"
 )
 
 func main() {
 	cfg := config.LoadConfig()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	localizer := i18n.NewLocalizer(cfg.DefaultLang)
-	
-	db, err := storage.New(cfg.DatabasePath)
+
+	database, err := openDatabase(cfg, false)
 	if err != nil {
 		log.Fatalf("FATAL: Could not initialize database: %v", err)
 	}
+	db := state.NewManager(database, time.Duration(cfg.StateCacheTTLSeconds)*time.Second, time.Duration(cfg.StateFlushSeconds)*time.Second)
+	go db.Run(ctx)
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Warning: failed to close database: %v", err)
+		}
+	}()
+
+	proxyURLs := cfg.ProxyURLs
+	if len(proxyURLs) == 0 && cfg.ProxyURL != "" {
+		proxyURLs = []string{cfg.ProxyURL}
+	}
+	// The pool exists even without proxies, connecting directly, so proxies
+	// added to PROXY_URLS by a reload are used right away.
+	proxyPool := &proxy.Manager{}
+	if len(proxyURLs) > 0 {
+		proxyPool, err = proxy.NewManager(proxyURLs)
+		if err != nil {
+			log.Fatalf("FATAL: Could not initialize proxy manager: %v", err)
+		}
+	}
+	routes := proxy.Routes(cfg.ProxyRoutes)
+	routeManagers := make(map[string]*proxy.Manager)
+	proxyFor := func(provider string) *proxy.Manager {
+		manager, err := routes.ManagerFor(provider, proxyPool, routeManagers)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		return manager
+	}
 
-	geminiKeyManager, err := apikeys.NewManager(cfg.GeminiAPIKeys)
+	var keyManagers []*apikeys.KeyManager
+	geminiKeyManager, err := apikeys.NewManager(ai.ProviderGemini, cfg.GeminiAPIKeys)
 	if err != nil {
 		log.Printf("WARNING: Could not initialize Gemini Key Manager: %v. Gemini features will be disabled.", err)
+	} else {
+		keyManagers = append(keyManagers, geminiKeyManager)
 	}
 
-	elevenlabsKeyManager, err := apikeys.NewManager(cfg.ElevenLabsAPIKeys)
+	elevenlabsKeyManager, err := apikeys.NewManager(ai.ProviderElevenLabs, cfg.ElevenLabsAPIKeys)
 	if err != nil {
 		log.Printf("WARNING: Could not initialize ElevenLabs Key Manager: %v. TTS features will be disabled.", err)
+	} else {
+		keyManagers = append(keyManagers, elevenlabsKeyManager)
+	}
+
+	var scriptGenerator ai.ScriptGenerator
+	switch cfg.ScriptProvider {
+	case ai.ProviderGemini:
+		scriptGenerator, err = ai.NewGeminiService(geminiKeyManager, ai.GeminiOptions{
+			JSONOutput:            cfg.GeminiJSONOutput,
+			MaxValidationAttempts: cfg.GeminiMaxValidationAttempts,
+			Generation:            cfg.GeminiGeneration,
+			StyleOverrides:        cfg.GeminiStyleOverrides,
+			InlineLimitBytes:      int64(cfg.GeminiInlineLimitMB) * 1024 * 1024,
+			Endpoint:              cfg.GeminiAPIEndpoint,
+			Proxy:                 proxyFor(ai.ProviderGemini),
+		})
+		if err != nil {
+			log.Fatalf("FATAL: Could not initialize Gemini service: %v", err)
+		}
+	case ai.ProviderOpenAI:
+		openaiKeyManager, err := apikeys.NewManager(ai.ProviderOpenAI, cfg.OpenAIAPIKeys)
+		if err != nil {
+			log.Printf("No OpenAI-compatible API keys configured, requests will be sent without authentication.")
+		} else {
+			keyManagers = append(keyManagers, openaiKeyManager)
+		}
+		scriptGenerator = ai.NewOpenAIService(openaiKeyManager, ai.OpenAIOptions{
+			BaseURL:               cfg.OpenAIBaseURL,
+			Model:                 cfg.OpenAIModel,
+			VideoInput:            cfg.OpenAIVideoInput,
+			MaxUploadBytes:        int64(cfg.OpenAIMaxUploadMB) * 1024 * 1024,
+			JSONOutput:            cfg.GeminiJSONOutput,
+			MaxValidationAttempts: cfg.GeminiMaxValidationAttempts,
+			Proxy:                 proxyFor(ai.ProviderOpenAI),
+		})
+	default:
+		log.Fatalf("FATAL: Unknown SCRIPT_PROVIDER '%s'. Use 'gemini' or 'openai'.", cfg.ScriptProvider)
+	}
+	breakers := breaker.NewSet(breaker.Settings{
+		FailureThreshold: cfg.BreakerFailureThreshold,
+		OpenDuration:     time.Duration(cfg.BreakerOpenSeconds) * time.Second,
+	})
+	scriptGenerator = ai.GuardGenerator(scriptGenerator, breakers.Get(scriptGenerator.Name()))
+
+	reloader := hotreload.New(cfg.ReloadFile, time.Duration(cfg.ReloadIntervalSeconds)*time.Second)
+	for _, keyManager := range keyManagers {
+		keyManager.SetRecorder(db)
+		keyManager.SetBreaker(breakers.Settings())
+		reloader.Watch(strings.ToUpper(keyManager.Provider())+"_API_KEYS", keyManager)
 	}
+	go reloader.Run(ctx)
+	defer func() {
+		if err := scriptGenerator.Close(); err != nil {
+			log.Printf("Warning: failed to close script generator: %v", err)
+		}
+	}()
 
-	geminiService := ai.NewGeminiService(geminiKeyManager)
-	
-	elevenlabsService, err := ai.NewElevenLabsService(elevenlabsKeyManager, cfg.ElevenLabsModelID, cfg.ProxyURL)
+	voiceCatalog, err := ai.LoadVoiceCatalog(cfg.VoicesFile)
+	if err != nil {
+		log.Fatalf("FATAL: Could not load voices from %s: %v", cfg.VoicesFile, err)
+	}
+
+	elevenlabsService, err := ai.NewElevenLabsService(elevenlabsKeyManager, cfg.ElevenLabsModelID, proxyFor(ai.ProviderElevenLabs), ai.VoicesForProvider(voiceCatalog, ai.ProviderElevenLabs))
 	if err != nil {
 		log.Fatalf("FATAL: Could not initialize ElevenLabs service: %v", err)
 	}
+	synthesizers := []ai.SpeechSynthesizer{ai.GuardSynthesizer(elevenlabsService, breakers.Get(elevenlabsService.Name()))}
+
+	if cfg.LocalTTSEngine != "" {
+		localTTS, err := ai.NewLocalTTSService(cfg.LocalTTSEngine, cfg.LocalTTSBinary, cfg.LocalTTSModelDir, ai.VoicesForProvider(voiceCatalog, cfg.LocalTTSEngine))
+		if err != nil {
+			log.Fatalf("FATAL: Could not initialize local TTS engine: %v", err)
+		}
+		if localTTS != nil {
+			synthesizers = append(synthesizers, ai.GuardSynthesizer(localTTS, breakers.Get(localTTS.Name())))
+		}
+	}
+	speech := ai.NewSpeechRegistry(synthesizers...)
+
+	mixer := audio.NewMixer(cfg.FFmpegPath, cfg.FFprobePath, audio.ParseOverrunPolicy(cfg.AudioOverrunPolicy))
 
-	telegramBot, err := bot.New(cfg, localizer, db, geminiService, elevenlabsService)
+	// Telegram long polling waits up to a minute for updates, so its client
+	// has no timeout of its own.
+	httpClients := bot.HTTPClients{
+		Telegram:  proxy.HTTPClient(proxyFor("telegram"), 0),
+		Downloads: proxy.HTTPClient(proxyFor("downloads"), 0),
+	}
+
+	reloader.Watch("PROXY_URLS", proxyPool)
+	routeManagers[proxy.RoutePool] = proxyPool
+	if cfg.ProxyHealthIntervalSeconds > 0 {
+		checker := proxy.HealthChecker{
+			ProbeURL: cfg.ProxyHealthURL,
+			Interval: time.Duration(cfg.ProxyHealthIntervalSeconds) * time.Second,
+			Timeout:  time.Duration(cfg.ProxyHealthTimeoutSeconds) * time.Second,
+		}
+		for _, manager := range routeManagers {
+			go checker.Run(ctx, manager)
+		}
+	}
+
+	telegramBot, err := bot.New(cfg, localizer, db, scriptGenerator, speech, mixer, keyManagers, reloader, breakers, httpClients)
 	if err != nil {
 		log.Fatalf("FATAL: Could not initialize bot: %v", err)
 	}
 
 	log.Println("Bot initialized successfully with API Key Rotation. Starting to listen for updates...")
 
-	telegramBot.Start()
+	if err := telegramBot.Start(ctx); err != nil {
+		log.Fatalf("FATAL: Could not receive Telegram updates: %v", err)
+	}
+	log.Println("Bot stopped.")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"video-script-bot/internal/config"
+)
+
+const migrateUsage = "usage: video-script-bot migrate status|up|down"
+
+// runMigrate handles the migrate subcommand: status lists the migrations, up
+// applies the pending ones and down rolls back the latest one.
+func runMigrate(cfg *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	db, err := openDatabase(cfg, true)
+	if err != nil {
+		log.Fatalf("FATAL: Could not open database: %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		version, err := db.SchemaVersion()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		fmt.Printf("Schema version %d, latest %d.\n", version, db.LatestSchemaVersion())
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-28s %s\n", status.Version, status.Name, applied)
+		}
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s.\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date.")
+		}
+	case "down":
+		migration, err := db.MigrateDown()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		if migration == nil {
+			fmt.Println("No migrations to roll back.")
+			return
+		}
+		fmt.Printf("Rolled back %04d_%s.\n", migration.Version, migration.Name)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}